@@ -23,3 +23,13 @@ type AuthContext struct {
 	UserId          int
 	AllowedFeatures []productfeature.ProductFeature
 }
+
+// HasFeature is for handlers that allow more depending on the user's features than the route's middleware requires
+func (c *AuthContext) HasFeature(feature productfeature.ProductFeature) bool {
+	for _, allowedFeature := range c.AllowedFeatures {
+		if allowedFeature == feature {
+			return true
+		}
+	}
+	return false
+}
@@ -69,6 +69,11 @@ func AuthenticatedSessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 }
 
 func RequireProductFeatureMiddleware(feature productfeature.ProductFeature) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return RequireAnyProductFeatureMiddleware(feature)
+}
+
+// RequireAnyProductFeatureMiddleware allows users that have at least one of the features
+func RequireAnyProductFeatureMiddleware(features ...productfeature.ProductFeature) func(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authContext := c.(*AuthContext)
@@ -77,8 +82,10 @@ func RequireProductFeatureMiddleware(feature productfeature.ProductFeature) func
 				return echo.ErrInternalServerError
 			}
 			for _, allowedFeature := range allowedFeatures {
-				if feature == allowedFeature {
-					return next(authContext)
+				for _, feature := range features {
+					if feature == allowedFeature {
+						return next(authContext)
+					}
 				}
 			}
 			return echo.ErrForbidden
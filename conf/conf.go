@@ -16,14 +16,17 @@ package conf
 import "os"
 
 type secrets struct {
-	SessionSecret      string
-	RedisConn          string
-	PostgresConn       string
-	SkipSchemaCreation string
-	SendgridKey        string
-	SiteUrl            string
-	SitePort           string
-	DebugSql           string
+	SessionSecret              string
+	RedisConn                  string
+	PostgresConn               string
+	SkipSchemaCreation         string
+	SendgridKey                string
+	SiteUrl                    string
+	SitePort                   string
+	DebugSql                   string
+	TrashRetentionDays         string
+	ThemesDirectory            string
+	OrphanedMediaRetentionDays string
 }
 
 var Secrets secrets
@@ -32,17 +35,23 @@ const (
 	SessionKey       = "tp_session"
 	SessionUserIdKey = "user_id"
 	BcryptCost       = 10
+
+	DefaultTrashRetentionDays = 30
+	DefaultThemesDirectory    = "themes"
 )
 
 func InitSecrets() {
 	Secrets = secrets{
-		SessionSecret:      os.Getenv("TP_SESSION_SECRET"),
-		RedisConn:          os.Getenv("TP_REDIS_CONN"),
-		PostgresConn:       os.Getenv("TP_POSTGRES_CONN"),
-		SkipSchemaCreation: os.Getenv("TP_SKIP_SCHEMA_CREATION"),
-		SendgridKey:        os.Getenv("TP_SENDGRID_KEY"),
-		SiteUrl:            os.Getenv("TP_SITE_URL"),
-		SitePort:           os.Getenv("TP_SITE_PORT"),
-		DebugSql:           os.Getenv("TP_DEBUG_SQL"),
+		SessionSecret:              os.Getenv("TP_SESSION_SECRET"),
+		RedisConn:                  os.Getenv("TP_REDIS_CONN"),
+		PostgresConn:               os.Getenv("TP_POSTGRES_CONN"),
+		SkipSchemaCreation:         os.Getenv("TP_SKIP_SCHEMA_CREATION"),
+		SendgridKey:                os.Getenv("TP_SENDGRID_KEY"),
+		SiteUrl:                    os.Getenv("TP_SITE_URL"),
+		SitePort:                   os.Getenv("TP_SITE_PORT"),
+		DebugSql:                   os.Getenv("TP_DEBUG_SQL"),
+		TrashRetentionDays:         os.Getenv("TP_TRASH_RETENTION_DAYS"),
+		ThemesDirectory:            os.Getenv("TP_THEMES_DIR"),
+		OrphanedMediaRetentionDays: os.Getenv("TP_ORPHANED_MEDIA_RETENTION_DAYS"),
 	}
 }
@@ -0,0 +1,25 @@
+/*
+Package imageformat is for the enum of formats resized images are saved in
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package imageformat
+
+type ImageFormat int
+
+const (
+	// Original keeps resized images in the format they were uploaded in where it can be written
+	Original ImageFormat = iota + 1
+	Jpeg
+	Png
+	// Webp is lossless, so it suits graphics more than photos, see service/media/webp.go
+	Webp
+)
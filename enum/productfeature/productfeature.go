@@ -19,4 +19,10 @@ const (
 	ManageUsers ProductFeature = iota + 1
 	ManageSettings
 	AddEditContent
+	ManageRedirects
+	BreakEditLocks
+	ReviewContent
+	PublishContent
+	ManageMenus
+	ManageTaxonomy
 )
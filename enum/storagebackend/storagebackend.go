@@ -0,0 +1,23 @@
+/*
+Package storagebackend is for the enum of places media files can be stored
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package storagebackend
+
+type StorageBackend int
+
+const (
+	// Local keeps media in a directory on the server, which only works with a single Tinypress instance
+	Local StorageBackend = iota + 1
+	// S3 keeps media in a bucket of any S3-compatible service
+	S3
+)
@@ -0,0 +1,28 @@
+/*
+Package uploadrule is for the enum of checks an upload can fail
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package uploadrule
+
+type UploadRule int
+
+const (
+	// TooLarge is over the limit for every upload
+	TooLarge UploadRule = iota + 1
+	// TypeTooLarge is over the lower limit set for its type
+	TypeTooLarge
+	UnsupportedType
+	// Corrupt claims to be an image of a supported type by its first bytes but can't be read as one
+	Corrupt
+	// UnsafeSvg has scripts, event handlers or other content that could run in the browser
+	UnsafeSvg
+)
@@ -19,4 +19,6 @@ const (
 	Admin UserRole = iota + 1
 	Editor
 	User
+	Reviewer
+	Publisher
 )
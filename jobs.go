@@ -0,0 +1,78 @@
+/*
+Package main is for the entrypoint of the Tinypress application
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/conf"
+	"github.com/pgray64/tinypress/service/media"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/redirect"
+	"strconv"
+	"time"
+)
+
+// startBackgroundJobs runs periodic work inside the server process. Every job has to be safe to run from several
+// Tinypress instances against the same database at once.
+func startBackgroundJobs(e *echo.Echo) {
+	go runPeriodically(e, "scheduled publishing", 30*time.Second, page.ApplyDueSchedules)
+	go runPeriodically(e, "saving redirect hit counts", time.Minute, redirect.FlushHits)
+	// Picks up uploads whose resizing failed, and redoes every image after the image settings change
+	go runPeriodically(e, "regenerating image variants", time.Minute, media.RegenerateStaleVariants)
+
+	retentionDays := conf.DefaultTrashRetentionDays
+	if len(conf.Secrets.TrashRetentionDays) > 0 {
+		days, err := strconv.Atoi(conf.Secrets.TrashRetentionDays)
+		if err != nil || days < 0 {
+			e.Logger.Fatal("Invalid trash retention specified - it should be a number of days")
+		}
+		retentionDays = days
+	}
+	// A retention of 0 keeps trashed pages until they are purged by hand
+	if retentionDays > 0 {
+		go runPeriodically(e, "emptying trash", time.Hour, func() error {
+			return page.EmptyTrash(time.Now().AddDate(0, 0, -retentionDays))
+		})
+	}
+
+	orphanRetentionDays := 0
+	if len(conf.Secrets.OrphanedMediaRetentionDays) > 0 {
+		days, err := strconv.Atoi(conf.Secrets.OrphanedMediaRetentionDays)
+		if err != nil || days < 0 {
+			e.Logger.Fatal("Invalid orphaned media retention specified - it should be a number of days")
+		}
+		orphanRetentionDays = days
+	}
+	// Unused uploads are only tracked by default, and listed in the media library for deleting by hand
+	go runPeriodically(e, "tracking orphaned media", time.Hour, func() error {
+		if err := media.MarkOrphans(); err != nil {
+			return err
+		}
+		if orphanRetentionDays < 1 {
+			return nil
+		}
+		return media.DeleteOrphans(time.Now().AddDate(0, 0, -orphanRetentionDays))
+	})
+}
+
+func runPeriodically(e *echo.Echo, name string, interval time.Duration, job func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := job(); err != nil {
+			e.Logger.Error("Background job failed - ", name, ": ", err)
+		}
+		<-ticker.C
+	}
+}
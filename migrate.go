@@ -15,17 +15,64 @@ package main
 
 import (
 	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/service/block"
+	"github.com/pgray64/tinypress/service/editlock"
+	"github.com/pgray64/tinypress/service/media"
+	"github.com/pgray64/tinypress/service/menu"
 	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/redirect"
 	"github.com/pgray64/tinypress/service/settings"
+	"github.com/pgray64/tinypress/service/taxonomy"
 	"github.com/pgray64/tinypress/service/user"
 )
 
 func migrateDatabase() error {
-	return database.Database.AutoMigrate(
+	err := database.Database.AutoMigrate(
 		&settings.Settings{},
 		&user.User{},
 		&user.RoleMapping{},
 		&page.Page{},
 		&page.ContentRevision{},
+		&page.PageAlias{},
+		&page.PublishSchedule{},
+		&page.ReviewEvent{},
+		&page.SearchDocument{},
+		&block.Block{},
+		&block.BlockRevision{},
+		&redirect.Redirect{},
+		&menu.Menu{},
+		&menu.MenuItem{},
+		&taxonomy.Term{},
+		&taxonomy.PageTerm{},
+		&editlock.EditLock{},
+		&media.Media{},
+		&media.MediaVariant{},
+		&page.MediaReference{},
+		&block.BlockMediaReference{},
 	)
+	if err != nil {
+		return err
+	}
+	if err = user.BackfillReviewRoles(); err != nil {
+		return err
+	}
+	if err = page.BackfillReviewStates(); err != nil {
+		return err
+	}
+	if err = page.DeleteOrphanedReviewEvents(); err != nil {
+		return err
+	}
+	if err = page.BackfillPaths(); err != nil {
+		return err
+	}
+	if err = page.BackfillSlugs(); err != nil {
+		return err
+	}
+	if err = page.BackfillSearch(); err != nil {
+		return err
+	}
+	if err = page.BackfillMediaReferences(); err != nil {
+		return err
+	}
+	return block.BackfillMediaReferences()
 }
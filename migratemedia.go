@@ -0,0 +1,76 @@
+/*
+Package main is for the entrypoint of the Tinypress application
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/enum/storagebackend"
+	"github.com/pgray64/tinypress/service/media"
+	"github.com/pgray64/tinypress/service/settings"
+)
+
+// storageBackendNames are how backends are picked on the command line
+var storageBackendNames = map[string]storagebackend.StorageBackend{
+	"local": storagebackend.Local,
+	"s3":    storagebackend.S3,
+}
+
+// migrateMedia copies all media from one storage backend to another, both set up from the site settings, and can then
+// switch the site over to the new one. Run it as: tinypress migrate-media -from local -to s3 [-switch]
+func migrateMedia(e *echo.Echo, args []string) {
+	flags := flag.NewFlagSet("migrate-media", flag.ExitOnError)
+	fromName := flags.String("from", "local", "backend to copy media from: local or s3")
+	toName := flags.String("to", "s3", "backend to copy media to: local or s3")
+	switchOver := flags.Bool("switch", false, "use the new backend for the site once everything is copied")
+	_ = flags.Parse(args)
+
+	fromBackend, fromOk := storageBackendNames[*fromName]
+	toBackend, toOk := storageBackendNames[*toName]
+	if !fromOk || !toOk || fromBackend == toBackend {
+		e.Logger.Fatal("Pick two different storage backends, each either local or s3")
+	}
+
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		e.Logger.Fatal("Failed to load site settings - has the site been set up? ", err)
+	}
+	from, err := media.NewStorageFor(siteSettings, fromBackend)
+	if err != nil {
+		e.Logger.Fatal("Failed to set up ", *fromName, " storage: ", err)
+	}
+	to, err := media.NewStorageFor(siteSettings, toBackend)
+	if err != nil {
+		e.Logger.Fatal("Failed to set up ", *toName, " storage: ", err)
+	}
+	testSettings := *siteSettings
+	testSettings.StorageBackend = toBackend
+	if !media.TestStorage(&testSettings) {
+		e.Logger.Fatal(media.StorageTestFailedMessage)
+	}
+
+	result, err := media.CopyAll(from, to)
+	// Echo only logs errors, so report progress directly
+	fmt.Printf("Copied %d files, skipped %d already there and %d missing\n", result.Copied, result.Skipped, result.Missing)
+	if err != nil {
+		e.Logger.Fatal("Media migration failed: ", err)
+	}
+	if *switchOver {
+		if err = settings.UpdateStorageBackend(toBackend); err != nil {
+			e.Logger.Fatal("Failed to switch storage backend: ", err)
+		}
+		fmt.Printf("Switched the site over to %s storage\n", *toName)
+	}
+}
@@ -178,6 +178,7 @@ func DeleteUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusForbidden, "You can't delete the user you are logged in as")
 	}
 
+	// This is a soft delete, so pages and revisions keep showing who authored and published them
 	deleteRes := database.Database.Where(map[string]interface{}{"id": request.ID}).Delete(&user.User{})
 	if deleteRes.Error != nil {
 		return echo.ErrInternalServerError
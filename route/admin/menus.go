@@ -0,0 +1,234 @@
+/*
+Package admin is for routes related to admin actions
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package admin
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/enum/menuitemtype"
+	"github.com/pgray64/tinypress/service/menu"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type menuForm struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+type addMenuResponse struct {
+	MenuId int `json:"menuId"`
+}
+
+func AddMenu(c echo.Context) error {
+	formData := new(menuForm)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	newMenu := menu.Menu{
+		Name: strings.TrimSpace(formData.Name),
+	}
+	isDup, err := newMenu.Create()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "A menu with this name already exists")
+	}
+	return c.JSON(http.StatusOK, addMenuResponse{
+		MenuId: newMenu.ID,
+	})
+}
+
+type renameMenuForm struct {
+	ID int `json:"id" validate:"required,min=1"`
+	menuForm
+}
+
+func RenameMenu(c echo.Context) error {
+	formData := new(renameMenuForm)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	isDup, err := menu.RenameMenu(formData.ID, strings.TrimSpace(formData.Name))
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "A menu with this name already exists")
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type menuResultItem struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+type menuListResult struct {
+	MenuList []menuResultItem `json:"menuList"`
+}
+
+func ListMenus(c echo.Context) error {
+	menus, err := menu.ListMenus()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var menuResults = make([]menuResultItem, len(menus))
+	for i, row := range menus {
+		menuResults[i] = menuResultItem{
+			ID:        row.ID,
+			Name:      row.Name,
+			UpdatedAt: row.UpdatedAt,
+		}
+	}
+	return c.JSON(http.StatusOK, menuListResult{
+		MenuList: menuResults,
+	})
+}
+
+type menuIdRequest struct {
+	ID int `json:"id" validate:"required,min=1"`
+}
+
+// menuItemNode is used both to return the items of a menu and to save them
+type menuItemNode struct {
+	Label    string                    `json:"label" validate:"max=255"`
+	ItemType menuitemtype.MenuItemType `json:"itemType" validate:"required,min=1,max=3"`
+	PageId   *int                      `json:"pageId" validate:"omitempty,min=1"`
+	Url      string                    `json:"url" validate:"max=2048"`
+	Anchor   string                    `json:"anchor" validate:"max=255"`
+	Children []menuItemNode            `json:"children" validate:"dive"`
+}
+type getMenuResponse struct {
+	ID    int            `json:"id"`
+	Name  string         `json:"name"`
+	Items []menuItemNode `json:"items"`
+}
+
+func GetMenu(c echo.Context) error {
+	request := new(menuIdRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	row, err := menu.GetMenu(request.ID)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if row == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Menu does not exist")
+	}
+	items, err := menu.ListItems(row.ID)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	childItems := make(map[int][]menu.MenuItem)
+	var topItems []menu.MenuItem
+	for _, item := range items {
+		if item.ParentId == nil {
+			topItems = append(topItems, item)
+		} else {
+			childItems[*item.ParentId] = append(childItems[*item.ParentId], item)
+		}
+	}
+	var buildNodes func(items []menu.MenuItem) []menuItemNode
+	buildNodes = func(items []menu.MenuItem) []menuItemNode {
+		nodes := make([]menuItemNode, len(items))
+		for i, item := range items {
+			nodes[i] = menuItemNode{
+				Label:    item.Label,
+				ItemType: item.ItemType,
+				PageId:   item.PageId,
+				Url:      item.Url,
+				Anchor:   item.Anchor,
+				Children: buildNodes(childItems[item.ID]),
+			}
+		}
+		return nodes
+	}
+	return c.JSON(http.StatusOK, getMenuResponse{
+		ID:    row.ID,
+		Name:  row.Name,
+		Items: buildNodes(topItems),
+	})
+}
+
+type saveMenuItemsRequest struct {
+	ID    int            `json:"id" validate:"required,min=1"`
+	Items []menuItemNode `json:"items" validate:"dive"`
+}
+
+func toItemInputs(nodes []menuItemNode) []menu.ItemInput {
+	inputs := make([]menu.ItemInput, len(nodes))
+	for i, node := range nodes {
+		inputs[i] = menu.ItemInput{
+			Label:    node.Label,
+			ItemType: node.ItemType,
+			PageId:   node.PageId,
+			Url:      node.Url,
+			Anchor:   node.Anchor,
+			Children: toItemInputs(node.Children),
+		}
+	}
+	return inputs
+}
+
+func SaveMenuItems(c echo.Context) error {
+	request := new(saveMenuItemsRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	err := menu.ReplaceItems(request.ID, toItemInputs(request.Items))
+	switch {
+	case errors.Is(err, menu.ErrInvalidItem):
+		return echo.NewHTTPError(http.StatusBadRequest, "Menu items need an existing page, a URL or path with a label, or an anchor with a label")
+	case errors.Is(err, menu.ErrTooDeep):
+		return echo.NewHTTPError(http.StatusBadRequest, "Menu items can only be nested 3 levels deep")
+	case err != nil:
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+func DeleteMenu(c echo.Context) error {
+	request := new(menuIdRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	if err := menu.DeleteMenu(request.ID); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
@@ -0,0 +1,196 @@
+/*
+Package admin is for routes related to admin actions
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package admin
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/redirect"
+	"math"
+	"net/http"
+	"time"
+)
+
+type redirectForm struct {
+	SourcePath   string `json:"sourcePath" validate:"required,max=2048"`
+	TargetPath   string `json:"targetPath" validate:"max=2048"`
+	TargetPageId *int   `json:"targetPageId" validate:"omitempty,min=1"`
+	StatusCode   int    `json:"statusCode" validate:"required,oneof=301 302 410"`
+}
+type addRedirectResponse struct {
+	RedirectId int `json:"redirectId"`
+}
+
+func AddRedirect(c echo.Context) error {
+	formData := new(redirectForm)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	newRedirect := redirect.Redirect{
+		SourcePath:   formData.SourcePath,
+		TargetPath:   formData.TargetPath,
+		TargetPageId: formData.TargetPageId,
+		StatusCode:   formData.StatusCode,
+	}
+	isDup, err := newRedirect.Create()
+	if err != nil {
+		return redirectSaveError(err)
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "A redirect already exists for this path")
+	}
+	return c.JSON(http.StatusOK, addRedirectResponse{
+		RedirectId: newRedirect.ID,
+	})
+}
+
+type updateRedirectForm struct {
+	ID int `json:"id" validate:"required,min=1"`
+	redirectForm
+}
+
+func UpdateRedirect(c echo.Context) error {
+	formData := new(updateRedirectForm)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	updatedRedirect := redirect.Redirect{
+		ID:           formData.ID,
+		SourcePath:   formData.SourcePath,
+		TargetPath:   formData.TargetPath,
+		TargetPageId: formData.TargetPageId,
+		StatusCode:   formData.StatusCode,
+	}
+	isDup, err := updatedRedirect.Update()
+	if err != nil {
+		return redirectSaveError(err)
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "A redirect already exists for this path")
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+func redirectSaveError(err error) error {
+	switch {
+	case errors.Is(err, redirect.ErrInvalidTarget):
+		return echo.NewHTTPError(http.StatusBadRequest, "Redirect target must be an existing page, a path starting with / or an http(s) URL")
+	case errors.Is(err, redirect.ErrReservedPath):
+		return echo.NewHTTPError(http.StatusBadRequest, "Redirect source is used by the site itself and can't be redirected")
+	case errors.Is(err, redirect.ErrLoop):
+		return echo.NewHTTPError(http.StatusBadRequest, "Redirect would send visitors back to where they started")
+	case errors.Is(err, redirect.ErrChain):
+		return echo.NewHTTPError(http.StatusBadRequest, "Redirect would chain with another redirect - point both directly at the final target instead")
+	default:
+		return echo.ErrInternalServerError
+	}
+}
+
+type redirectResultItem struct {
+	ID           int       `json:"id"`
+	SourcePath   string    `json:"sourcePath"`
+	TargetPath   string    `json:"targetPath"`
+	TargetPageId *int      `json:"targetPageId"`
+	StatusCode   int       `json:"statusCode"`
+	HitCount     int64     `json:"hitCount"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+type redirectListResult struct {
+	RedirectList []redirectResultItem `json:"redirectList"`
+	PageCount    int64                `json:"pageCount"`
+}
+type listRedirectsRequest struct {
+	Page int `json:"page"`
+}
+
+const ListRedirectsPerPage = 25
+
+func toRedirectResultItem(row *redirect.Redirect) redirectResultItem {
+	return redirectResultItem{
+		ID:           row.ID,
+		SourcePath:   row.SourcePath,
+		TargetPath:   row.TargetPath,
+		TargetPageId: row.TargetPageId,
+		StatusCode:   row.StatusCode,
+		HitCount:     row.HitCount,
+		UpdatedAt:    row.UpdatedAt,
+	}
+}
+
+func ListRedirects(c echo.Context) error {
+	paging := new(listRedirectsRequest)
+	if err := c.Bind(paging); err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	redirects, totalCount, err := redirect.ListRedirects(paging.Page, ListRedirectsPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var redirectResults = make([]redirectResultItem, len(redirects))
+	for i := range redirects {
+		redirectResults[i] = toRedirectResultItem(&redirects[i])
+	}
+	return c.JSON(http.StatusOK, redirectListResult{
+		PageCount:    int64(math.Ceil(float64(totalCount) / float64(ListRedirectsPerPage))),
+		RedirectList: redirectResults,
+	})
+}
+
+type redirectIdRequest struct {
+	ID int `json:"id" validate:"required,min=1"`
+}
+
+func GetRedirect(c echo.Context) error {
+	request := new(redirectIdRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	row, err := redirect.GetRedirect(request.ID)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if row == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Redirect does not exist")
+	}
+	return c.JSON(http.StatusOK, toRedirectResultItem(row))
+}
+
+func DeleteRedirect(c echo.Context) error {
+	request := new(redirectIdRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	if err := redirect.DeleteRedirect(request.ID); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
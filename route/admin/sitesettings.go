@@ -14,16 +14,39 @@ You should have received a copy of the GNU General Public License along with Tin
 package admin
 
 import (
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/enum/imageformat"
+	"github.com/pgray64/tinypress/enum/storagebackend"
 	"github.com/pgray64/tinypress/service/media"
 	"github.com/pgray64/tinypress/service/settings"
 	"net/http"
 	"strings"
 )
 
+// migrateMediaNames are what the migrate-media command calls each storage backend
+var migrateMediaNames = map[storagebackend.StorageBackend]string{
+	storagebackend.Local: "local",
+	storagebackend.S3:    "s3",
+}
+
 type updateSettingsForm struct {
-	SiteName           string `json:"siteName" validate:"required,max=100"`
-	ImageDirectoryPath string `json:"imageDirectoryPath" validate:"required,max=255"`
+	SiteName string `json:"siteName" validate:"required,max=100"`
+	storageSettings
+	// S3SecretKey is never sent back, so leaving it empty keeps the current one
+	S3SecretKey string `json:"s3SecretKey" validate:"max=255"`
+}
+
+type storageSettings struct {
+	// StorageBackend can be left out for a local directory at setup, or to keep the current one when updating
+	StorageBackend     int    `json:"storageBackend" validate:"min=0,max=2"`
+	ImageDirectoryPath string `json:"imageDirectoryPath" validate:"required_unless=StorageBackend 2,max=255"`
+	S3Endpoint         string `json:"s3Endpoint" validate:"required_if=StorageBackend 2,max=255"`
+	S3Region           string `json:"s3Region" validate:"max=100"`
+	S3Bucket           string `json:"s3Bucket" validate:"required_if=StorageBackend 2,max=255"`
+	S3AccessKey        string `json:"s3AccessKey" validate:"max=255"`
+	S3UseSsl           bool   `json:"s3UseSsl"`
 }
 
 func UpdateGeneralSiteSettings(c echo.Context) error {
@@ -31,35 +54,67 @@ func UpdateGeneralSiteSettings(c echo.Context) error {
 	if err := c.Bind(formData); err != nil {
 		return echo.ErrInternalServerError
 	}
+	currentSettings, err := settings.GetSettings()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	// Filled in before validating, since which storage fields are required depends on it
+	if formData.StorageBackend == 0 {
+		formData.StorageBackend = int(currentSettings.StorageBackend)
+	}
 	if err := c.Validate(formData); err != nil {
 		return echo.ErrBadRequest
 	}
-
 	var newSettings = settings.Settings{
 		Active:             true,
 		SiteName:           strings.TrimSpace(formData.SiteName),
+		StorageBackend:     storagebackend.StorageBackend(formData.StorageBackend),
 		ImageDirectoryPath: strings.TrimRight(formData.ImageDirectoryPath, "/\\"),
+		S3Endpoint:         strings.TrimSpace(formData.S3Endpoint),
+		S3Region:           strings.TrimSpace(formData.S3Region),
+		S3Bucket:           strings.TrimSpace(formData.S3Bucket),
+		S3AccessKey:        formData.S3AccessKey,
+		S3SecretKey:        formData.S3SecretKey,
+		S3UseSsl:           formData.S3UseSsl,
+	}
+	if newSettings.S3SecretKey == "" {
+		newSettings.S3SecretKey = currentSettings.S3SecretKey
 	}
 
-	// Abort setup if image directory is unwritable
-	if !media.TestImageDirectoryPath(newSettings.ImageDirectoryPath) {
-		return echo.NewHTTPError(http.StatusBadRequest, "Image directory test failed - ensure it exists and allows read and write access")
+	// Existing media would be left behind in the old storage, so switching has to go through the migrate-media command
+	if newSettings.StorageBackend != currentSettings.StorageBackend {
+		hasMedia, err := media.HasMedia()
+		if err != nil {
+			return echo.ErrInternalServerError
+		}
+		if hasMedia {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf(
+				"Storage can't be switched while there is media, since it would be left behind. Save the new storage details without switching, then run \"tinypress migrate-media -from %s -to %s -switch\" instead.",
+				migrateMediaNames[currentSettings.StorageBackend], migrateMediaNames[newSettings.StorageBackend]))
+		}
+	}
+	// Abort if media storage is unusable
+	if !media.TestStorage(&newSettings) {
+		return echo.NewHTTPError(http.StatusBadRequest, media.StorageTestFailedMessage)
 	}
 
-	err := settings.UpdateGeneralSettings(&newSettings)
+	err = settings.UpdateGeneralSettings(&newSettings)
 	if err != nil {
 		return echo.ErrInternalServerError
 	}
+	media.ClearStorageCache()
 
 	return c.JSON(http.StatusOK, new(struct{}))
 }
 
 type siteSettingsResult struct {
-	SiteName           string `json:"siteName"`
-	ImageDirectoryPath string `json:"imageDirectoryPath"`
-	SmtpServer         string `json:"smtpServer"`
-	SmtpUsername       string `json:"smtpUsername"`
-	SmtpPort           string `json:"smtpPort"`
+	SiteName string `json:"siteName"`
+	storageSettings
+	SmtpServer   string `json:"smtpServer"`
+	SmtpUsername string `json:"smtpUsername"`
+	SmtpPort     string `json:"smtpPort"`
+	seoSettings
+	mediaSettings
 }
 
 func GetSiteSettings(c echo.Context) error {
@@ -68,11 +123,30 @@ func GetSiteSettings(c echo.Context) error {
 		return echo.ErrInternalServerError
 	}
 	settingsResult := siteSettingsResult{
-		SiteName:           siteSettings.SiteName,
-		ImageDirectoryPath: siteSettings.ImageDirectoryPath,
-		SmtpServer:         siteSettings.SmtpServer,
-		SmtpUsername:       siteSettings.SmtpUsername,
-		SmtpPort:           siteSettings.SmtpPort,
+		SiteName: siteSettings.SiteName,
+		storageSettings: storageSettings{
+			StorageBackend:     int(siteSettings.StorageBackend),
+			ImageDirectoryPath: siteSettings.ImageDirectoryPath,
+			S3Endpoint:         siteSettings.S3Endpoint,
+			S3Region:           siteSettings.S3Region,
+			S3Bucket:           siteSettings.S3Bucket,
+			S3AccessKey:        siteSettings.S3AccessKey,
+			S3UseSsl:           siteSettings.S3UseSsl,
+		},
+		SmtpServer:   siteSettings.SmtpServer,
+		SmtpUsername: siteSettings.SmtpUsername,
+		SmtpPort:     siteSettings.SmtpPort,
+		seoSettings: seoSettings{
+			RobotsTxt:               siteSettings.RobotsTxt,
+			DiscourageSearchEngines: siteSettings.DiscourageSearchEngines,
+		},
+		mediaSettings: mediaSettings{
+			ImageSizes:       siteSettings.ImageSizes,
+			ImageFormat:      int(siteSettings.ImageFormat),
+			ImageQuality:     siteSettings.ImageQuality,
+			MaxUploadKb:      siteSettings.MaxUploadKb,
+			UploadTypeLimits: siteSettings.UploadTypeLimits,
+		},
 	}
 	return c.JSON(http.StatusOK, settingsResult)
 }
@@ -108,3 +182,88 @@ func UpdateSmtpSettings(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, new(struct{}))
 }
+
+type seoSettings struct {
+	RobotsTxt               string `json:"robotsTxt" validate:"max=10000"`
+	DiscourageSearchEngines bool   `json:"discourageSearchEngines"`
+}
+
+func UpdateSeoSettings(c echo.Context) error {
+	formData := new(seoSettings)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	var newSettings = settings.Settings{
+		Active:                  true,
+		RobotsTxt:               strings.TrimSpace(formData.RobotsTxt),
+		DiscourageSearchEngines: formData.DiscourageSearchEngines,
+	}
+
+	err := settings.UpdateSeoSettings(&newSettings)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type mediaSettings struct {
+	ImageSizes   string `json:"imageSizes" validate:"max=255"`
+	ImageFormat  int    `json:"imageFormat" validate:"required,min=1,max=4"`
+	ImageQuality int    `json:"imageQuality" validate:"required,min=1,max=100"`
+	// MaxUploadKb and UploadTypeLimits are in kilobytes, the latter as comma-separated type=kb pairs
+	MaxUploadKb      int    `json:"maxUploadKb" validate:"required,min=1"`
+	UploadTypeLimits string `json:"uploadTypeLimits" validate:"max=255"`
+}
+
+// UpdateMediaSettings changes upload limits and how uploaded images are resized. Existing images are resized again in
+// the background.
+func UpdateMediaSettings(c echo.Context) error {
+	formData := new(mediaSettings)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	sizes, err := media.ParseSizes(formData.ImageSizes)
+	if errors.Is(err, media.ErrInvalidSizes) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Image sizes must be up to %d widths between %d and %d, separated by commas",
+			media.MaxVariantSizes, media.MinVariantWidth, media.MaxVariantWidth))
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if formData.MaxUploadKb > media.MaxUploadKb {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("The upload limit can be at most %d KB", media.MaxUploadKb))
+	}
+	typeLimits, err := media.ParseTypeLimits(formData.UploadTypeLimits)
+	if errors.Is(err, media.ErrInvalidTypeLimits) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Upload limits by type must be type=KB pairs separated by commas, with limits of up to %d KB for image types that can be uploaded",
+			media.MaxUploadKb))
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var newSettings = settings.Settings{
+		Active:           true,
+		MaxUploadKb:      formData.MaxUploadKb,
+		UploadTypeLimits: media.FormatTypeLimits(typeLimits),
+		ImageSizes:       media.FormatSizes(sizes),
+		ImageFormat:      imageformat.ImageFormat(formData.ImageFormat),
+		ImageQuality:     formData.ImageQuality,
+	}
+
+	err = settings.UpdateMediaSettings(&newSettings)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	return c.JSON(http.StatusOK, new(struct{}))
+}
@@ -0,0 +1,200 @@
+/*
+Package admin is for routes related to admin actions
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package admin
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/enum/termtype"
+	"github.com/pgray64/tinypress/service/taxonomy"
+	"net/http"
+	"time"
+)
+
+type termForm struct {
+	Name     string `json:"name" validate:"required,max=255"`
+	Slug     string `json:"slug" validate:"omitempty,slug"`
+	ParentId *int   `json:"parentId" validate:"omitempty,min=1"`
+}
+type addTermForm struct {
+	TermType termtype.TermType `json:"termType" validate:"required,min=1,max=2"`
+	termForm
+}
+type addTermResponse struct {
+	TermId int    `json:"termId"`
+	Path   string `json:"path"`
+}
+
+func AddTerm(c echo.Context) error {
+	formData := new(addTermForm)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	newTerm := taxonomy.Term{
+		TermType: formData.TermType,
+		Name:     formData.Name,
+		Slug:     formData.Slug,
+		ParentId: formData.ParentId,
+	}
+	isDup, err := newTerm.Create()
+	if err != nil {
+		return termError(err)
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "Slug is already in use for another term")
+	}
+	return c.JSON(http.StatusOK, addTermResponse{
+		TermId: newTerm.ID,
+		Path:   newTerm.Path,
+	})
+}
+
+type updateTermForm struct {
+	ID int `json:"id" validate:"required,min=1"`
+	termForm
+}
+
+func UpdateTerm(c echo.Context) error {
+	formData := new(updateTermForm)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	isDup, err := taxonomy.UpdateTerm(&taxonomy.Term{
+		ID:       formData.ID,
+		Name:     formData.Name,
+		Slug:     formData.Slug,
+		ParentId: formData.ParentId,
+	})
+	if err != nil {
+		return termError(err)
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "Slug is already in use for another term")
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+// termError maps taxonomy errors to responses for the admin app
+func termError(err error) error {
+	switch {
+	case errors.Is(err, taxonomy.ErrTermNotFound):
+		return echo.NewHTTPError(http.StatusBadRequest, "Term does not exist")
+	case errors.Is(err, taxonomy.ErrInvalidSlug):
+		return echo.NewHTTPError(http.StatusBadRequest, "A slug could not be made from the name, please enter one")
+	case errors.Is(err, taxonomy.ErrInvalidParent):
+		return echo.NewHTTPError(http.StatusBadRequest, "Only categories can have a parent, which must be another category that is not nested under this one")
+	case errors.Is(err, taxonomy.ErrPathTooLong):
+		return echo.NewHTTPError(http.StatusBadRequest, "Category is nested too deeply")
+	case errors.Is(err, taxonomy.ErrHasChildren):
+		return echo.NewHTTPError(http.StatusBadRequest, "Category has subcategories, which must be moved or deleted first")
+	default:
+		return echo.ErrInternalServerError
+	}
+}
+
+type listTermsRequest struct {
+	TermType termtype.TermType `json:"termType" validate:"required,min=1,max=2"`
+}
+type termResultItem struct {
+	ID        int               `json:"id"`
+	TermType  termtype.TermType `json:"termType"`
+	Name      string            `json:"name"`
+	Slug      string            `json:"slug"`
+	Path      string            `json:"path"`
+	ParentId  *int              `json:"parentId"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+type termListResult struct {
+	TermList []termResultItem `json:"termList"`
+}
+
+func toTermResultItem(term *taxonomy.Term) termResultItem {
+	return termResultItem{
+		ID:        term.ID,
+		TermType:  term.TermType,
+		Name:      term.Name,
+		Slug:      term.Slug,
+		Path:      term.Path,
+		ParentId:  term.ParentId,
+		UpdatedAt: term.UpdatedAt,
+	}
+}
+
+func ListTerms(c echo.Context) error {
+	request := new(listTermsRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	terms, err := taxonomy.ListTerms(request.TermType)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var termResults = make([]termResultItem, len(terms))
+	for i := range terms {
+		termResults[i] = toTermResultItem(&terms[i])
+	}
+	return c.JSON(http.StatusOK, termListResult{
+		TermList: termResults,
+	})
+}
+
+type termIdRequest struct {
+	ID int `json:"id" validate:"required,min=1"`
+}
+
+func GetTerm(c echo.Context) error {
+	request := new(termIdRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	term, err := taxonomy.GetTerm(request.ID)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if term == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Term does not exist")
+	}
+	return c.JSON(http.StatusOK, toTermResultItem(term))
+}
+
+func DeleteTerm(c echo.Context) error {
+	request := new(termIdRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	if err := taxonomy.DeleteTerm(request.ID); err != nil {
+		return termError(err)
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
@@ -0,0 +1,80 @@
+/*
+Package admin is for routes related to admin actions
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package admin
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/settings"
+	"github.com/pgray64/tinypress/service/theme"
+	"net/http"
+)
+
+type themeResultItem struct {
+	Name     string `json:"name"`
+	IsActive bool   `json:"isActive"`
+}
+type themeListResult struct {
+	ThemeList []themeResultItem `json:"themeList"`
+}
+
+func ListThemes(c echo.Context) error {
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	names, err := theme.ListThemes()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var themeResults = make([]themeResultItem, len(names))
+	for i, name := range names {
+		themeResults[i] = themeResultItem{
+			Name:     name,
+			IsActive: name == siteSettings.ActiveTheme,
+		}
+	}
+	return c.JSON(http.StatusOK, themeListResult{
+		ThemeList: themeResults,
+	})
+}
+
+type activateThemeRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+func ActivateTheme(c echo.Context) error {
+	request := new(activateThemeRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	err := theme.Activate(request.Name)
+	if errors.Is(err, theme.ErrThemeNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Theme does not exist")
+	}
+	var loadErr *theme.LoadError
+	if errors.As(err, &loadErr) {
+		// Template errors name the file and line, which is what the theme author needs to fix it
+		return echo.NewHTTPError(http.StatusBadRequest, "Theme could not be loaded: "+loadErr.Error())
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
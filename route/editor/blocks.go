@@ -0,0 +1,421 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/authentication"
+	"github.com/pgray64/tinypress/service/block"
+	"github.com/pgray64/tinypress/service/user"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const ListBlocksPerPage = 20
+
+type createBlockForm struct {
+	Name          string `json:"name" validate:"required,max=255"`
+	Handle        string `json:"handle" validate:"required,max=100"`
+	RenderedHtml  string `json:"renderedHtml"`
+	RenderedCss   string `json:"renderedCss"`
+	EditorContent string `json:"editorContent"`
+}
+type createBlockResponse struct {
+	BlockId     int    `json:"blockId"`
+	Placeholder string `json:"placeholder"`
+}
+
+func CreateBlock(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	formData := new(createBlockForm)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+	if !block.IsValidHandle(formData.Handle) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Handle can only contain lowercase letters, numbers and dashes")
+	}
+
+	newBlock := block.Block{
+		Name:        strings.TrimSpace(formData.Name),
+		Handle:      formData.Handle,
+		CreatedById: &authContext.UserId,
+	}
+	newDraft := block.BlockRevision{
+		RenderedHtml:  formData.RenderedHtml,
+		RenderedCss:   formData.RenderedCss,
+		EditorContent: formData.EditorContent,
+		CreatedById:   &authContext.UserId,
+	}
+	isDup, err := newBlock.Create(&newDraft)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "Handle is already in use for another block")
+	}
+	return c.JSON(http.StatusOK, createBlockResponse{
+		BlockId:     newBlock.ID,
+		Placeholder: block.Placeholder(newBlock.Handle),
+	})
+}
+
+type blockIdRequest struct {
+	BlockId int `json:"blockId" validate:"required,min=1"`
+}
+
+func bindBlockIdRequest(c echo.Context) (*blockIdRequest, error) {
+	request := new(blockIdRequest)
+	if err := c.Bind(request); err != nil {
+		return nil, echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return nil, echo.ErrBadRequest
+	}
+	return request, nil
+}
+
+type getBlockWithDraftResponse struct {
+	BlockId        int       `json:"blockId"`
+	DraftId        int       `json:"draftId"`
+	Name           string    `json:"name"`
+	Handle         string    `json:"handle"`
+	Placeholder    string    `json:"placeholder"`
+	IsPublished    bool      `json:"isPublished"`
+	DraftCreatedAt time.Time `json:"draftCreatedAt"`
+	DraftCreatedBy string    `json:"draftCreatedBy"`
+	EditorContent  string    `json:"editorContent"`
+}
+
+func GetBlockWithDraft(c echo.Context) error {
+	request, err := bindBlockIdRequest(c)
+	if err != nil {
+		return err
+	}
+	blockWithDraft, draft, err := block.GetBlockWithDraft(request.BlockId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if blockWithDraft == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Block does not exist")
+	}
+	names, err := user.GetDisplayNames(collectUserIds(draft.CreatedById))
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, getBlockWithDraftResponse{
+		BlockId:        blockWithDraft.ID,
+		DraftId:        draft.ID,
+		Name:           blockWithDraft.Name,
+		Handle:         blockWithDraft.Handle,
+		Placeholder:    block.Placeholder(blockWithDraft.Handle),
+		IsPublished:    blockWithDraft.PublishedRevisionId != nil,
+		DraftCreatedAt: draft.CreatedAt,
+		DraftCreatedBy: displayName(names, draft.CreatedById),
+		EditorContent:  draft.EditorContent,
+	})
+}
+
+type saveBlockDraftRequest struct {
+	BlockId       int    `json:"blockId" validate:"required,min=1"`
+	RenderedHtml  string `json:"renderedHtml"`
+	RenderedCss   string `json:"renderedCss"`
+	EditorContent string `json:"editorContent" validate:"required"`
+}
+
+func SaveBlockDraft(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	request := new(saveBlockDraftRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	draft := block.BlockRevision{
+		BlockId:       request.BlockId,
+		RenderedHtml:  request.RenderedHtml,
+		RenderedCss:   request.RenderedCss,
+		EditorContent: request.EditorContent,
+		CreatedById:   &authContext.UserId,
+	}
+	if err := block.SaveDraft(&draft); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, saveDraftResponse{
+		DraftId: draft.ID,
+	})
+}
+
+func PublishBlockDraft(c echo.Context) error {
+	request := new(publishDraftRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	if err := block.PublishDraft(request.DraftId); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type renameBlockRequest struct {
+	BlockId int    `json:"blockId" validate:"required,min=1"`
+	Name    string `json:"name" validate:"required,max=255"`
+}
+
+func RenameBlock(c echo.Context) error {
+	request := new(renameBlockRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	if err := block.RenameBlock(request.BlockId, strings.TrimSpace(request.Name)); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+func DeleteBlock(c echo.Context) error {
+	request, err := bindBlockIdRequest(c)
+	if err != nil {
+		return err
+	}
+	if err = block.DeleteBlock(request.BlockId); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type blockListItem struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Handle      string    `json:"handle"`
+	Placeholder string    `json:"placeholder"`
+	IsPublished bool      `json:"isPublished"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+type listBlocksResult struct {
+	BlockList []blockListItem `json:"blockList"`
+	PageCount int64           `json:"pageCount"`
+}
+
+func ListBlocks(c echo.Context) error {
+	paging := new(listPagesRequest)
+	if err := c.Bind(paging); err != nil {
+		return echo.ErrInternalServerError
+	}
+	blocks, totalCount, err := block.ListBlocks(paging.Page, ListBlocksPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var blockResults = make([]blockListItem, len(blocks))
+	for i, row := range blocks {
+		blockResults[i] = blockListItem{
+			ID:          row.ID,
+			Name:        row.Name,
+			Handle:      row.Handle,
+			Placeholder: block.Placeholder(row.Handle),
+			IsPublished: row.PublishedRevisionId != nil,
+			UpdatedAt:   row.UpdatedAt,
+		}
+	}
+	return c.JSON(http.StatusOK, listBlocksResult{
+		PageCount: int64(math.Ceil(float64(totalCount) / float64(ListBlocksPerPage))),
+		BlockList: blockResults,
+	})
+}
+
+type listBlockRevisionsRequest struct {
+	BlockId int `json:"blockId" validate:"required,min=1"`
+	Page    int `json:"page"`
+}
+type blockRevisionListItem struct {
+	ID          int       `json:"id"`
+	CreatedAt   time.Time `json:"createdAt"`
+	CreatedBy   string    `json:"createdBy"`
+	IsPublished bool      `json:"isPublished"`
+}
+type listBlockRevisionsResult struct {
+	RevisionList []blockRevisionListItem `json:"revisionList"`
+	PageCount    int64                   `json:"pageCount"`
+}
+
+func ListBlockRevisions(c echo.Context) error {
+	request := new(listBlockRevisionsRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	revisionBlock, err := block.GetBlock(request.BlockId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if revisionBlock == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Block does not exist")
+	}
+	revisions, totalCount, err := block.ListRevisions(request.BlockId, request.Page, ListRevisionsPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var userIds []int
+	for _, row := range revisions {
+		userIds = append(userIds, collectUserIds(row.CreatedById)...)
+	}
+	names, err := user.GetDisplayNames(userIds)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var revisionResults = make([]blockRevisionListItem, len(revisions))
+	for i, row := range revisions {
+		revisionResults[i] = blockRevisionListItem{
+			ID:          row.ID,
+			CreatedAt:   row.CreatedAt,
+			CreatedBy:   displayName(names, row.CreatedById),
+			IsPublished: revisionBlock.PublishedRevisionId != nil && *revisionBlock.PublishedRevisionId == row.ID,
+		}
+	}
+	return c.JSON(http.StatusOK, listBlockRevisionsResult{
+		PageCount:    int64(math.Ceil(float64(totalCount) / float64(ListRevisionsPerPage))),
+		RevisionList: revisionResults,
+	})
+}
+
+type getBlockRevisionResponse struct {
+	ID            int       `json:"id"`
+	BlockId       int       `json:"blockId"`
+	CreatedAt     time.Time `json:"createdAt"`
+	CreatedBy     string    `json:"createdBy"`
+	RenderedHtml  string    `json:"renderedHtml"`
+	RenderedCss   string    `json:"renderedCss"`
+	EditorContent string    `json:"editorContent"`
+}
+
+func GetBlockRevision(c echo.Context) error {
+	request := new(revisionRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	revision, err := block.GetRevision(request.RevisionId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if revision == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Revision does not exist")
+	}
+	names, err := user.GetDisplayNames(collectUserIds(revision.CreatedById))
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, getBlockRevisionResponse{
+		ID:            revision.ID,
+		BlockId:       revision.BlockId,
+		CreatedAt:     revision.CreatedAt,
+		CreatedBy:     displayName(names, revision.CreatedById),
+		RenderedHtml:  revision.RenderedHtml,
+		RenderedCss:   revision.RenderedCss,
+		EditorContent: revision.EditorContent,
+	})
+}
+
+func RestoreBlockRevision(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	request := new(revisionRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	revision, err := block.GetRevision(request.RevisionId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if revision == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Revision does not exist")
+	}
+	draft, err := block.RestoreRevision(revision.ID, authContext.UserId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, restoreRevisionResponse{
+		DraftId: draft.ID,
+	})
+}
+
+type blockUsageItem struct {
+	PageId      int    `json:"pageId"`
+	Title       string `json:"title"`
+	Path        string `json:"path"`
+	InPublished bool   `json:"inPublished"`
+	InDraft     bool   `json:"inDraft"`
+}
+type whereUsedResult struct {
+	PageList []blockUsageItem `json:"pageList"`
+}
+
+// WhereUsed lists the pages that would change if the block was published or deleted
+func WhereUsed(c echo.Context) error {
+	request, err := bindBlockIdRequest(c)
+	if err != nil {
+		return err
+	}
+	usedBlock, err := block.GetBlock(request.BlockId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if usedBlock == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Block does not exist")
+	}
+	usages, err := block.WhereUsed(usedBlock.Handle)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var usageResults = make([]blockUsageItem, len(usages))
+	for i, usage := range usages {
+		usageResults[i] = blockUsageItem{
+			PageId:      usage.Page.ID,
+			Title:       usage.Page.Title,
+			Path:        usage.Page.Path,
+			InPublished: usage.InPublished,
+			InDraft:     usage.InDraft,
+		}
+	}
+	return c.JSON(http.StatusOK, whereUsedResult{
+		PageList: usageResults,
+	})
+}
@@ -0,0 +1,133 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/authentication"
+	"github.com/pgray64/tinypress/service/editlock"
+	"github.com/pgray64/tinypress/service/user"
+	"net/http"
+	"time"
+)
+
+type editLockResult struct {
+	Acquired  bool      `json:"acquired"`
+	LockedBy  string    `json:"lockedBy"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func acquireEditLock(c echo.Context) (*editLockResult, error) {
+	authContext := c.(*authentication.AuthContext)
+	request, err := bindPageIdRequest(c)
+	if err != nil {
+		return nil, err
+	}
+
+	holder, err := editlock.Acquire(request.PageId, authContext.UserId)
+	if err != nil {
+		return nil, echo.ErrInternalServerError
+	}
+	if holder == nil {
+		return &editLockResult{Acquired: true, ExpiresAt: time.Now().Add(editlock.Ttl)}, nil
+	}
+	return heldBy(holder)
+}
+
+// heldBy reports who holds a lock that the user couldn't get, if anyone
+func heldBy(holder *editlock.Lock) (*editLockResult, error) {
+	if holder == nil {
+		return &editLockResult{}, nil
+	}
+	names, err := user.GetDisplayNames([]int{holder.UserId})
+	if err != nil {
+		return nil, echo.ErrInternalServerError
+	}
+	return &editLockResult{LockedBy: names[holder.UserId], ExpiresAt: holder.ExpiresAt}, nil
+}
+
+// checkEditLock stops the user changing a page while someone else has it open in the editor
+func checkEditLock(c echo.Context, pageId int) error {
+	authContext := c.(*authentication.AuthContext)
+	holder, err := editlock.GetLock(pageId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if holder == nil || holder.UserId == authContext.UserId {
+		return nil
+	}
+	result, err := heldBy(holder)
+	if err != nil {
+		return err
+	}
+	return echo.NewHTTPError(http.StatusConflict, result)
+}
+
+// AcquireEditLock is called when opening a page in the editor, and reports who has it open if it is already locked
+func AcquireEditLock(c echo.Context) error {
+	result, err := acquireEditLock(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// HeartbeatEditLock renews the lock while the page stays open in the editor. Once the lock has expired or been broken
+// the editor has to acquire it again, so that breaking a lock can't be undone by the old holder's next heartbeat.
+func HeartbeatEditLock(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	request, err := bindPageIdRequest(c)
+	if err != nil {
+		return err
+	}
+	renewed, err := editlock.Renew(request.PageId, authContext.UserId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if renewed {
+		return c.JSON(http.StatusOK, editLockResult{Acquired: true, ExpiresAt: time.Now().Add(editlock.Ttl)})
+	}
+	holder, err := editlock.GetLock(request.PageId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	result, err := heldBy(holder)
+	if err != nil {
+		return err
+	}
+	return echo.NewHTTPError(http.StatusConflict, result)
+}
+
+func ReleaseEditLock(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	request, err := bindPageIdRequest(c)
+	if err != nil {
+		return err
+	}
+	if err = editlock.Release(request.PageId, authContext.UserId); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+func BreakEditLock(c echo.Context) error {
+	request, err := bindPageIdRequest(c)
+	if err != nil {
+		return err
+	}
+	if err = editlock.Break(request.PageId); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
@@ -14,8 +14,16 @@ You should have received a copy of the GNU General Public License along with Tin
 package editor
 
 import (
+	"errors"
 	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/authentication"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/pagetype"
+	"github.com/pgray64/tinypress/enum/reviewstate"
+	"github.com/pgray64/tinypress/service/editlock"
 	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/taxonomy"
+	"github.com/pgray64/tinypress/service/user"
 	"math"
 	"net/http"
 	"strings"
@@ -24,15 +32,28 @@ import (
 
 type createPageForm struct {
 	Title         string `json:"title" validate:"required,max=255"`
+	Slug          string `json:"slug" validate:"omitempty,slug"`
+	ParentId      int    `json:"parentId" validate:"min=0"`
 	RenderedHtml  string `json:"renderedHtml"`
 	RenderedCss   string `json:"renderedCss"`
 	EditorContent string `json:"editorContent"`
 }
 type createPageResponse struct {
-	PageId int `json:"pageId"`
+	PageId int    `json:"pageId"`
+	Slug   string `json:"slug"`
+	Path   string `json:"path"`
+}
+
+// optionalPageId turns the 0 used by requests for "no page" into a nil ID
+func optionalPageId(pageId int) *int {
+	if pageId < 1 {
+		return nil
+	}
+	return &pageId
 }
 
 func CreatePage(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
 	formData := new(createPageForm)
 	if err := c.Bind(formData); err != nil {
 		return echo.ErrInternalServerError
@@ -41,23 +62,38 @@ func CreatePage(c echo.Context) error {
 		return echo.ErrBadRequest
 	}
 	newPage := page.Page{
-		Title: strings.TrimSpace(formData.Title),
+		Title:       strings.TrimSpace(formData.Title),
+		Slug:        formData.Slug,
+		ParentId:    optionalPageId(formData.ParentId),
+		CreatedById: &authContext.UserId,
 	}
 	newDraft := page.ContentRevision{
 		PageId:        newPage.ID,
 		RenderedHtml:  formData.RenderedHtml,
 		RenderedCss:   formData.RenderedCss,
 		EditorContent: formData.EditorContent,
+		CreatedById:   &authContext.UserId,
 	}
 	newPageId, isDup, err := newPage.Create(&newDraft)
+	if errors.Is(err, page.ErrParentNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Parent page does not exist")
+	}
+	if errors.Is(err, page.ErrPostNesting) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Posts can't be used as parent pages")
+	}
+	if errors.Is(err, page.ErrPathTooLong) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Page is nested too deeply")
+	}
 	if err != nil {
 		return echo.ErrInternalServerError
 	}
 	if isDup {
-		return echo.NewHTTPError(http.StatusBadRequest, "Title is already in use for another page")
+		return echo.NewHTTPError(http.StatusBadRequest, "Slug is already in use for another page")
 	}
 	return c.JSON(http.StatusOK, createPageResponse{
 		PageId: newPageId,
+		Slug:   newPage.Slug,
+		Path:   newPage.Path,
 	})
 }
 
@@ -65,11 +101,53 @@ type getPageWithDraftRequest struct {
 	PageId int `json:"pageId" validate:"required,min=1"`
 }
 type getPageWithDraftResponse struct {
-	PageId         int       `json:"pageId"`
-	PageTitle      string    `json:"pageTitle"`
-	PageCreatedAt  time.Time `json:"pageCreatedAt"`
-	DraftCreatedAt time.Time `json:"draftCreatedAt"`
-	EditorContent  string    `json:"editorContent"`
+	PageId         int                     `json:"pageId"`
+	DraftId        int                     `json:"draftId"`
+	ReviewState    reviewstate.ReviewState `json:"reviewState"`
+	PageTitle      string                  `json:"pageTitle"`
+	PageSlug       string                  `json:"pageSlug"`
+	PagePath       string                  `json:"pagePath"`
+	ParentId       *int                    `json:"parentId"`
+	PageCreatedAt  time.Time               `json:"pageCreatedAt"`
+	PageCreatedBy  string                  `json:"pageCreatedBy"`
+	PublishedAt    *time.Time              `json:"publishedAt"`
+	PublishedBy    string                  `json:"publishedBy"`
+	DraftCreatedAt time.Time               `json:"draftCreatedAt"`
+	DraftCreatedBy string                  `json:"draftCreatedBy"`
+	EditorContent  string                  `json:"editorContent"`
+	LockedBy       string                  `json:"lockedBy"`
+	LockExpiresAt  *time.Time              `json:"lockExpiresAt"`
+	TermIds        []int                   `json:"termIds"`
+	pageMetadata
+}
+
+type pageMetadata struct {
+	MetaDescription string `json:"metaDescription" validate:"max=500"`
+	CanonicalUrl    string `json:"canonicalUrl" validate:"omitempty,url,max=2048"`
+	OgTitle         string `json:"ogTitle" validate:"max=255"`
+	OgDescription   string `json:"ogDescription" validate:"max=500"`
+	OgImage         string `json:"ogImage" validate:"max=2048"`
+	NoIndex         bool   `json:"noIndex"`
+	Layout          string `json:"layout" validate:"max=100"`
+}
+
+// displayName returns the name of an author, or an empty string for content from before authors were recorded
+func displayName(names map[int]string, userId *int) string {
+	if userId == nil {
+		return ""
+	}
+	return names[*userId]
+}
+
+// collectUserIds gathers the recorded user IDs for a display name lookup
+func collectUserIds(userIds ...*int) []int {
+	var ids = make([]int, 0, len(userIds))
+	for _, userId := range userIds {
+		if userId != nil {
+			ids = append(ids, *userId)
+		}
+	}
+	return ids
 }
 
 func GetPageWithDraft(c echo.Context) error {
@@ -81,20 +159,74 @@ func GetPageWithDraft(c echo.Context) error {
 		return echo.ErrBadRequest
 	}
 
-	pageWithDraft, draft, err := page.GetPageWithDraft(request.PageId)
+	response, _, err := loadPageWithDraft(request.PageId)
 	if err != nil {
 		return err
 	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// loadPageWithDraft builds the editor view of a page or post, which is shared by the page and post editors
+func loadPageWithDraft(pageId int) (*getPageWithDraftResponse, *page.Page, error) {
+	pageWithDraft, draft, err := page.GetPageWithDraft(pageId)
+	if err != nil {
+		return nil, nil, echo.ErrInternalServerError
+	}
 	if pageWithDraft == nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Page does not exist")
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Page does not exist")
+	}
+	lock, err := editlock.GetLock(pageWithDraft.ID)
+	if err != nil {
+		return nil, nil, echo.ErrInternalServerError
 	}
-	return c.JSON(http.StatusOK, getPageWithDraftResponse{
+	userIds := collectUserIds(pageWithDraft.CreatedById, pageWithDraft.PublishedById, draft.CreatedById)
+	var lockedBy *int
+	var lockExpiresAt *time.Time
+	if lock != nil {
+		lockedBy = &lock.UserId
+		lockExpiresAt = &lock.ExpiresAt
+		userIds = append(userIds, lock.UserId)
+	}
+	names, err := user.GetDisplayNames(userIds)
+	if err != nil {
+		return nil, nil, echo.ErrInternalServerError
+	}
+	terms, err := taxonomy.ListPageTerms(pageWithDraft.ID)
+	if err != nil {
+		return nil, nil, echo.ErrInternalServerError
+	}
+	var termIds = make([]int, len(terms))
+	for i, term := range terms {
+		termIds[i] = term.ID
+	}
+	return &getPageWithDraftResponse{
 		PageId:         pageWithDraft.ID,
+		DraftId:        draft.ID,
+		ReviewState:    draft.ReviewState,
 		PageCreatedAt:  pageWithDraft.CreatedAt,
+		PageCreatedBy:  displayName(names, pageWithDraft.CreatedById),
+		PublishedAt:    pageWithDraft.PublishedAt,
+		PublishedBy:    displayName(names, pageWithDraft.PublishedById),
 		DraftCreatedAt: draft.CreatedAt,
+		DraftCreatedBy: displayName(names, draft.CreatedById),
 		EditorContent:  draft.EditorContent,
+		LockedBy:       displayName(names, lockedBy),
+		LockExpiresAt:  lockExpiresAt,
 		PageTitle:      pageWithDraft.Title,
-	})
+		PageSlug:       pageWithDraft.Slug,
+		PagePath:       pageWithDraft.Path,
+		ParentId:       pageWithDraft.ParentId,
+		TermIds:        termIds,
+		pageMetadata: pageMetadata{
+			MetaDescription: pageWithDraft.MetaDescription,
+			CanonicalUrl:    pageWithDraft.CanonicalUrl,
+			OgTitle:         pageWithDraft.OgTitle,
+			OgDescription:   pageWithDraft.OgDescription,
+			OgImage:         pageWithDraft.OgImage,
+			NoIndex:         pageWithDraft.NoIndex,
+			Layout:          pageWithDraft.Layout,
+		},
+	}, pageWithDraft, nil
 }
 
 type saveDraftRequest struct {
@@ -102,9 +234,26 @@ type saveDraftRequest struct {
 	RenderedHtml  string `json:"renderedHtml"`
 	RenderedCss   string `json:"renderedCss"`
 	EditorContent string `json:"editorContent" validate:"required"`
+	// BaseRevisionId is the draft the edit started from, and Force skips checking that it is still the latest
+	BaseRevisionId int  `json:"baseRevisionId" validate:"min=0"`
+	Force          bool `json:"force"`
+}
+type saveDraftResponse struct {
+	DraftId int `json:"draftId"`
+}
+type draftConflictResponse struct {
+	Message     string              `json:"message"`
+	LatestDraft draftConflictDetail `json:"latestDraft"`
+}
+type draftConflictDetail struct {
+	DraftId       int       `json:"draftId"`
+	CreatedAt     time.Time `json:"createdAt"`
+	CreatedBy     string    `json:"createdBy"`
+	EditorContent string    `json:"editorContent"`
 }
 
 func SaveDraft(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
 	request := new(saveDraftRequest)
 	if err := c.Bind(request); err != nil {
 		return echo.ErrInternalServerError
@@ -112,24 +261,54 @@ func SaveDraft(c echo.Context) error {
 	if err := c.Validate(request); err != nil {
 		return echo.ErrBadRequest
 	}
+	if !request.Force && request.BaseRevisionId < 1 {
+		return echo.ErrBadRequest
+	}
+	if err := checkEditLock(c, request.PageId); err != nil {
+		return err
+	}
 	draft := page.ContentRevision{
 		PageId:        request.PageId,
 		RenderedHtml:  request.RenderedHtml,
 		RenderedCss:   request.RenderedCss,
 		EditorContent: request.EditorContent,
+		CreatedById:   &authContext.UserId,
 	}
-	err := page.SaveDraft(&draft)
-	if err != nil {
-		return echo.ErrInternalServerError
+	if request.Force {
+		if err := page.SaveDraft(&draft); err != nil {
+			return echo.ErrInternalServerError
+		}
+	} else {
+		newer, err := page.SaveDraftOnto(&draft, request.BaseRevisionId)
+		if err != nil {
+			return echo.ErrInternalServerError
+		}
+		if newer != nil {
+			names, err := user.GetDisplayNames(collectUserIds(newer.CreatedById))
+			if err != nil {
+				return echo.ErrInternalServerError
+			}
+			return echo.NewHTTPError(http.StatusConflict, draftConflictResponse{
+				Message: "Someone else saved this page while you were editing it",
+				LatestDraft: draftConflictDetail{
+					DraftId:       newer.ID,
+					CreatedAt:     newer.CreatedAt,
+					CreatedBy:     displayName(names, newer.CreatedById),
+					EditorContent: newer.EditorContent,
+				},
+			})
+		}
 	}
 
 	// Saving draft doesn't touch Page table, so need to manually updated "updatedAt"
-	err = page.UpdateRecentlyEditedPage(request.PageId)
+	err := page.UpdateRecentlyEditedPage(request.PageId)
 	if err != nil {
 		return echo.ErrInternalServerError
 	}
 
-	return c.JSON(http.StatusOK, new(struct{}))
+	return c.JSON(http.StatusOK, saveDraftResponse{
+		DraftId: draft.ID,
+	})
 }
 
 type publishDraftRequest struct {
@@ -137,6 +316,7 @@ type publishDraftRequest struct {
 }
 
 func PublishDraft(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
 	request := new(publishDraftRequest)
 	if err := c.Bind(request); err != nil {
 		return echo.ErrInternalServerError
@@ -145,18 +325,122 @@ func PublishDraft(c echo.Context) error {
 		return echo.ErrBadRequest
 	}
 
-	err := page.PublishDraft(request.DraftId)
+	draft, err := page.GetRevision(request.DraftId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if draft == nil {
+		return echo.ErrBadRequest
+	}
+	if err = checkEditLock(c, draft.PageId); err != nil {
+		return err
+	}
+
+	err = page.PublishDraft(request.DraftId, authContext.UserId)
+	if errors.Is(err, page.ErrInvalidTransition) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Only approved drafts can be published")
+	}
 	if err != nil {
 		return echo.ErrInternalServerError
 	}
 	return c.JSON(http.StatusOK, new(struct{}))
 }
 
+type updateSlugRequest struct {
+	PageId int    `json:"pageId" validate:"required,min=1"`
+	Slug   string `json:"slug" validate:"required,slug"`
+}
+
+func UpdateSlug(c echo.Context) error {
+	request := new(updateSlugRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	if err := checkEditLock(c, request.PageId); err != nil {
+		return err
+	}
+
+	isDup, err := page.UpdateSlug(database.Database, request.PageId, request.Slug)
+	if errors.Is(err, page.ErrPathTooLong) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Slug makes the address of a subpage too long")
+	}
+	if errors.Is(err, page.ErrArchiveSlug) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Post slugs can't be just a number")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "Slug is already in use for another page")
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type updateMetadataRequest struct {
+	PageId int    `json:"pageId" validate:"required,min=1"`
+	Title  string `json:"title" validate:"required,max=255"`
+	Slug   string `json:"slug" validate:"required,slug"`
+	pageMetadata
+}
+
+func UpdateMetadata(c echo.Context) error {
+	request := new(updateMetadataRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	if err := checkEditLock(c, request.PageId); err != nil {
+		return err
+	}
+
+	isDup, err := page.UpdateMetadata(&page.Page{
+		ID:              request.PageId,
+		Title:           strings.TrimSpace(request.Title),
+		Slug:            request.Slug,
+		MetaDescription: strings.TrimSpace(request.MetaDescription),
+		CanonicalUrl:    strings.TrimSpace(request.CanonicalUrl),
+		OgTitle:         strings.TrimSpace(request.OgTitle),
+		OgDescription:   strings.TrimSpace(request.OgDescription),
+		OgImage:         strings.TrimSpace(request.OgImage),
+		NoIndex:         request.NoIndex,
+		Layout:          strings.TrimSpace(request.Layout),
+	})
+	if errors.Is(err, page.ErrPathTooLong) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Slug makes the address of a subpage too long")
+	}
+	if errors.Is(err, page.ErrArchiveSlug) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Post slugs can't be just a number")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "Slug is already in use for another page")
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
 const ListPagesPerPage = 10
 
 type listPagesResultItem struct {
-	ID    int    `json:"id"`
-	Title string `json:"title"`
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	Slug        string     `json:"slug"`
+	Path        string     `json:"path"`
+	CreatedBy   string     `json:"createdBy"`
+	PublishedAt *time.Time `json:"publishedAt"`
+	PublishedBy string     `json:"publishedBy"`
+	LockedBy    string     `json:"lockedBy"`
+	// PostDate and Author are only set for posts
+	PostDate *time.Time `json:"postDate,omitempty"`
+	Author   string     `json:"author,omitempty"`
 }
 type listPagesResult struct {
 	PageList  []listPagesResultItem ` json:"pageList"`
@@ -164,6 +448,8 @@ type listPagesResult struct {
 }
 type listPagesRequest struct {
 	Page int `json:"page"`
+	// TermId optionally narrows the list down to pages with a category or tag
+	TermId int `json:"termId"`
 }
 
 func ListRecentlyEditedPages(c echo.Context) error {
@@ -171,7 +457,29 @@ func ListRecentlyEditedPages(c echo.Context) error {
 	if err := c.Bind(paging); err != nil {
 		return echo.ErrInternalServerError
 	}
-	var rawPages, totalCount, err = page.ListRecentlyEditedPages(paging.Page, ListPagesPerPage)
+	var rawPages, totalCount, err = page.ListRecentlyEditedPages(paging.TermId, paging.Page, ListPagesPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return writePageList(c, rawPages, totalCount)
+}
+
+// writePageList responds with a page of the recently edited pages or posts, along with who is editing them
+func writePageList(c echo.Context, rawPages []page.Page, totalCount int64) error {
+	var userIds []int
+	var pageIds = make([]int, len(rawPages))
+	for i, row := range rawPages {
+		userIds = append(userIds, collectUserIds(row.CreatedById, row.PublishedById, row.AuthorId)...)
+		pageIds[i] = row.ID
+	}
+	locks, err := editlock.GetLocks(pageIds)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	for _, lock := range locks {
+		userIds = append(userIds, lock.UserId)
+	}
+	names, err := user.GetDisplayNames(userIds)
 	if err != nil {
 		return echo.ErrInternalServerError
 	}
@@ -180,8 +488,20 @@ func ListRecentlyEditedPages(c echo.Context) error {
 
 	for i, row := range rawPages {
 		listPagesResults[i] = listPagesResultItem{
-			ID:    row.ID,
-			Title: row.Title,
+			ID:          row.ID,
+			Title:       row.Title,
+			Slug:        row.Slug,
+			Path:        row.Path,
+			CreatedBy:   displayName(names, row.CreatedById),
+			PublishedAt: row.PublishedAt,
+			PublishedBy: displayName(names, row.PublishedById),
+		}
+		if lock, ok := locks[row.ID]; ok {
+			listPagesResults[i].LockedBy = names[lock.UserId]
+		}
+		if row.PageType == pagetype.Post {
+			listPagesResults[i].PostDate = row.DisplayDate()
+			listPagesResults[i].Author = displayName(names, row.AuthorId)
 		}
 	}
 	var result = listPagesResult{
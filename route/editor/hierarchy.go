@@ -0,0 +1,116 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/page"
+	"net/http"
+)
+
+type movePageRequest struct {
+	PageId int `json:"pageId" validate:"required,min=1"`
+	// ParentId is 0 to move the page to the top level
+	ParentId int `json:"parentId" validate:"min=0"`
+}
+
+func MovePage(c echo.Context) error {
+	request := new(movePageRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	isDup, err := page.MovePage(request.PageId, optionalPageId(request.ParentId))
+	if errors.Is(err, page.ErrParentNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Parent page does not exist")
+	}
+	if errors.Is(err, page.ErrPostNesting) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Posts can't be nested under pages or have subpages")
+	}
+	if errors.Is(err, page.ErrParentCycle) {
+		return echo.NewHTTPError(http.StatusBadRequest, "A page can't be moved under itself or one of its subpages")
+	}
+	if errors.Is(err, page.ErrPathTooLong) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Page would be nested too deeply")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "The new parent already has a subpage with this slug")
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type pageTreeNode struct {
+	ID          int            `json:"id"`
+	Title       string         `json:"title"`
+	Slug        string         `json:"slug"`
+	Path        string         `json:"path"`
+	IsPublished bool           `json:"isPublished"`
+	Children    []pageTreeNode `json:"children"`
+}
+type getPageTreeResponse struct {
+	Pages []pageTreeNode `json:"pages"`
+}
+
+func GetPageTree(c echo.Context) error {
+	rawPages, err := page.ListPageTree()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	childIds := make(map[int][]int)
+	var rootIds []int
+	pagesById := make(map[int]page.Page, len(rawPages))
+	for _, row := range rawPages {
+		pagesById[row.ID] = row
+	}
+	for _, row := range rawPages {
+		if row.ParentId == nil {
+			rootIds = append(rootIds, row.ID)
+			continue
+		}
+		if _, ok := pagesById[*row.ParentId]; !ok {
+			// Shouldn't happen since pages with subpages can't be trashed, but keep the page visible if it does
+			rootIds = append(rootIds, row.ID)
+			continue
+		}
+		childIds[*row.ParentId] = append(childIds[*row.ParentId], row.ID)
+	}
+
+	var buildNodes func(ids []int) []pageTreeNode
+	buildNodes = func(ids []int) []pageTreeNode {
+		nodes := make([]pageTreeNode, len(ids))
+		for i, id := range ids {
+			row := pagesById[id]
+			nodes[i] = pageTreeNode{
+				ID:          row.ID,
+				Title:       row.Title,
+				Slug:        row.Slug,
+				Path:        row.Path,
+				IsPublished: row.PublishedRevisionId != nil,
+				Children:    buildNodes(childIds[id]),
+			}
+		}
+		return nodes
+	}
+	return c.JSON(http.StatusOK, getPageTreeResponse{
+		Pages: buildNodes(rootIds),
+	})
+}
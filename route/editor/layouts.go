@@ -0,0 +1,36 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/settings"
+	"github.com/pgray64/tinypress/service/theme"
+	"net/http"
+)
+
+type listLayoutsResponse struct {
+	Layouts []string `json:"layouts"`
+}
+
+// ListLayouts returns the layouts of the active theme, which pages can choose between
+func ListLayouts(c echo.Context) error {
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, listLayoutsResponse{
+		Layouts: theme.Get(siteSettings.ActiveTheme).LayoutNames(),
+	})
+}
@@ -0,0 +1,381 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/authentication"
+	"github.com/pgray64/tinypress/service/block"
+	"github.com/pgray64/tinypress/service/media"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/user"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ListMediaPerPage  = 40
+	MaxFilesPerUpload = 20
+)
+
+// mediaAsset is in the shape the GrapesJS asset manager expects, with the rest of the details alongside
+type mediaAsset struct {
+	Src        string    `json:"src"`
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	ID         int       `json:"id"`
+	MimeType   string    `json:"mimeType"`
+	Size       int64     `json:"size"`
+	UploadedBy string    `json:"uploadedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// UsedBy counts the pages and blocks using the upload, and is only filled in when listing
+	UsedBy int64 `json:"usedBy"`
+}
+
+// uploadMediaResponse is what the GrapesJS asset manager reads back after an upload
+type uploadMediaResponse struct {
+	Data []mediaAsset `json:"data"`
+}
+
+func toMediaAsset(row *media.Media, names map[int]string) mediaAsset {
+	return mediaAsset{
+		Src:        row.Url(),
+		Type:       "image",
+		Name:       row.Filename,
+		Width:      row.Width,
+		Height:     row.Height,
+		ID:         row.ID,
+		MimeType:   row.MimeType,
+		Size:       row.Size,
+		UploadedBy: displayName(names, row.UploadedById),
+		CreatedAt:  row.CreatedAt,
+	}
+}
+
+// uploadRejectedResponse says which file failed which check, with the limit it went over for the size checks
+type uploadRejectedResponse struct {
+	Message  string `json:"message"`
+	Filename string `json:"filename"`
+	Rule     int    `json:"rule"`
+	Limit    int64  `json:"limit,omitempty"`
+}
+
+// UploadMedia takes a multipart upload of one or more files, under the field name the GrapesJS asset manager uses.
+// Every file is checked before any is stored, so one rejected file means none of them are kept.
+func UploadMedia(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	limits, err := media.GetUploadLimits()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, MaxFilesPerUpload*(limits.Max+(1<<20)))
+	form, err := c.MultipartForm()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload is invalid or too large")
+	}
+	files := append(form.File["files"], form.File["files[]"]...)
+	if len(files) < 1 || len(files) > MaxFilesPerUpload {
+		return echo.ErrBadRequest
+	}
+
+	for _, fileHeader := range files {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return echo.ErrInternalServerError
+		}
+		err = media.Check(fileHeader.Filename, file)
+		file.Close()
+		if err != nil {
+			return uploadFailed(fileHeader.Filename, err)
+		}
+	}
+
+	var uploaded = make([]mediaAsset, len(files))
+	for i, fileHeader := range files {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return echo.ErrInternalServerError
+		}
+		stored, err := media.Store(fileHeader.Filename, file, &authContext.UserId)
+		file.Close()
+		if err != nil {
+			return uploadFailed(fileHeader.Filename, err)
+		}
+		// The upload is kept either way, and the regeneration job tries again later
+		if err = media.GenerateVariants(stored); err != nil {
+			c.Logger().Error("Failed to resize upload: ", err)
+		}
+		uploaded[i] = toMediaAsset(stored, nil)
+	}
+	return c.JSON(http.StatusOK, uploadMediaResponse{
+		Data: uploaded,
+	})
+}
+
+// uploadFailed answers with the check a file failed, or an internal error if it didn't get as far as the checks
+func uploadFailed(filename string, err error) error {
+	var uploadErr *media.UploadError
+	if errors.As(err, &uploadErr) {
+		return echo.NewHTTPError(http.StatusBadRequest, uploadRejectedResponse{
+			Message:  filename + ": " + uploadErr.Message,
+			Filename: filename,
+			Rule:     int(uploadErr.Rule),
+			Limit:    uploadErr.Limit,
+		})
+	}
+	return echo.ErrInternalServerError
+}
+
+type listMediaRequest struct {
+	Query string `json:"query" validate:"max=255"`
+	Page  int    `json:"page"`
+}
+type listMediaResult struct {
+	MediaList []mediaAsset `json:"mediaList"`
+	PageCount int64        `json:"pageCount"`
+}
+
+func ListMedia(c echo.Context) error {
+	request := new(listMediaRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	rows, totalCount, err := media.ListMedia(strings.TrimSpace(request.Query), request.Page, ListMediaPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var userIds []int
+	for _, row := range rows {
+		userIds = append(userIds, collectUserIds(row.UploadedById)...)
+	}
+	names, err := user.GetDisplayNames(userIds)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var mediaIds = make([]int, len(rows))
+	for i, row := range rows {
+		mediaIds[i] = row.ID
+	}
+	uses, err := media.CountUses(mediaIds)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var mediaResults = make([]mediaAsset, len(rows))
+	for i := range rows {
+		mediaResults[i] = toMediaAsset(&rows[i], names)
+		mediaResults[i].UsedBy = uses[rows[i].ID]
+	}
+	return c.JSON(http.StatusOK, listMediaResult{
+		MediaList: mediaResults,
+		PageCount: int64(math.Ceil(float64(totalCount) / float64(ListMediaPerPage))),
+	})
+}
+
+type mediaIdRequest struct {
+	MediaId int `json:"mediaId" validate:"required,min=1"`
+}
+
+type mediaPageUsageItem struct {
+	PageId      int    `json:"pageId"`
+	Title       string `json:"title"`
+	Path        string `json:"path"`
+	IsPublished bool   `json:"isPublished"`
+	InPublished bool   `json:"inPublished"`
+	InDraft     bool   `json:"inDraft"`
+	AsImage     bool   `json:"asImage"`
+}
+type mediaBlockUsageItem struct {
+	BlockId     int    `json:"blockId"`
+	Name        string `json:"name"`
+	Handle      string `json:"handle"`
+	InPublished bool   `json:"inPublished"`
+	InDraft     bool   `json:"inDraft"`
+}
+type mediaUsageResult struct {
+	PageList  []mediaPageUsageItem  `json:"pageList"`
+	BlockList []mediaBlockUsageItem `json:"blockList"`
+}
+
+// getMediaUsage finds the pages and blocks using an upload, and whether any of them show it on the public site
+func getMediaUsage(upload *media.Media) (result mediaUsageResult, isPublished bool, err error) {
+	pageUsages, err := page.WhereMediaUsed(upload)
+	if err != nil {
+		return result, false, err
+	}
+	blockUsages, err := block.WhereMediaUsed(upload.ID)
+	if err != nil {
+		return result, false, err
+	}
+
+	result.PageList = make([]mediaPageUsageItem, len(pageUsages))
+	for i, usage := range pageUsages {
+		pagePublished := usage.Page.PublishedRevisionId != nil
+		result.PageList[i] = mediaPageUsageItem{
+			PageId:      usage.Page.ID,
+			Title:       usage.Page.Title,
+			Path:        usage.Page.Path,
+			IsPublished: pagePublished,
+			InPublished: usage.InPublished,
+			InDraft:     usage.InDraft,
+			AsImage:     usage.AsImage,
+		}
+		if usage.InPublished || (usage.AsImage && pagePublished) {
+			isPublished = true
+		}
+	}
+	result.BlockList = make([]mediaBlockUsageItem, len(blockUsages))
+	for i, usage := range blockUsages {
+		result.BlockList[i] = mediaBlockUsageItem{
+			BlockId:     usage.Block.ID,
+			Name:        usage.Block.Name,
+			Handle:      usage.Block.Handle,
+			InPublished: usage.InPublished,
+			InDraft:     usage.InDraft,
+		}
+		if usage.InPublished {
+			isPublished = true
+		}
+	}
+	return result, isPublished, nil
+}
+
+// GetMediaUsage lists the pages and blocks using an upload, in their published revision, their current draft, older
+// revisions or as a page image
+func GetMediaUsage(c echo.Context) error {
+	request := new(mediaIdRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	upload, err := media.GetMedia(request.MediaId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if upload == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Media does not exist")
+	}
+	result, _, err := getMediaUsage(upload)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+type deleteMediaRequest struct {
+	MediaId int  `json:"mediaId" validate:"required,min=1"`
+	Force   bool `json:"force"`
+}
+
+// mediaInUseResponse is sent instead of deleting an upload that is still used. CanForce is false when the public
+// site shows it, since deleting it would leave broken images there.
+type mediaInUseResponse struct {
+	Message   string                `json:"message"`
+	PageList  []mediaPageUsageItem  `json:"pageList"`
+	BlockList []mediaBlockUsageItem `json:"blockList"`
+	CanForce  bool                  `json:"canForce"`
+}
+
+// DeleteMedia deletes an upload unless it is used. Uploads only used by drafts, older revisions or unpublished pages
+// can be deleted with force set, while those on the public site have to be removed from it first.
+func DeleteMedia(c echo.Context) error {
+	request := new(deleteMediaRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	upload, err := media.GetMedia(request.MediaId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if upload == nil {
+		return c.JSON(http.StatusOK, new(struct{}))
+	}
+	usage, isPublished, err := getMediaUsage(upload)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if isPublished {
+		return echo.NewHTTPError(http.StatusConflict, mediaInUseResponse{
+			Message:   "This media is used by published content, remove it from there before deleting it",
+			PageList:  usage.PageList,
+			BlockList: usage.BlockList,
+		})
+	}
+	if (len(usage.PageList) > 0 || len(usage.BlockList) > 0) && !request.Force {
+		return echo.NewHTTPError(http.StatusConflict, mediaInUseResponse{
+			Message:   "This media is used by drafts or older revisions, which will show a broken image if it is deleted",
+			PageList:  usage.PageList,
+			BlockList: usage.BlockList,
+			CanForce:  true,
+		})
+	}
+
+	if err := media.DeleteMedia(request.MediaId); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type listOrphanedMediaRequest struct {
+	Days int `json:"days" validate:"min=0,max=3650"`
+	Page int `json:"page"`
+}
+
+// ListOrphanedMedia lists uploads that no page or block revision has used for at least the given number of days
+func ListOrphanedMedia(c echo.Context) error {
+	request := new(listOrphanedMediaRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -request.Days)
+	rows, totalCount, err := media.ListOrphans(cutoff, request.Page, ListMediaPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var userIds []int
+	for _, row := range rows {
+		userIds = append(userIds, collectUserIds(row.UploadedById)...)
+	}
+	names, err := user.GetDisplayNames(userIds)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var mediaResults = make([]mediaAsset, len(rows))
+	for i := range rows {
+		mediaResults[i] = toMediaAsset(&rows[i], names)
+	}
+	return c.JSON(http.StatusOK, listMediaResult{
+		MediaList: mediaResults,
+		PageCount: int64(math.Ceil(float64(totalCount) / float64(ListMediaPerPage))),
+	})
+}
@@ -0,0 +1,168 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/authentication"
+	"github.com/pgray64/tinypress/enum/pagetype"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/user"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Posts are pages with a type of pagetype.Post, so the post editor shares most of its endpoints with the page editor.
+// The ones here deal with what is different about posts.
+
+type createPostForm struct {
+	Title         string `json:"title" validate:"required,max=255"`
+	Slug          string `json:"slug" validate:"omitempty,slug"`
+	RenderedHtml  string `json:"renderedHtml"`
+	RenderedCss   string `json:"renderedCss"`
+	EditorContent string `json:"editorContent"`
+}
+
+func CreatePost(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	formData := new(createPostForm)
+	if err := c.Bind(formData); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(formData); err != nil {
+		return echo.ErrBadRequest
+	}
+	newPost := page.Page{
+		PageType:    pagetype.Post,
+		Title:       strings.TrimSpace(formData.Title),
+		Slug:        formData.Slug,
+		CreatedById: &authContext.UserId,
+		AuthorId:    &authContext.UserId,
+	}
+	newDraft := page.ContentRevision{
+		RenderedHtml:  formData.RenderedHtml,
+		RenderedCss:   formData.RenderedCss,
+		EditorContent: formData.EditorContent,
+		CreatedById:   &authContext.UserId,
+	}
+	newPostId, isDup, err := newPost.Create(&newDraft)
+	if errors.Is(err, page.ErrArchiveSlug) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Post slugs can't be just a number")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if isDup {
+		return echo.NewHTTPError(http.StatusBadRequest, "Slug is already in use for another post")
+	}
+	return c.JSON(http.StatusOK, createPageResponse{
+		PageId: newPostId,
+		Slug:   newPost.Slug,
+		Path:   newPost.Path,
+	})
+}
+
+type postDetails struct {
+	PostDate      *time.Time `json:"postDate"`
+	Excerpt       string     `json:"excerpt" validate:"max=1000"`
+	FeaturedImage string     `json:"featuredImage" validate:"max=2048"`
+	AuthorId      *int       `json:"authorId" validate:"omitempty,min=1"`
+}
+type getPostWithDraftResponse struct {
+	*getPageWithDraftResponse
+	postDetails
+	Author string `json:"author"`
+}
+
+func GetPostWithDraft(c echo.Context) error {
+	request := new(getPageWithDraftRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	response, post, err := loadPageWithDraft(request.PageId)
+	if err != nil {
+		return err
+	}
+	if post.PageType != pagetype.Post {
+		return echo.NewHTTPError(http.StatusBadRequest, "Post does not exist")
+	}
+	names, err := user.GetDisplayNames(collectUserIds(post.AuthorId))
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, getPostWithDraftResponse{
+		getPageWithDraftResponse: response,
+		postDetails: postDetails{
+			PostDate:      post.PostDate,
+			Excerpt:       post.Excerpt,
+			FeaturedImage: post.FeaturedImage,
+			AuthorId:      post.AuthorId,
+		},
+		Author: displayName(names, post.AuthorId),
+	})
+}
+
+type updatePostDetailsRequest struct {
+	PageId int `json:"pageId" validate:"required,min=1"`
+	postDetails
+}
+
+func UpdatePostDetails(c echo.Context) error {
+	request := new(updatePostDetailsRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+	if request.AuthorId != nil {
+		author, err := user.GetUserWithRoles(*request.AuthorId)
+		if err != nil {
+			return echo.ErrInternalServerError
+		}
+		if author == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Author does not exist")
+		}
+	}
+
+	err := page.UpdatePostDetails(&page.Page{
+		ID:            request.PageId,
+		PostDate:      request.PostDate,
+		Excerpt:       strings.TrimSpace(request.Excerpt),
+		FeaturedImage: strings.TrimSpace(request.FeaturedImage),
+		AuthorId:      request.AuthorId,
+	})
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+func ListRecentlyEditedPosts(c echo.Context) error {
+	paging := new(listPagesRequest)
+	if err := c.Bind(paging); err != nil {
+		return echo.ErrInternalServerError
+	}
+	rawPosts, totalCount, err := page.ListRecentlyEditedPosts(paging.TermId, paging.Page, ListPagesPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return writePageList(c, rawPosts, totalCount)
+}
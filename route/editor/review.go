@@ -0,0 +1,146 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/authentication"
+	"github.com/pgray64/tinypress/enum/reviewstate"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/user"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type reviewRequest struct {
+	RevisionId int    `json:"revisionId" validate:"required,min=1"`
+	Comment    string `json:"comment" validate:"max=5000"`
+}
+
+func transitionReview(c echo.Context, to reviewstate.ReviewState, requireComment bool) error {
+	authContext := c.(*authentication.AuthContext)
+	request := new(reviewRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+	comment := strings.TrimSpace(request.Comment)
+	if requireComment && comment == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Add a comment explaining what needs to change")
+	}
+
+	err := page.TransitionReview(request.RevisionId, to, comment, authContext.UserId)
+	if errors.Is(err, page.ErrInvalidTransition) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Draft is not at the right review stage for this")
+	}
+	if errors.Is(err, page.ErrSelfApproval) {
+		return echo.NewHTTPError(http.StatusForbidden, "Someone other than the author has to approve this draft")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+func SubmitForReview(c echo.Context) error {
+	return transitionReview(c, reviewstate.InReview, false)
+}
+
+func RequestChanges(c echo.Context) error {
+	return transitionReview(c, reviewstate.ChangesRequested, true)
+}
+
+func ApproveDraft(c echo.Context) error {
+	return transitionReview(c, reviewstate.Approved, false)
+}
+
+func AddReviewComment(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	request := new(reviewRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+	comment := strings.TrimSpace(request.Comment)
+	if comment == "" {
+		return echo.ErrBadRequest
+	}
+
+	revision, err := page.GetRevision(request.RevisionId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if revision == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Revision does not exist")
+	}
+	if err = page.AddReviewComment(revision.ID, comment, authContext.UserId); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type reviewEventResultItem struct {
+	ID         int                     `json:"id"`
+	RevisionId int                     `json:"revisionId"`
+	FromState  reviewstate.ReviewState `json:"fromState"`
+	ToState    reviewstate.ReviewState `json:"toState"`
+	Comment    string                  `json:"comment"`
+	CreatedBy  string                  `json:"createdBy"`
+	CreatedAt  time.Time               `json:"createdAt"`
+}
+type listReviewHistoryResult struct {
+	EventList []reviewEventResultItem `json:"eventList"`
+}
+
+func ListReviewHistory(c echo.Context) error {
+	request, err := bindPageIdRequest(c)
+	if err != nil {
+		return err
+	}
+
+	events, err := page.ListReviewEvents(request.PageId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var userIds []int
+	for _, row := range events {
+		userIds = append(userIds, collectUserIds(row.UserId)...)
+	}
+	names, err := user.GetDisplayNames(userIds)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var eventResults = make([]reviewEventResultItem, len(events))
+	for i, row := range events {
+		eventResults[i] = reviewEventResultItem{
+			ID:         row.ID,
+			RevisionId: row.RevisionId,
+			FromState:  row.FromState,
+			ToState:    row.ToState,
+			Comment:    row.Comment,
+			CreatedBy:  displayName(names, row.UserId),
+			CreatedAt:  row.CreatedAt,
+		}
+	}
+	return c.JSON(http.StatusOK, listReviewHistoryResult{
+		EventList: eventResults,
+	})
+}
@@ -0,0 +1,218 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/authentication"
+	"github.com/pgray64/tinypress/enum/reviewstate"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/user"
+	"math"
+	"net/http"
+	"time"
+)
+
+const ListRevisionsPerPage = 20
+
+type listRevisionsRequest struct {
+	PageId int `json:"pageId" validate:"required,min=1"`
+	Page   int `json:"page"`
+}
+type revisionListItem struct {
+	ID          int                     `json:"id"`
+	CreatedAt   time.Time               `json:"createdAt"`
+	CreatedBy   string                  `json:"createdBy"`
+	IsPublished bool                    `json:"isPublished"`
+	ReviewState reviewstate.ReviewState `json:"reviewState"`
+}
+type listRevisionsResult struct {
+	RevisionList []revisionListItem `json:"revisionList"`
+	PageCount    int64              `json:"pageCount"`
+}
+
+func ListRevisions(c echo.Context) error {
+	request := new(listRevisionsRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	revisionPage, err := page.GetPage(request.PageId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if revisionPage == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Page does not exist")
+	}
+	revisions, totalCount, err := page.ListRevisions(request.PageId, request.Page, ListRevisionsPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var userIds []int
+	for _, row := range revisions {
+		userIds = append(userIds, collectUserIds(row.CreatedById)...)
+	}
+	names, err := user.GetDisplayNames(userIds)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var revisionResults = make([]revisionListItem, len(revisions))
+	for i, row := range revisions {
+		revisionResults[i] = revisionListItem{
+			ID:          row.ID,
+			CreatedAt:   row.CreatedAt,
+			CreatedBy:   displayName(names, row.CreatedById),
+			ReviewState: row.ReviewState,
+			IsPublished: revisionPage.PublishedRevisionId != nil && *revisionPage.PublishedRevisionId == row.ID,
+		}
+	}
+	return c.JSON(http.StatusOK, listRevisionsResult{
+		PageCount:    int64(math.Ceil(float64(totalCount) / float64(ListRevisionsPerPage))),
+		RevisionList: revisionResults,
+	})
+}
+
+type revisionRequest struct {
+	RevisionId int `json:"revisionId" validate:"required,min=1"`
+}
+type getRevisionResponse struct {
+	ID            int                     `json:"id"`
+	PageId        int                     `json:"pageId"`
+	CreatedAt     time.Time               `json:"createdAt"`
+	CreatedBy     string                  `json:"createdBy"`
+	ReviewState   reviewstate.ReviewState `json:"reviewState"`
+	RenderedHtml  string                  `json:"renderedHtml"`
+	RenderedCss   string                  `json:"renderedCss"`
+	EditorContent string                  `json:"editorContent"`
+}
+
+func GetRevision(c echo.Context) error {
+	request := new(revisionRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	revision, err := page.GetRevision(request.RevisionId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if revision == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Revision does not exist")
+	}
+	names, err := user.GetDisplayNames(collectUserIds(revision.CreatedById))
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, getRevisionResponse{
+		ID:            revision.ID,
+		PageId:        revision.PageId,
+		CreatedAt:     revision.CreatedAt,
+		CreatedBy:     displayName(names, revision.CreatedById),
+		ReviewState:   revision.ReviewState,
+		RenderedHtml:  revision.RenderedHtml,
+		RenderedCss:   revision.RenderedCss,
+		EditorContent: revision.EditorContent,
+	})
+}
+
+type compareRevisionsRequest struct {
+	FromRevisionId int `json:"fromRevisionId" validate:"required,min=1"`
+	ToRevisionId   int `json:"toRevisionId" validate:"required,min=1"`
+}
+type diffChunkResult struct {
+	Op    page.DiffOp `json:"op"`
+	Lines []string    `json:"lines"`
+}
+type compareRevisionsResult struct {
+	EditorContentDiff []diffChunkResult `json:"editorContentDiff"`
+	RenderedHtmlDiff  []diffChunkResult `json:"renderedHtmlDiff"`
+}
+
+func toDiffChunkResults(chunks []page.DiffChunk) []diffChunkResult {
+	var results = make([]diffChunkResult, len(chunks))
+	for i, chunk := range chunks {
+		results[i] = diffChunkResult{
+			Op:    chunk.Op,
+			Lines: chunk.Lines,
+		}
+	}
+	return results
+}
+
+func CompareRevisions(c echo.Context) error {
+	request := new(compareRevisionsRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	from, err := page.GetRevision(request.FromRevisionId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	to, err := page.GetRevision(request.ToRevisionId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if from == nil || to == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Revision does not exist")
+	}
+	if from.PageId != to.PageId {
+		return echo.NewHTTPError(http.StatusBadRequest, "Revisions belong to different pages")
+	}
+	return c.JSON(http.StatusOK, compareRevisionsResult{
+		EditorContentDiff: toDiffChunkResults(page.DiffContent(from.EditorContent, to.EditorContent)),
+		RenderedHtmlDiff:  toDiffChunkResults(page.DiffContent(from.RenderedHtml, to.RenderedHtml)),
+	})
+}
+
+type restoreRevisionResponse struct {
+	DraftId int `json:"draftId"`
+}
+
+func RestoreRevision(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	request := new(revisionRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	revision, err := page.GetRevision(request.RevisionId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if revision == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Revision does not exist")
+	}
+	draft, err := page.RestoreRevision(revision.ID, authContext.UserId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, restoreRevisionResponse{
+		DraftId: draft.ID,
+	})
+}
@@ -0,0 +1,130 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/authentication"
+	"github.com/pgray64/tinypress/enum/scheduleaction"
+	"github.com/pgray64/tinypress/service/page"
+	"net/http"
+	"time"
+)
+
+type scheduleDraftRequest struct {
+	DraftId   int        `json:"draftId" validate:"required,min=1"`
+	PublishAt *time.Time `json:"publishAt"`
+	ExpireAt  *time.Time `json:"expireAt"`
+}
+
+func ScheduleDraft(c echo.Context) error {
+	authContext := c.(*authentication.AuthContext)
+	request := new(scheduleDraftRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	if request.PublishAt == nil && request.ExpireAt == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Choose when to publish or unpublish the page")
+	}
+	if request.ExpireAt != nil && request.ExpireAt.Before(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Expiry time is in the past")
+	}
+	if request.PublishAt != nil && request.ExpireAt != nil && !request.ExpireAt.After(*request.PublishAt) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Expiry time must be after the publish time")
+	}
+	revision, err := page.GetRevision(request.DraftId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if revision == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Draft does not exist")
+	}
+
+	err = page.SchedulePublish(revision.ID, request.PublishAt, request.ExpireAt, authContext.UserId)
+	if errors.Is(err, page.ErrInvalidTransition) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Only approved drafts can be scheduled for publishing")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type listSchedulesRequest struct {
+	PageId int `json:"pageId" validate:"required,min=1"`
+}
+type scheduleResultItem struct {
+	ID         int                           `json:"id"`
+	RevisionId *int                          `json:"revisionId"`
+	Action     scheduleaction.ScheduleAction `json:"action"`
+	RunAt      time.Time                     `json:"runAt"`
+}
+type listSchedulesResult struct {
+	ScheduleList []scheduleResultItem `json:"scheduleList"`
+}
+
+func ListSchedules(c echo.Context) error {
+	request := new(listSchedulesRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	schedules, err := page.ListPendingSchedules(request.PageId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var scheduleResults = make([]scheduleResultItem, len(schedules))
+	for i, row := range schedules {
+		scheduleResults[i] = scheduleResultItem{
+			ID:         row.ID,
+			RevisionId: row.RevisionId,
+			Action:     row.Action,
+			RunAt:      row.RunAt,
+		}
+	}
+	return c.JSON(http.StatusOK, listSchedulesResult{
+		ScheduleList: scheduleResults,
+	})
+}
+
+type cancelScheduleRequest struct {
+	ScheduleId int `json:"scheduleId" validate:"required,min=1"`
+}
+
+func CancelSchedule(c echo.Context) error {
+	request := new(cancelScheduleRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	canceled, err := page.CancelSchedule(request.ScheduleId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if !canceled {
+		return echo.NewHTTPError(http.StatusBadRequest, "Schedule has already run or been canceled")
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
@@ -0,0 +1,74 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/enum/pagetype"
+	"github.com/pgray64/tinypress/service/page"
+	"math"
+	"net/http"
+	"strings"
+)
+
+const SearchResultsPerPage = 20
+
+type searchPagesRequest struct {
+	Query string `json:"query" validate:"required,max=200"`
+	Page  int    `json:"page" validate:"min=0"`
+}
+type searchPagesResultItem struct {
+	PageId      int               `json:"pageId"`
+	Title       string            `json:"title"`
+	Path        string            `json:"path"`
+	PageType    pagetype.PageType `json:"pageType"`
+	IsPublished bool              `json:"isPublished"`
+	Snippet     string            `json:"snippet"`
+}
+type searchPagesResult struct {
+	Results   []searchPagesResultItem `json:"results"`
+	PageCount int64                   `json:"pageCount"`
+}
+
+// SearchPages searches the current drafts of all pages and posts, including ones that were never published
+func SearchPages(c echo.Context) error {
+	request := new(searchPagesRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	request.Query = strings.TrimSpace(request.Query)
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	results, totalCount, err := page.SearchDrafts(request.Query, request.Page, SearchResultsPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var items = make([]searchPagesResultItem, len(results))
+	for i, row := range results {
+		items[i] = searchPagesResultItem{
+			PageId:      row.PageId,
+			Title:       row.Title,
+			Path:        row.Path,
+			PageType:    row.PageType,
+			IsPublished: row.IsPublished,
+			Snippet:     row.Snippet,
+		}
+	}
+	return c.JSON(http.StatusOK, searchPagesResult{
+		Results:   items,
+		PageCount: int64(math.Ceil(float64(totalCount) / float64(SearchResultsPerPage))),
+	})
+}
@@ -0,0 +1,54 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/taxonomy"
+	"net/http"
+)
+
+type setPageTermsRequest struct {
+	PageId  int   `json:"pageId" validate:"required,min=1"`
+	TermIds []int `json:"termIds" validate:"max=100,dive,min=1"`
+}
+
+// SetPageTerms replaces the categories and tags of a page or post
+func SetPageTerms(c echo.Context) error {
+	request := new(setPageTermsRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	existingPage, err := page.GetPage(request.PageId)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if existingPage == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Page does not exist")
+	}
+	err = taxonomy.SetPageTerms(request.PageId, request.TermIds)
+	if errors.Is(err, taxonomy.ErrTermNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Category or tag does not exist")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
@@ -0,0 +1,149 @@
+/*
+Package editor is for routes related to page editing
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editor
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/authentication"
+	"github.com/pgray64/tinypress/enum/productfeature"
+	"github.com/pgray64/tinypress/service/page"
+	"math"
+	"net/http"
+	"time"
+)
+
+type pageIdRequest struct {
+	PageId int `json:"pageId" validate:"required,min=1"`
+}
+
+func bindPageIdRequest(c echo.Context) (*pageIdRequest, error) {
+	request := new(pageIdRequest)
+	if err := c.Bind(request); err != nil {
+		return nil, echo.ErrInternalServerError
+	}
+	if err := c.Validate(request); err != nil {
+		return nil, echo.ErrBadRequest
+	}
+	return request, nil
+}
+
+func UnpublishPage(c echo.Context) error {
+	request, err := bindPageIdRequest(c)
+	if err != nil {
+		return err
+	}
+	if err = page.Unpublish(request.PageId); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+func TrashPage(c echo.Context) error {
+	request, err := bindPageIdRequest(c)
+	if err != nil {
+		return err
+	}
+	authContext := c.(*authentication.AuthContext)
+	err = page.TrashPage(request.PageId, authContext.HasFeature(productfeature.PublishContent))
+	if errors.Is(err, page.ErrHasChildren) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Move or trash the subpages of this page first")
+	}
+	if errors.Is(err, page.ErrPublished) {
+		return echo.NewHTTPError(http.StatusForbidden, "Only publishers can trash a published page")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
+
+type trashResultItem struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Slug      string    `json:"slug"`
+	Path      string    `json:"path"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+type listTrashResult struct {
+	PageList  []trashResultItem `json:"pageList"`
+	PageCount int64             `json:"pageCount"`
+}
+
+func ListTrash(c echo.Context) error {
+	paging := new(listPagesRequest)
+	if err := c.Bind(paging); err != nil {
+		return echo.ErrInternalServerError
+	}
+	rawPages, totalCount, err := page.ListTrash(paging.Page, ListPagesPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var trashResults = make([]trashResultItem, len(rawPages))
+	for i, row := range rawPages {
+		trashResults[i] = trashResultItem{
+			ID:        row.ID,
+			Title:     row.Title,
+			Slug:      row.Slug,
+			Path:      row.Path,
+			DeletedAt: row.DeletedAt.Time,
+		}
+	}
+	return c.JSON(http.StatusOK, listTrashResult{
+		PageCount: int64(math.Ceil(float64(totalCount) / float64(ListPagesPerPage))),
+		PageList:  trashResults,
+	})
+}
+
+type restorePageResponse struct {
+	Slug string `json:"slug"`
+	Path string `json:"path"`
+}
+
+func RestorePage(c echo.Context) error {
+	request, err := bindPageIdRequest(c)
+	if err != nil {
+		return err
+	}
+	restoredPage, err := page.RestorePage(request.PageId)
+	if errors.Is(err, page.ErrNotInTrash) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Page is not in the trash")
+	}
+	if errors.Is(err, page.ErrPathTooLong) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Page is nested too deeply to be restored")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, restorePageResponse{
+		Slug: restoredPage.Slug,
+		Path: restoredPage.Path,
+	})
+}
+
+func PurgePage(c echo.Context) error {
+	request, err := bindPageIdRequest(c)
+	if err != nil {
+		return err
+	}
+	err = page.PurgePage(request.PageId)
+	if errors.Is(err, page.ErrNotInTrash) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Only pages in the trash can be permanently deleted")
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, new(struct{}))
+}
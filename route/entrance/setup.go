@@ -16,6 +16,7 @@ package entrance
 import (
 	"github.com/labstack/echo/v4"
 	"github.com/pgray64/tinypress/conf"
+	"github.com/pgray64/tinypress/enum/storagebackend"
 	"github.com/pgray64/tinypress/enum/userrole"
 	"github.com/pgray64/tinypress/service/media"
 	"github.com/pgray64/tinypress/service/settings"
@@ -26,16 +27,24 @@ import (
 )
 
 type siteSetupForm struct {
-	SiteName           string `json:"siteName" validate:"required,max=100"`
-	DisplayName        string `json:"displayName" validate:"required,max=100"`
-	Email              string `json:"email" validate:"required,email,max=255"`
-	Username           string `json:"username" validate:"required,alphanum,max=100"`
-	Password           string `json:"password" validate:"required"`
-	SmtpServer         string `json:"smtpServer" validate:"required,max=255"`
-	SmtpUsername       string `json:"smtpUsername" validate:"required,max=255"`
-	SmtpPassword       string `json:"smtpPassword" validate:"required,max=255"`
-	SmtpPort           string `json:"smtpPort" validate:"required,max=16"`
-	ImageDirectoryPath string `json:"imageDirectoryPath" validate:"required,max=255"`
+	SiteName     string `json:"siteName" validate:"required,max=100"`
+	DisplayName  string `json:"displayName" validate:"required,max=100"`
+	Email        string `json:"email" validate:"required,email,max=255"`
+	Username     string `json:"username" validate:"required,alphanum,max=100"`
+	Password     string `json:"password" validate:"required"`
+	SmtpServer   string `json:"smtpServer" validate:"required,max=255"`
+	SmtpUsername string `json:"smtpUsername" validate:"required,max=255"`
+	SmtpPassword string `json:"smtpPassword" validate:"required,max=255"`
+	SmtpPort     string `json:"smtpPort" validate:"required,max=16"`
+	// StorageBackend can be left out for a local directory
+	StorageBackend     int    `json:"storageBackend" validate:"min=0,max=2"`
+	ImageDirectoryPath string `json:"imageDirectoryPath" validate:"required_unless=StorageBackend 2,max=255"`
+	S3Endpoint         string `json:"s3Endpoint" validate:"required_if=StorageBackend 2,max=255"`
+	S3Region           string `json:"s3Region" validate:"max=100"`
+	S3Bucket           string `json:"s3Bucket" validate:"required_if=StorageBackend 2,max=255"`
+	S3AccessKey        string `json:"s3AccessKey" validate:"max=255"`
+	S3SecretKey        string `json:"s3SecretKey" validate:"max=255"`
+	S3UseSsl           bool   `json:"s3UseSsl"`
 }
 
 func SiteSetup(c echo.Context) error {
@@ -64,11 +73,21 @@ func SiteSetup(c echo.Context) error {
 		SmtpUsername:       formData.SmtpUsername,
 		SmtpPassword:       formData.SmtpPassword,
 		SmtpPort:           formData.SmtpPort,
+		StorageBackend:     storagebackend.Local,
+		S3Endpoint:         strings.TrimSpace(formData.S3Endpoint),
+		S3Region:           strings.TrimSpace(formData.S3Region),
+		S3Bucket:           strings.TrimSpace(formData.S3Bucket),
+		S3AccessKey:        formData.S3AccessKey,
+		S3SecretKey:        formData.S3SecretKey,
+		S3UseSsl:           formData.S3UseSsl,
+	}
+	if formData.StorageBackend > 0 {
+		newSettings.StorageBackend = storagebackend.StorageBackend(formData.StorageBackend)
 	}
 
-	// Abort setup if image directory is unwritable
-	if !media.TestImageDirectoryPath(newSettings.ImageDirectoryPath) {
-		return echo.NewHTTPError(http.StatusBadRequest, "Image directory test failed - ensure it exists and allows read and write access")
+	// Abort setup if media storage is unusable
+	if !media.TestStorage(&newSettings) {
+		return echo.NewHTTPError(http.StatusBadRequest, media.StorageTestFailedMessage)
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(formData.Password), conf.BcryptCost)
@@ -101,7 +120,7 @@ func SiteSetup(c echo.Context) error {
 
 	// First user for new customer gets all roles
 	err = user.CreateOrUpdateRoleMappings(newUser.ID, []userrole.UserRole{
-		userrole.Admin, userrole.Editor, userrole.User,
+		userrole.Admin, userrole.Editor, userrole.User, userrole.Reviewer, userrole.Publisher,
 	})
 	if err != nil {
 		return echo.ErrInternalServerError
@@ -0,0 +1,150 @@
+/*
+Package public is for routes serving the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/render"
+	"github.com/pgray64/tinypress/service/settings"
+	"github.com/pgray64/tinypress/service/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const PostsPerPage = 10
+
+var archivePattern = regexp.MustCompile(`^([0-9]{4})(?:/([0-9]{2}))?$`)
+
+// postListing is a paged listing of published posts, or of pages for the taxonomy archives
+type postListing struct {
+	title       string
+	path        string
+	breadcrumbs []render.Breadcrumb
+	// requireContent treats a listing with nothing in it as missing, rather than showing that there is nothing yet
+	requireContent bool
+	list           func(pageNumber int) ([]page.Page, int64, error)
+}
+
+// RenderBlog serves the blog index, the yearly and monthly archives under it, and the posts themselves. Listings are
+// paged with ?page=N, starting at 1.
+func RenderBlog(c echo.Context) error {
+	siteSettings, err := getSiteSettings()
+	if err != nil {
+		return err
+	}
+
+	path := c.Request().URL.Path
+	rest := strings.Trim(strings.TrimPrefix(path, "/"+page.BlogPath), "/")
+	if rest == "" {
+		return renderPostList(c, siteSettings, postListing{
+			title: render.BlogTitle,
+			path:  "/" + page.BlogPath,
+			list: func(pageNumber int) ([]page.Page, int64, error) {
+				return page.ListPublishedPosts(nil, nil, pageNumber, PostsPerPage)
+			},
+		})
+	}
+	match := archivePattern.FindStringSubmatch(rest)
+	if match == nil {
+		return renderPath(c, siteSettings, path)
+	}
+
+	year, _ := strconv.Atoi(match[1])
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(1, 0, 0)
+	title := fmt.Sprintf("Posts from %d", year)
+	if match[2] != "" {
+		month, _ := strconv.Atoi(match[2])
+		if month < 1 || month > 12 {
+			return renderNotFound(c, siteSettings)
+		}
+		from = from.AddDate(0, month-1, 0)
+		to = from.AddDate(0, 1, 0)
+		title = fmt.Sprintf("Posts from %s", from.Format("January 2006"))
+	}
+	return renderPostList(c, siteSettings, postListing{
+		title:          title,
+		path:           "/" + page.BlogPath + "/" + rest,
+		breadcrumbs:    []render.Breadcrumb{{Title: render.BlogTitle, Url: "/" + page.BlogPath}},
+		requireContent: true,
+		list: func(pageNumber int) ([]page.Page, int64, error) {
+			return page.ListPublishedPosts(&from, &to, pageNumber, PostsPerPage)
+		},
+	})
+}
+
+// renderPostList serves one page of a listing, as picked with the page query parameter
+func renderPostList(c echo.Context, siteSettings *settings.Settings, listing postListing) error {
+	pageNumber := 1
+	if rawPage := c.QueryParam("page"); rawPage != "" {
+		var err error
+		pageNumber, err = strconv.Atoi(rawPage)
+		if err != nil || pageNumber < 1 {
+			return renderNotFound(c, siteSettings)
+		}
+	}
+
+	posts, totalCount, err := listing.list(pageNumber - 1)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	// The first page is always there, if only to say there is nothing yet, unless the listing has to have content
+	if len(posts) < 1 && (pageNumber > 1 || listing.requireContent) {
+		return renderNotFound(c, siteSettings)
+	}
+
+	var authorIds []int
+	for _, post := range posts {
+		if post.AuthorId != nil {
+			authorIds = append(authorIds, *post.AuthorId)
+		}
+	}
+	authors, err := user.GetDisplayNames(authorIds)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	list := render.PostList{
+		Heading: listing.title,
+		Posts:   make([]render.PostSummary, len(posts)),
+	}
+	for i := range posts {
+		list.Posts[i] = render.NewPostSummary(&posts[i], authors)
+	}
+	if pageNumber > 1 {
+		list.NewerUrl = pagedPath(listing.path, pageNumber-1)
+	}
+	if int64(pageNumber*PostsPerPage) < totalCount {
+		list.OlderUrl = pagedPath(listing.path, pageNumber+1)
+	}
+
+	view, err := render.NewPostListView(siteSettings.SiteName, listing.title, pagedPath(listing.path, pageNumber), list)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	view.Breadcrumbs = listing.breadcrumbs
+	return renderView(c, siteSettings, view)
+}
+
+// pagedPath links to a page of a post listing, leaving the first page at the plain path
+func pagedPath(path string, pageNumber int) string {
+	if pageNumber <= 1 {
+		return path
+	}
+	return fmt.Sprintf("%s?page=%d", path, pageNumber)
+}
@@ -0,0 +1,151 @@
+/*
+Package public is for routes serving the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/conf"
+	"github.com/pgray64/tinypress/enum/termtype"
+	"github.com/pgray64/tinypress/service/feed"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/taxonomy"
+	"github.com/pgray64/tinypress/service/user"
+	"net/http"
+	"strings"
+)
+
+const (
+	rssFormat  = "rss"
+	atomFormat = "atom"
+)
+
+// RenderFeed serves the site-wide feeds at /feed/rss and /feed/atom, and the feeds of a term at paths like
+// /feed/rss/category/news or /feed/atom/tag/golang. Responses carry an ETag and Last-Modified, so polling a feed that
+// hasn't changed gets a 304.
+func RenderFeed(c echo.Context) error {
+	siteSettings, err := getSiteSettings()
+	if err != nil {
+		return err
+	}
+
+	segments := strings.SplitN(strings.Trim(c.Request().URL.Path, "/"), "/", 3)
+	if len(segments) < 2 || (segments[1] != rssFormat && segments[1] != atomFormat) {
+		return renderNotFound(c, siteSettings)
+	}
+	baseUrl := siteBaseUrl(c)
+	siteFeed := feed.Feed{
+		SiteName:    siteSettings.SiteName,
+		Title:       siteSettings.SiteName,
+		Description: "Latest from " + siteSettings.SiteName,
+		Link:        baseUrl + "/",
+		SelfUrl:     baseUrl + c.Request().URL.Path,
+	}
+
+	var pages []page.Page
+	if len(segments) == 3 {
+		termSegments := strings.SplitN(segments[2], "/", 2)
+		if len(termSegments) < 2 || (termSegments[0] != taxonomy.CategoryPath && termSegments[0] != taxonomy.TagPath) {
+			return renderNotFound(c, siteSettings)
+		}
+		termType := termtype.Tag
+		if termSegments[0] == taxonomy.CategoryPath {
+			termType = termtype.Category
+		}
+		term, err := taxonomy.ResolveArchive(termType, termSegments[1])
+		if err != nil {
+			return echo.ErrInternalServerError
+		}
+		if term == nil {
+			return renderNotFound(c, siteSettings)
+		}
+		siteFeed.Title = siteSettings.SiteName + " - " + term.Name
+		siteFeed.Description = "Latest in " + term.Name + " from " + siteSettings.SiteName
+		siteFeed.Link = baseUrl + term.Permalink()
+		if pages, _, err = taxonomy.ListPublished(term, 0, feed.Size); err != nil {
+			return echo.ErrInternalServerError
+		}
+	} else if pages, err = page.ListLatestPublished(feed.Size); err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	var authorIds []int
+	for _, row := range pages {
+		if row.AuthorId != nil {
+			authorIds = append(authorIds, *row.AuthorId)
+		}
+	}
+	authors, err := user.GetDisplayNames(authorIds)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	siteFeed.Items = make([]feed.Item, len(pages))
+	for i := range pages {
+		siteFeed.Items[i] = toFeedItem(&pages[i], baseUrl, authors)
+	}
+
+	var buf bytes.Buffer
+	contentType := "application/rss+xml; charset=utf-8"
+	if segments[1] == atomFormat {
+		contentType = "application/atom+xml; charset=utf-8"
+		err = feed.WriteAtom(&buf, &siteFeed)
+	} else {
+		err = feed.WriteRss(&buf, &siteFeed)
+	}
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	// ServeContent takes care of If-None-Match and If-Modified-Since
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, contentType)
+	res.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(buf.Bytes())))
+	res.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(res, c.Request(), "", siteFeed.Updated(), bytes.NewReader(buf.Bytes()))
+	return nil
+}
+
+func toFeedItem(published *page.Page, baseUrl string, authors map[int]string) feed.Item {
+	item := feed.Item{
+		Title:   published.Title,
+		Link:    baseUrl + published.Permalink(),
+		Summary: published.Excerpt,
+	}
+	if item.Summary == "" {
+		item.Summary = published.MetaDescription
+	}
+	if date := published.DisplayDate(); date != nil {
+		item.Published = *date
+	}
+	item.Updated = item.Published
+	if published.PublishedAt != nil && published.PublishedAt.After(item.Updated) {
+		// Republishing updates an item, without moving it in the feed
+		item.Updated = *published.PublishedAt
+	}
+	if published.AuthorId != nil {
+		item.Author = authors[*published.AuthorId]
+	}
+	return item
+}
+
+// siteBaseUrl is the configured site URL, or the address the request came in on if none is set, since feeds need
+// absolute links
+func siteBaseUrl(c echo.Context) string {
+	if conf.Secrets.SiteUrl != "" {
+		return strings.TrimRight(conf.Secrets.SiteUrl, "/")
+	}
+	return c.Scheme() + "://" + c.Request().Host
+}
@@ -0,0 +1,87 @@
+/*
+Package public is for routes serving the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/media"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// storedNamePattern matches uploads, as hash.ext, and their resized copies, as hash-123w.ext
+var storedNamePattern = regexp.MustCompile(`^([0-9a-f]{64})(?:-([0-9]+)w)?\.[a-z]+$`)
+
+// ServeMedia serves uploaded files and their resized copies. Their names are the hash of the original content, so they
+// can be cached for good.
+func ServeMedia(c echo.Context) error {
+	storedName := c.Param("file")
+	match := storedNamePattern.FindStringSubmatch(storedName)
+	if match == nil {
+		return echo.ErrNotFound
+	}
+
+	var expectedName, mimeType, etag string
+	cacheControl := "public, max-age=31536000, immutable"
+	var modified time.Time
+	if match[2] == "" {
+		row, err := media.GetByHash(match[1])
+		if err != nil {
+			return echo.ErrInternalServerError
+		}
+		if row == nil {
+			return echo.ErrNotFound
+		}
+		expectedName, mimeType, etag, modified = row.StoredName(), row.MimeType, row.Hash, row.CreatedAt
+	} else {
+		width, err := strconv.Atoi(match[2])
+		if err != nil {
+			return echo.ErrNotFound
+		}
+		variant, err := media.GetVariant(match[1], width)
+		if err != nil {
+			return echo.ErrInternalServerError
+		}
+		if variant == nil {
+			return echo.ErrNotFound
+		}
+		// Copies are remade in place when the image settings change, so they can't be cached for good
+		expectedName, mimeType, modified = variant.StoredName(), variant.MimeType, variant.UpdatedAt
+		etag = match[1] + "-" + match[2] + "-" + strconv.FormatInt(variant.UpdatedAt.Unix(), 10)
+		cacheControl = "public, max-age=86400"
+	}
+	// Only the exact name is served, and deleted media is gone even if its file is still around
+	if expectedName != storedName {
+		return echo.ErrNotFound
+	}
+	file, err := media.Open(storedName)
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	defer file.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, mimeType)
+	res.Header().Set("Cache-Control", cacheControl)
+	res.Header().Set("ETag", `"`+etag+`"`)
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	if mimeType == "image/svg+xml" {
+		// Uploads are checked for scripts, but opening an SVG directly shouldn't be able to run any regardless
+		res.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox")
+	}
+	http.ServeContent(res, c.Request(), "", modified, file)
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+Package public is for routes serving the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"bytes"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/block"
+	"github.com/pgray64/tinypress/service/media"
+	"github.com/pgray64/tinypress/service/menu"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/render"
+	"github.com/pgray64/tinypress/service/settings"
+	"github.com/pgray64/tinypress/service/theme"
+	"github.com/pgray64/tinypress/service/user"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// isAppPath checks for client-side routes of the admin app in web/tinypress, which the static middleware serves
+func isAppPath(path string) bool {
+	firstSegment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	return page.IsReservedSlug(firstSegment)
+}
+
+// RenderPage serves the published revision of a page to site visitors. It runs behind the static middleware, so
+// returning echo.ErrNotFound hands the request to the admin app, while a missing page gets a real 404 response.
+func RenderPage(c echo.Context) error {
+	path := c.Request().URL.Path
+	if isAppPath(path) {
+		return echo.ErrNotFound
+	}
+
+	siteSettings, err := getSiteSettings()
+	if err != nil {
+		return err
+	}
+	return renderPath(c, siteSettings, path)
+}
+
+// getSiteSettings returns echo.ErrNotFound if the site has not been set up yet, so that the admin app takes over
+func getSiteSettings() (*settings.Settings, error) {
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, echo.ErrNotFound
+		}
+		return nil, echo.ErrInternalServerError
+	}
+	return siteSettings, nil
+}
+
+// renderPath serves the published page or post at a path
+func renderPath(c echo.Context, siteSettings *settings.Settings, path string) error {
+	resolvedPage, isAlias, err := page.ResolvePath(path)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if resolvedPage == nil {
+		return renderNotFound(c, siteSettings)
+	}
+	publishedPage, revision, err := page.GetPublishedPage(resolvedPage.ID)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if publishedPage == nil {
+		return renderNotFound(c, siteSettings)
+	}
+	if isAlias {
+		// Send visitors and search engines to the current address of the page
+		return c.Redirect(http.StatusMovedPermanently, publishedPage.Permalink())
+	}
+
+	ancestors, err := page.GetAncestors(publishedPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+
+	// Fill in shared blocks, so that publishing a block updates every page using it
+	expanded := *revision
+	blockHtml, blockCss, err := block.Expand(revision.RenderedHtml)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	expanded.RenderedHtml, err = media.AddSrcsets(blockHtml)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	expanded.RenderedCss += blockCss
+
+	view := render.NewPageView(siteSettings.SiteName, publishedPage, &expanded, ancestors)
+	if publishedPage.AuthorId != nil {
+		names, err := user.GetDisplayNames([]int{*publishedPage.AuthorId})
+		if err != nil {
+			return echo.ErrInternalServerError
+		}
+		view.Author = names[*publishedPage.AuthorId]
+	}
+	return renderView(c, siteSettings, view)
+}
+
+// renderView fills in the site menus and renders a view with the active theme
+func renderView(c echo.Context, siteSettings *settings.Settings, view render.PageView) error {
+	menus, err := menu.ResolveMenus()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	view.Menus = menus
+
+	var buf bytes.Buffer
+	if err = render.RenderPage(&buf, theme.Get(siteSettings.ActiveTheme), view); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
+
+// renderNotFound writes the 404 page itself, since an echo.ErrNotFound would be turned into the admin app by the
+// static middleware's HTML5 fallback
+func renderNotFound(c echo.Context, siteSettings *settings.Settings) error {
+	return renderErrorPage(c, siteSettings, http.StatusNotFound, render.RenderNotFound)
+}
+
+// renderErrorPage renders an error page with the active theme and the site menus. Without settings, the bare error
+// page is rendered, and failing to resolve the menus only leaves them out, so that a 404 doesn't turn into a 500.
+func renderErrorPage(c echo.Context, siteSettings *settings.Settings, status int,
+	renderError func(io.Writer, *theme.Theme, render.PageView) error) error {
+	var siteTheme *theme.Theme
+	var view render.PageView
+	if siteSettings != nil {
+		siteTheme = theme.Get(siteSettings.ActiveTheme)
+		view.SiteName = siteSettings.SiteName
+		menus, err := menu.ResolveMenus()
+		if err != nil {
+			c.Logger().Error("Failed to resolve menus for error page: ", err)
+		}
+		view.Menus = menus
+	}
+
+	var buf bytes.Buffer
+	if err := renderError(&buf, siteTheme, view); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.HTMLBlob(status, buf.Bytes())
+}
@@ -0,0 +1,81 @@
+/*
+Package public is for routes serving the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/redirect"
+	"github.com/pgray64/tinypress/service/render"
+	"github.com/pgray64/tinypress/service/settings"
+	"github.com/pgray64/tinypress/service/taxonomy"
+	"net/http"
+	"strings"
+)
+
+// RedirectMiddleware applies redirects from the redirect manager before static files or pages are served
+func RedirectMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			return next(c)
+		}
+		// Skip the admin app, the API and generated files, so that a redirect saved before they were reserved can't lock
+		// anyone out. Term archives are the exception, since renaming a term redirects its old archive address.
+		if redirect.IsReservedSource(req.URL.Path) && !isArchivePath(req.URL.Path) {
+			return next(c)
+		}
+
+		match, err := redirect.FindBySourcePath(req.URL.Path)
+		if err != nil {
+			// Don't take the rest of the site down with the redirect table
+			c.Logger().Error("Failed to look up redirect: ", err)
+			return next(c)
+		}
+		if match == nil {
+			return next(c)
+		}
+		redirect.RecordHit(match.ID)
+
+		if match.StatusCode == http.StatusGone {
+			return renderGone(c)
+		}
+		target := match.TargetPath
+		if match.TargetPageId != nil {
+			targetPage, err := page.GetPage(*match.TargetPageId)
+			if err != nil {
+				return echo.ErrInternalServerError
+			}
+			if targetPage == nil {
+				// Target page has since been deleted
+				return next(c)
+			}
+			target = targetPage.Permalink()
+		}
+		if req.URL.RawQuery != "" && !strings.Contains(target, "?") {
+			target += "?" + req.URL.RawQuery
+		}
+		return c.Redirect(match.StatusCode, target)
+	}
+}
+
+func isArchivePath(path string) bool {
+	return strings.HasPrefix(path, "/"+taxonomy.CategoryPath+"/") || strings.HasPrefix(path, "/"+taxonomy.TagPath+"/")
+}
+
+func renderGone(c echo.Context) error {
+	// Settings that can't be read leave siteSettings nil, which still gives a 410, only without the theme
+	siteSettings, _ := settings.GetSettings()
+	return renderErrorPage(c, siteSettings, http.StatusGone, render.RenderGone)
+}
@@ -0,0 +1,72 @@
+/*
+Package public is for routes serving the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/enum/pagetype"
+	"github.com/pgray64/tinypress/service/page"
+	"math"
+	"net/http"
+	"strings"
+)
+
+const SearchResultsPerPage = 10
+
+type searchRequest struct {
+	Query string `query:"q" validate:"required,max=200"`
+	Page  int    `query:"page" validate:"min=0"`
+}
+type searchResultItem struct {
+	Title    string            `json:"title"`
+	Url      string            `json:"url"`
+	PageType pagetype.PageType `json:"pageType"`
+	// Snippet is HTML with the matching words in <mark> tags, and everything else escaped
+	Snippet string `json:"snippet"`
+}
+type searchResult struct {
+	Results   []searchResultItem `json:"results"`
+	PageCount int64              `json:"pageCount"`
+}
+
+// Search looks through the published site for visitors. It is a GET, unlike the rest of the API, so that it can be
+// called from themes without a CSRF token.
+func Search(c echo.Context) error {
+	request := new(searchRequest)
+	if err := c.Bind(request); err != nil {
+		return echo.ErrInternalServerError
+	}
+	request.Query = strings.TrimSpace(request.Query)
+	if err := c.Validate(request); err != nil {
+		return echo.ErrBadRequest
+	}
+
+	results, totalCount, err := page.SearchPublished(request.Query, request.Page, SearchResultsPerPage)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var items = make([]searchResultItem, len(results))
+	for i, row := range results {
+		items[i] = searchResultItem{
+			Title:    row.Title,
+			Url:      "/" + row.Path,
+			PageType: row.PageType,
+			Snippet:  row.Snippet,
+		}
+	}
+	return c.JSON(http.StatusOK, searchResult{
+		Results:   items,
+		PageCount: int64(math.Ceil(float64(totalCount) / float64(SearchResultsPerPage))),
+	})
+}
@@ -0,0 +1,105 @@
+/*
+Package public is for routes serving the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"bytes"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/media"
+	"github.com/pgray64/tinypress/service/sitemap"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const defaultRobotsTxt = `User-agent: *
+Disallow: /api/
+`
+
+// sitemapPartPattern matches the numbered parts of a sitemap too large for one file, after the sitemap- prefix
+var sitemapPartPattern = regexp.MustCompile(`^([0-9]+)\.xml$`)
+
+// IsGeneratedFile skips the static middleware for files that are generated or uploaded rather than taken from the
+// admin app build. Its HTML5 fallback would otherwise answer a missing file with the admin app and a 200.
+func IsGeneratedFile(c echo.Context) bool {
+	path := c.Request().URL.Path
+	return sitemap.IsGeneratedPath(path) || strings.HasPrefix(path, media.UrlPrefix)
+}
+
+// RobotsTxt serves the rules from the site settings, pointing crawlers at the sitemap
+func RobotsTxt(c echo.Context) error {
+	siteSettings, err := getSiteSettings()
+	if err != nil {
+		return err
+	}
+	if siteSettings.DiscourageSearchEngines {
+		return c.String(http.StatusOK, "User-agent: *\nDisallow: /\n")
+	}
+	rules := defaultRobotsTxt
+	if siteSettings.RobotsTxt != "" {
+		rules = strings.TrimSpace(siteSettings.RobotsTxt) + "\n"
+	}
+	return c.String(http.StatusOK, rules+"\nSitemap: "+siteBaseUrl(c)+"/sitemap.xml\n")
+}
+
+// Sitemap serves the list of published pages, or an index of the sitemap files once there are too many for one
+func Sitemap(c echo.Context) error {
+	entryCount, err := sitemap.CountEntries()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	partCount := sitemap.PartCount(entryCount)
+	if partCount > 1 {
+		var buf bytes.Buffer
+		if err = sitemap.WriteIndex(&buf, siteBaseUrl(c), partCount); err != nil {
+			return echo.ErrInternalServerError
+		}
+		return c.Blob(http.StatusOK, echo.MIMEApplicationXMLCharsetUTF8, buf.Bytes())
+	}
+	return renderSitemapPart(c, 0)
+}
+
+// SitemapPart serves one of the files listed in the sitemap index, like /sitemap-2.xml
+func SitemapPart(c echo.Context) error {
+	match := sitemapPartPattern.FindStringSubmatch(c.Param("part"))
+	if match == nil {
+		// Slugs can't contain dots, so this is a page that happens to start with sitemap-
+		return RenderPage(c)
+	}
+	part, err := strconv.Atoi(match[1])
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	entryCount, err := sitemap.CountEntries()
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if part < 1 || part > sitemap.PartCount(entryCount) {
+		return echo.ErrNotFound
+	}
+	return renderSitemapPart(c, part-1)
+}
+
+func renderSitemapPart(c echo.Context, part int) error {
+	entries, err := sitemap.ListEntries(part)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var buf bytes.Buffer
+	if err = sitemap.WriteUrlSet(&buf, siteBaseUrl(c), entries); err != nil {
+		return echo.ErrInternalServerError
+	}
+	return c.Blob(http.StatusOK, echo.MIMEApplicationXMLCharsetUTF8, buf.Bytes())
+}
@@ -0,0 +1,68 @@
+/*
+Package public is for routes serving the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/enum/termtype"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/render"
+	"github.com/pgray64/tinypress/service/taxonomy"
+	"strings"
+)
+
+// RenderTermArchive lists the published pages and posts in a category or with a tag, newest first. Renamed terms are
+// sent to their new address by the redirect middleware before getting here.
+func RenderTermArchive(c echo.Context) error {
+	siteSettings, err := getSiteSettings()
+	if err != nil {
+		return err
+	}
+
+	segments := strings.SplitN(strings.Trim(c.Request().URL.Path, "/"), "/", 2)
+	if len(segments) < 2 {
+		return renderNotFound(c, siteSettings)
+	}
+	termType := termtype.Tag
+	if segments[0] == taxonomy.CategoryPath {
+		termType = termtype.Category
+	}
+	term, err := taxonomy.ResolveArchive(termType, segments[1])
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	if term == nil {
+		return renderNotFound(c, siteSettings)
+	}
+
+	ancestors, err := taxonomy.GetAncestors(term)
+	if err != nil {
+		return echo.ErrInternalServerError
+	}
+	var breadcrumbs []render.Breadcrumb
+	for i := range ancestors {
+		breadcrumbs = append(breadcrumbs, render.Breadcrumb{
+			Title: ancestors[i].Name,
+			Url:   ancestors[i].Permalink(),
+		})
+	}
+	return renderPostList(c, siteSettings, postListing{
+		title:       term.Name,
+		path:        term.Permalink(),
+		breadcrumbs: breadcrumbs,
+		list: func(pageNumber int) ([]page.Page, int64, error) {
+			return taxonomy.ListPublished(term, pageNumber, PostsPerPage)
+		},
+	})
+}
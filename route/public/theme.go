@@ -0,0 +1,43 @@
+/*
+Package public is for routes serving the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package public
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pgray64/tinypress/service/settings"
+	"github.com/pgray64/tinypress/service/theme"
+	"gorm.io/gorm"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ThemeAsset serves the fonts, stylesheets and images of the active theme under /theme-assets/
+func ThemeAsset(c echo.Context) error {
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.ErrNotFound
+		}
+		return echo.ErrInternalServerError
+	}
+	// Cleaning as an absolute path keeps the file inside the assets directory
+	assetPath := path.Clean("/" + c.Param("*"))
+	file := filepath.Join(theme.AssetsDirectory(siteSettings.ActiveTheme), filepath.FromSlash(assetPath))
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		return renderNotFound(c, siteSettings)
+	}
+	return c.File(file)
+}
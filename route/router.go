@@ -27,6 +27,9 @@ import (
 	"github.com/pgray64/tinypress/route/admin"
 	"github.com/pgray64/tinypress/route/editor"
 	"github.com/pgray64/tinypress/route/entrance"
+	"github.com/pgray64/tinypress/route/public"
+	"github.com/pgray64/tinypress/service/editlock"
+	"github.com/pgray64/tinypress/service/page"
 	"net/http"
 	"strings"
 )
@@ -61,8 +64,19 @@ func InitRoutes() *echo.Echo {
 		e.Logger.Fatal("Failed to connect to database: ", err)
 	}
 
+	// Edit locks live in Redis when it is configured, otherwise in Postgres
+	if err := editlock.InitStore(conf.Secrets.RedisConn); err != nil {
+		e.Logger.Fatal("Failed to connect to Redis: ", err)
+	}
+
 	// Register validator
-	e.Validator = &CustomValidator{validator: validator.New()}
+	validate := validator.New()
+	if err := validate.RegisterValidation("slug", func(fl validator.FieldLevel) bool {
+		return page.IsValidSlug(fl.Field().String())
+	}); err != nil {
+		e.Logger.Fatal("Failed to register validators: ", err)
+	}
+	e.Validator = &CustomValidator{validator: validate}
 
 	// CSRF protection
 	e.Use(middleware.CSRFWithConfig(middleware.CSRFConfig{
@@ -71,12 +85,17 @@ func InitRoutes() *echo.Echo {
 		CookieSecure:   false,
 		CookieHTTPOnly: false,
 	}))
+	// Redirects take precedence over static content and pages
+	e.Use(public.RedirectMiddleware)
+
 	// Static content
 	e.Use(middleware.StaticWithConfig(middleware.StaticConfig{
 		Root:   "web/tinypress/build",
 		Index:  "index.html",
 		Browse: false,
 		HTML5:  true,
+		// The admin app build has its own robots.txt, which the generated one replaces, and missing media gets a real 404
+		Skipper: public.IsGeneratedFile,
 	}))
 
 	/***************************************** AUTHENTICATED ROUTES ***************************************************/
@@ -87,11 +106,87 @@ func InitRoutes() *echo.Echo {
 	authenticatedRoutes.POST("account/sign-out", account.SignOut)
 
 	/***** EDITOR ROUTES *****/
+	// Reviewers and publishers need to read content that they can't edit
+	readContentMiddleware := authentication.RequireAnyProductFeatureMiddleware(productfeature.AddEditContent, productfeature.ReviewContent, productfeature.PublishContent)
 	authenticatedRoutes.POST("page-editor/create", editor.CreatePage, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
-	authenticatedRoutes.POST("page-editor/get-page-with-draft", editor.GetPageWithDraft, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/get-page-with-draft", editor.GetPageWithDraft, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/update-slug", editor.UpdateSlug, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/move", editor.MovePage, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/get-page-tree", editor.GetPageTree, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/list-layouts", editor.ListLayouts, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/update-metadata", editor.UpdateMetadata, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
 	authenticatedRoutes.POST("page-editor/save-draft", editor.SaveDraft, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
-	authenticatedRoutes.POST("page-editor/publish-draft", editor.PublishDraft, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
-	authenticatedRoutes.POST("page-editor/list-recently-edited", editor.ListRecentlyEditedPages, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/publish-draft", editor.PublishDraft, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("page-editor/schedule-draft", editor.ScheduleDraft, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("page-editor/list-schedules", editor.ListSchedules, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/cancel-schedule", editor.CancelSchedule, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("page-editor/unpublish", editor.UnpublishPage, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("page-editor/trash", editor.TrashPage, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/list-trash", editor.ListTrash, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/restore", editor.RestorePage, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/purge", editor.PurgePage, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("page-editor/acquire-lock", editor.AcquireEditLock, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/heartbeat-lock", editor.HeartbeatEditLock, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/release-lock", editor.ReleaseEditLock, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/break-lock", editor.BreakEditLock, authentication.RequireAnyProductFeatureMiddleware(productfeature.ManageUsers, productfeature.BreakEditLocks))
+	authenticatedRoutes.POST("page-editor/submit-for-review", editor.SubmitForReview, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/request-changes", editor.RequestChanges, authentication.RequireProductFeatureMiddleware(productfeature.ReviewContent))
+	authenticatedRoutes.POST("page-editor/approve-draft", editor.ApproveDraft, authentication.RequireProductFeatureMiddleware(productfeature.ReviewContent))
+	authenticatedRoutes.POST("page-editor/add-review-comment", editor.AddReviewComment, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/list-review-history", editor.ListReviewHistory, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/set-terms", editor.SetPageTerms, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("page-editor/search", editor.SearchPages, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/list-recently-edited", editor.ListRecentlyEditedPages, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/list-revisions", editor.ListRevisions, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/get-revision", editor.GetRevision, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/compare-revisions", editor.CompareRevisions, readContentMiddleware)
+	authenticatedRoutes.POST("page-editor/restore-revision", editor.RestoreRevision, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+
+	// Posts are pages too, so most post editor routes share their handlers with the page editor
+	authenticatedRoutes.POST("post-editor/create", editor.CreatePost, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/get-post-with-draft", editor.GetPostWithDraft, readContentMiddleware)
+	authenticatedRoutes.POST("post-editor/update-post-details", editor.UpdatePostDetails, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/list-recently-edited", editor.ListRecentlyEditedPosts, readContentMiddleware)
+	authenticatedRoutes.POST("post-editor/set-terms", editor.SetPageTerms, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/update-slug", editor.UpdateSlug, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/update-metadata", editor.UpdateMetadata, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/save-draft", editor.SaveDraft, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/publish-draft", editor.PublishDraft, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("post-editor/schedule-draft", editor.ScheduleDraft, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("post-editor/list-schedules", editor.ListSchedules, readContentMiddleware)
+	authenticatedRoutes.POST("post-editor/cancel-schedule", editor.CancelSchedule, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("post-editor/unpublish", editor.UnpublishPage, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("post-editor/trash", editor.TrashPage, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/acquire-lock", editor.AcquireEditLock, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/heartbeat-lock", editor.HeartbeatEditLock, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/release-lock", editor.ReleaseEditLock, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/submit-for-review", editor.SubmitForReview, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("post-editor/request-changes", editor.RequestChanges, authentication.RequireProductFeatureMiddleware(productfeature.ReviewContent))
+	authenticatedRoutes.POST("post-editor/approve-draft", editor.ApproveDraft, authentication.RequireProductFeatureMiddleware(productfeature.ReviewContent))
+	authenticatedRoutes.POST("post-editor/add-review-comment", editor.AddReviewComment, readContentMiddleware)
+	authenticatedRoutes.POST("post-editor/list-review-history", editor.ListReviewHistory, readContentMiddleware)
+	authenticatedRoutes.POST("post-editor/list-revisions", editor.ListRevisions, readContentMiddleware)
+	authenticatedRoutes.POST("post-editor/get-revision", editor.GetRevision, readContentMiddleware)
+	authenticatedRoutes.POST("post-editor/compare-revisions", editor.CompareRevisions, readContentMiddleware)
+	authenticatedRoutes.POST("post-editor/restore-revision", editor.RestoreRevision, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+
+	authenticatedRoutes.POST("block-editor/create", editor.CreateBlock, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("block-editor/get-block-with-draft", editor.GetBlockWithDraft, readContentMiddleware)
+	authenticatedRoutes.POST("block-editor/list-blocks", editor.ListBlocks, readContentMiddleware)
+	authenticatedRoutes.POST("block-editor/save-draft", editor.SaveBlockDraft, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("block-editor/publish-draft", editor.PublishBlockDraft, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("block-editor/rename", editor.RenameBlock, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("block-editor/delete", editor.DeleteBlock, authentication.RequireProductFeatureMiddleware(productfeature.PublishContent))
+	authenticatedRoutes.POST("block-editor/list-revisions", editor.ListBlockRevisions, readContentMiddleware)
+	authenticatedRoutes.POST("block-editor/get-revision", editor.GetBlockRevision, readContentMiddleware)
+	authenticatedRoutes.POST("block-editor/restore-revision", editor.RestoreBlockRevision, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("block-editor/where-used", editor.WhereUsed, readContentMiddleware)
+
+	authenticatedRoutes.POST("media-library/upload", editor.UploadMedia, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("media-library/list-media", editor.ListMedia, readContentMiddleware)
+	authenticatedRoutes.POST("media-library/delete-media", editor.DeleteMedia, authentication.RequireProductFeatureMiddleware(productfeature.AddEditContent))
+	authenticatedRoutes.POST("media-library/get-usage", editor.GetMediaUsage, readContentMiddleware)
+	authenticatedRoutes.POST("media-library/list-orphans", editor.ListOrphanedMedia, readContentMiddleware)
 
 	/***** ADMIN ROUTES *****/
 	authenticatedRoutes.POST("admin/users/add-user", admin.AddUser, authentication.RequireProductFeatureMiddleware(productfeature.ManageUsers))
@@ -100,15 +195,55 @@ func InitRoutes() *echo.Echo {
 	authenticatedRoutes.POST("admin/users/delete-user", admin.DeleteUser, authentication.RequireProductFeatureMiddleware(productfeature.ManageUsers))
 	authenticatedRoutes.POST("admin/users/update-user", admin.UpdateUser, authentication.RequireProductFeatureMiddleware(productfeature.ManageUsers))
 
+	authenticatedRoutes.POST("admin/redirects/list-redirects", admin.ListRedirects, authentication.RequireProductFeatureMiddleware(productfeature.ManageRedirects))
+	authenticatedRoutes.POST("admin/redirects/get-redirect", admin.GetRedirect, authentication.RequireProductFeatureMiddleware(productfeature.ManageRedirects))
+	authenticatedRoutes.POST("admin/redirects/add-redirect", admin.AddRedirect, authentication.RequireProductFeatureMiddleware(productfeature.ManageRedirects))
+	authenticatedRoutes.POST("admin/redirects/update-redirect", admin.UpdateRedirect, authentication.RequireProductFeatureMiddleware(productfeature.ManageRedirects))
+	authenticatedRoutes.POST("admin/redirects/delete-redirect", admin.DeleteRedirect, authentication.RequireProductFeatureMiddleware(productfeature.ManageRedirects))
+
+	authenticatedRoutes.POST("admin/menus/list-menus", admin.ListMenus, authentication.RequireProductFeatureMiddleware(productfeature.ManageMenus))
+	authenticatedRoutes.POST("admin/menus/get-menu", admin.GetMenu, authentication.RequireProductFeatureMiddleware(productfeature.ManageMenus))
+	authenticatedRoutes.POST("admin/menus/add-menu", admin.AddMenu, authentication.RequireProductFeatureMiddleware(productfeature.ManageMenus))
+	authenticatedRoutes.POST("admin/menus/rename-menu", admin.RenameMenu, authentication.RequireProductFeatureMiddleware(productfeature.ManageMenus))
+	authenticatedRoutes.POST("admin/menus/save-menu-items", admin.SaveMenuItems, authentication.RequireProductFeatureMiddleware(productfeature.ManageMenus))
+	authenticatedRoutes.POST("admin/menus/delete-menu", admin.DeleteMenu, authentication.RequireProductFeatureMiddleware(productfeature.ManageMenus))
+
+	// Content editors need the list of terms to pick from too
+	authenticatedRoutes.POST("admin/taxonomy/list-terms", admin.ListTerms, authentication.RequireAnyProductFeatureMiddleware(productfeature.ManageTaxonomy, productfeature.AddEditContent, productfeature.ReviewContent, productfeature.PublishContent))
+	authenticatedRoutes.POST("admin/taxonomy/get-term", admin.GetTerm, authentication.RequireProductFeatureMiddleware(productfeature.ManageTaxonomy))
+	authenticatedRoutes.POST("admin/taxonomy/add-term", admin.AddTerm, authentication.RequireProductFeatureMiddleware(productfeature.ManageTaxonomy))
+	authenticatedRoutes.POST("admin/taxonomy/update-term", admin.UpdateTerm, authentication.RequireProductFeatureMiddleware(productfeature.ManageTaxonomy))
+	authenticatedRoutes.POST("admin/taxonomy/delete-term", admin.DeleteTerm, authentication.RequireProductFeatureMiddleware(productfeature.ManageTaxonomy))
+
+	authenticatedRoutes.POST("admin/themes/list-themes", admin.ListThemes, authentication.RequireProductFeatureMiddleware(productfeature.ManageSettings))
+	authenticatedRoutes.POST("admin/themes/activate-theme", admin.ActivateTheme, authentication.RequireProductFeatureMiddleware(productfeature.ManageSettings))
+
 	authenticatedRoutes.GET("admin/site-settings/get-site-settings", admin.GetSiteSettings, authentication.RequireProductFeatureMiddleware(productfeature.ManageSettings))
 	authenticatedRoutes.POST("admin/site-settings/update-general-settings", admin.UpdateGeneralSiteSettings, authentication.RequireProductFeatureMiddleware(productfeature.ManageSettings))
 	authenticatedRoutes.POST("admin/site-settings/update-smtp-settings", admin.UpdateSmtpSettings, authentication.RequireProductFeatureMiddleware(productfeature.ManageSettings))
+	authenticatedRoutes.POST("admin/site-settings/update-seo-settings", admin.UpdateSeoSettings, authentication.RequireProductFeatureMiddleware(productfeature.ManageSettings))
+	authenticatedRoutes.POST("admin/site-settings/update-media-settings", admin.UpdateMediaSettings, authentication.RequireProductFeatureMiddleware(productfeature.ManageSettings))
 
 	/********************************************* PUBLIC ROUTES ******************************************************/
 	publicRoutes := e.Group("/api/public/v1/")
 
 	publicRoutes.POST("site-setup", entrance.SiteSetup)
 	publicRoutes.POST("sign-in", entrance.SignIn)
+	publicRoutes.GET("search", public.Search)
+
+	/********************************************* PUBLIC WEBSITE *****************************************************/
+	e.GET("/theme-assets/*", public.ThemeAsset)
+	e.GET("/media/:file", public.ServeMedia)
+	e.GET("/blog", public.RenderBlog)
+	e.GET("/blog/*", public.RenderBlog)
+	e.GET("/category/*", public.RenderTermArchive)
+	e.GET("/tag/*", public.RenderTermArchive)
+	e.GET("/feed/*", public.RenderFeed)
+	e.GET("/robots.txt", public.RobotsTxt)
+	e.GET("/sitemap.xml", public.Sitemap)
+	e.GET("/sitemap-:part", public.SitemapPart)
+	// Anything that isn't an API route or a file from the admin app build is treated as a published page
+	e.GET("/*", public.RenderPage)
 
 	return e
 }
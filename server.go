@@ -16,6 +16,7 @@ package main
 import (
 	"github.com/pgray64/tinypress/conf"
 	"github.com/pgray64/tinypress/route"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -33,6 +34,14 @@ func main() {
 		}
 	}
 
+	// Commands run against the site and exit, rather than starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate-media" {
+		migrateMedia(e, os.Args[2:])
+		return
+	}
+
+	startBackgroundJobs(e)
+
 	port := ":1323"
 	if len(conf.Secrets.SitePort) > 0 {
 		_, err := strconv.Atoi(conf.Secrets.SitePort)
@@ -0,0 +1,349 @@
+/*
+Package block is for services related to reusable content blocks
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package block
+
+import (
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/user"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Block is a section of content shared between pages. Pages embed it with a placeholder naming its handle, and it is
+// filled in with the published revision of the block whenever a page is rendered.
+type Block struct {
+	ID   int    `gorm:"primaryKey;autoIncrement"`
+	Name string `gorm:"not null;size:255"`
+	// Handle can't change once the block is created, since pages refer to the block by it
+	Handle              string `gorm:"not null;size:100;uniqueIndex:idx_blocks_handle,where:deleted_at is null"`
+	PublishedRevisionId *int
+	CreatedById         *int
+	CreatedBy           user.User `gorm:"PRELOAD:false;foreignKey:CreatedById;constraint:OnDelete:SET NULL"`
+	CreatedAt           time.Time `gorm:"autoCreateTime"`
+	UpdatedAt           time.Time `gorm:"autoUpdateTime"`
+	DeletedAt           gorm.DeletedAt
+}
+
+// BlockRevision contains the content for every version of a block, with the latest one being the current draft
+type BlockRevision struct {
+	ID            int    `gorm:"primaryKey;autoIncrement"`
+	BlockId       int    `gorm:"not null;index:idx_block_revisions_block_id"`
+	RenderedHtml  string `gorm:"not null"`
+	RenderedCss   string `gorm:"not null"`
+	EditorContent string `gorm:"not null"`
+	CreatedById   *int
+	CreatedBy     user.User `gorm:"PRELOAD:false;foreignKey:CreatedById;constraint:OnDelete:SET NULL"`
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	// MediaIndexed is set once the uploads the revision links to are recorded, see media.go
+	MediaIndexed bool `gorm:"not null;default:false"`
+}
+
+const MaxHandleLength = 100
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// placeholderPattern matches [[block:handle]] in the rendered HTML of a page
+var placeholderPattern = regexp.MustCompile(`\[\[block:([a-z0-9]+(?:-[a-z0-9]+)*)\]\]`)
+
+func IsValidHandle(handle string) bool {
+	return len(handle) <= MaxHandleLength && handlePattern.MatchString(handle)
+}
+
+// Placeholder is the text pages include to embed a block
+func Placeholder(handle string) string {
+	return "[[block:" + handle + "]]"
+}
+
+func (block *Block) Create(content *BlockRevision) (isDup bool, err error) {
+	if !IsValidHandle(block.Handle) {
+		return false, errors.New("handle is invalid")
+	}
+	txErr := database.Database.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Create(block); res.Error != nil {
+			return res.Error
+		}
+		content.BlockId = block.ID
+		content.MediaIndexed = true
+		if res := tx.Create(content); res.Error != nil {
+			return res.Error
+		}
+		return indexMediaReferences(tx, content)
+	})
+	var pgErr *pgconn.PgError
+	if txErr != nil && errors.As(txErr, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return true, nil
+	}
+	return false, txErr
+}
+
+// GetBlock returns the non-deleted block, or nil if it does not exist
+func GetBlock(blockId int) (*Block, error) {
+	var blocks []Block
+	selectRes := database.Database.Model(&Block{}).Where(map[string]interface{}{"id": blockId}).Find(&blocks)
+	if selectRes.Error != nil || len(blocks) < 1 {
+		return nil, selectRes.Error
+	}
+	return &blocks[0], nil
+}
+
+func GetBlockWithDraft(blockId int) (*Block, *BlockRevision, error) {
+	block, err := GetBlock(blockId)
+	if err != nil || block == nil {
+		return nil, nil, err
+	}
+	var drafts []BlockRevision
+	selectRes := database.Database.Model(&BlockRevision{}).
+		Where(map[string]interface{}{"block_id": blockId}).
+		Order("id desc").
+		Limit(1).
+		Find(&drafts)
+	if selectRes.Error != nil || len(drafts) < 1 {
+		return nil, nil, selectRes.Error
+	}
+	return block, &drafts[0], nil
+}
+
+func ListBlocks(page int, perPage int) (blocks []Block, totalCount int64, err error) {
+	countRes := database.Database.Model(&Block{}).Count(&totalCount)
+	if countRes.Error != nil {
+		return blocks, 0, countRes.Error
+	}
+	offset := perPage * page
+	selectRes := database.Database.Model(&Block{}).
+		Offset(offset).
+		Limit(perPage).
+		Order("name asc").
+		Find(&blocks)
+	return blocks, totalCount, selectRes.Error
+}
+
+func RenameBlock(blockId int, name string) error {
+	updateRes := database.Database.Model(&Block{}).
+		Where(map[string]interface{}{"id": blockId}).
+		Updates(&Block{Name: name})
+	return updateRes.Error
+}
+
+// DeleteBlock soft-deletes a block. Pages that still embed it render nothing in its place.
+func DeleteBlock(blockId int) error {
+	deleteRes := database.Database.Where(map[string]interface{}{"id": blockId}).Delete(&Block{})
+	return deleteRes.Error
+}
+
+// SaveDraft appends a new revision to a non-deleted block
+func SaveDraft(draft *BlockRevision) error {
+	if draft.ID > 0 {
+		return errors.New("you can only append to drafts")
+	}
+	block, err := GetBlock(draft.BlockId)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		return errors.New("block does not exist")
+	}
+	draft.MediaIndexed = true
+	txErr := database.Database.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Create(draft); res.Error != nil {
+			return res.Error
+		}
+		return indexMediaReferences(tx, draft)
+	})
+	if txErr != nil {
+		return txErr
+	}
+	return touchBlock(draft.BlockId)
+}
+
+func touchBlock(blockId int) error {
+	updateRes := database.Database.Model(&Block{}).
+		Where(map[string]interface{}{"id": blockId}).
+		Updates(&Block{UpdatedAt: time.Now()})
+	return updateRes.Error
+}
+
+// PublishDraft makes a revision the live version of its block, which every page embedding it picks up immediately
+func PublishDraft(draftId int) error {
+	revision, err := GetRevision(draftId)
+	if err != nil {
+		return err
+	}
+	if revision == nil {
+		return errors.New("draft does not exist")
+	}
+	if err = ensureMediaIndexed(database.Database, revision); err != nil {
+		return err
+	}
+	updateRes := database.Database.Model(&Block{}).
+		Where(map[string]interface{}{"id": revision.BlockId}).
+		Updates(&Block{PublishedRevisionId: &revision.ID})
+	return updateRes.Error
+}
+
+// ListRevisions returns the revisions of a block newest first, without their content
+func ListRevisions(blockId int, page int, perPage int) (revisions []BlockRevision, totalCount int64, err error) {
+	countRes := database.Database.Model(&BlockRevision{}).
+		Where(map[string]interface{}{"block_id": blockId}).
+		Count(&totalCount)
+	if countRes.Error != nil {
+		return revisions, 0, countRes.Error
+	}
+	offset := perPage * page
+	selectRes := database.Database.Model(&BlockRevision{}).
+		Select("id", "block_id", "created_by_id", "created_at").
+		Where(map[string]interface{}{"block_id": blockId}).
+		Order("id desc").
+		Offset(offset).
+		Limit(perPage).
+		Find(&revisions)
+	return revisions, totalCount, selectRes.Error
+}
+
+// GetRevision returns a revision of a non-deleted block, or nil if there is none
+func GetRevision(revisionId int) (*BlockRevision, error) {
+	var revisions []BlockRevision
+	selectRes := database.Database.Model(&BlockRevision{}).
+		Joins("inner join blocks on blocks.id = block_revisions.block_id and blocks.deleted_at is null").
+		Where(map[string]interface{}{"block_revisions.id": revisionId}).
+		Find(&revisions)
+	if selectRes.Error != nil || len(revisions) < 1 {
+		return nil, selectRes.Error
+	}
+	return &revisions[0], nil
+}
+
+// RestoreRevision appends a copy of an old revision, making it the current draft again
+func RestoreRevision(revisionId int, userId int) (*BlockRevision, error) {
+	revision, err := GetRevision(revisionId)
+	if err != nil {
+		return nil, err
+	}
+	if revision == nil {
+		return nil, errors.New("revision does not exist")
+	}
+	draft := BlockRevision{
+		BlockId:       revision.BlockId,
+		RenderedHtml:  revision.RenderedHtml,
+		RenderedCss:   revision.RenderedCss,
+		EditorContent: revision.EditorContent,
+		CreatedById:   &userId,
+	}
+	return &draft, SaveDraft(&draft)
+}
+
+// Expand replaces block placeholders in rendered page HTML with the published content of the blocks, and returns the
+// CSS of the blocks used. Placeholders for blocks that are unpublished or deleted are removed. Blocks are expanded
+// once, so a placeholder inside a block is left as it is.
+func Expand(html string) (expandedHtml string, css string, err error) {
+	matches := placeholderPattern.FindAllStringSubmatch(html, -1)
+	if len(matches) < 1 {
+		return html, "", nil
+	}
+	var handles []string
+	for _, match := range matches {
+		handles = append(handles, match[1])
+	}
+	var revisions []struct {
+		Handle       string
+		RenderedHtml string
+		RenderedCss  string
+	}
+	selectRes := database.Database.Model(&BlockRevision{}).
+		Select("blocks.handle", "block_revisions.rendered_html", "block_revisions.rendered_css").
+		Joins("inner join blocks on blocks.published_revision_id = block_revisions.id and blocks.deleted_at is null").
+		Where(map[string]interface{}{"blocks.handle": handles}).
+		Find(&revisions)
+	if selectRes.Error != nil {
+		return "", "", selectRes.Error
+	}
+
+	htmlByHandle := make(map[string]string, len(revisions))
+	var cssBuilder strings.Builder
+	for _, revision := range revisions {
+		htmlByHandle[revision.Handle] = revision.RenderedHtml
+		cssBuilder.WriteString(revision.RenderedCss)
+	}
+	expandedHtml = placeholderPattern.ReplaceAllStringFunc(html, func(placeholder string) string {
+		return htmlByHandle[placeholderPattern.FindStringSubmatch(placeholder)[1]]
+	})
+	return expandedHtml, cssBuilder.String(), nil
+}
+
+// PageUsage is a page that embeds a block, either in its published revision, its current draft or both
+type PageUsage struct {
+	Page        page.Page
+	InPublished bool
+	InDraft     bool
+}
+
+// WhereUsed finds the non-deleted pages embedding a block in their published revision or their current draft
+func WhereUsed(handle string) ([]PageUsage, error) {
+	pattern := "%" + Placeholder(handle) + "%"
+	var published []int
+	selectRes := database.Database.Model(&page.ContentRevision{}).
+		Joins("inner join pages on pages.published_revision_id = content_revisions.id and pages.deleted_at is null").
+		Where("content_revisions.rendered_html like ?", pattern).
+		Pluck("content_revisions.page_id", &published)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	var drafts []int
+	selectRes = database.Database.Model(&page.ContentRevision{}).
+		Joins("inner join pages on pages.id = content_revisions.page_id and pages.deleted_at is null").
+		Where("content_revisions.id = (select max(latest.id) from content_revisions latest where latest.page_id = content_revisions.page_id)").
+		Where("content_revisions.rendered_html like ?", pattern).
+		Pluck("content_revisions.page_id", &drafts)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+
+	usageByPage := make(map[int]*PageUsage)
+	var pageIds []int
+	for _, pageId := range published {
+		usageByPage[pageId] = &PageUsage{InPublished: true}
+		pageIds = append(pageIds, pageId)
+	}
+	for _, pageId := range drafts {
+		if usage, ok := usageByPage[pageId]; ok {
+			usage.InDraft = true
+			continue
+		}
+		usageByPage[pageId] = &PageUsage{InDraft: true}
+		pageIds = append(pageIds, pageId)
+	}
+	if len(pageIds) < 1 {
+		return nil, nil
+	}
+
+	var pages []page.Page
+	selectRes = database.Database.Model(&page.Page{}).
+		Where(map[string]interface{}{"id": pageIds}).
+		Order("title asc").
+		Find(&pages)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	usages := make([]PageUsage, len(pages))
+	for i, row := range pages {
+		usages[i] = *usageByPage[row.ID]
+		usages[i].Page = row
+	}
+	return usages, nil
+}
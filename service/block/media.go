@@ -0,0 +1,151 @@
+/*
+Package block is for services related to reusable content blocks
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package block
+
+import (
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/service/media"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BlockMediaReference records that a block revision links to an upload from the media library, like
+// page.MediaReference does for pages
+type BlockMediaReference struct {
+	RevisionId int           `gorm:"primaryKey"`
+	Revision   BlockRevision `gorm:"PRELOAD:false;constraint:OnDelete:CASCADE"`
+	MediaId    int           `gorm:"primaryKey;index:idx_block_media_references_media_id"`
+	Media      media.Media   `gorm:"PRELOAD:false;constraint:OnDelete:CASCADE"`
+}
+
+// MediaUsage is a block using an upload in its published revision, its current draft or neither, in which case only
+// older revisions have it
+type MediaUsage struct {
+	Block       Block
+	InPublished bool
+	InDraft     bool
+}
+
+// indexMediaReferences records the uploads a new revision links to. Revisions should be saved with MediaIndexed set
+// in the same transaction.
+func indexMediaReferences(tx *gorm.DB, revision *BlockRevision) error {
+	mediaIds, err := media.ReferencedIds(tx, revision.RenderedHtml, revision.RenderedCss)
+	if err != nil || len(mediaIds) < 1 {
+		return err
+	}
+	references := make([]BlockMediaReference, len(mediaIds))
+	for i, mediaId := range mediaIds {
+		references[i] = BlockMediaReference{RevisionId: revision.ID, MediaId: mediaId}
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&references).Error
+}
+
+// ensureMediaIndexed indexes a revision saved before media references were tracked
+func ensureMediaIndexed(tx *gorm.DB, revision *BlockRevision) error {
+	if revision.MediaIndexed {
+		return nil
+	}
+	if err := indexMediaReferences(tx, revision); err != nil {
+		return err
+	}
+	revision.MediaIndexed = true
+	return tx.Model(&BlockRevision{}).
+		Where(map[string]interface{}{"id": revision.ID}).
+		Update("media_indexed", true).Error
+}
+
+// BackfillMediaReferences indexes the revisions saved before media references were tracked
+func BackfillMediaReferences() error {
+	var revisions []BlockRevision
+	batchRes := database.Database.Model(&BlockRevision{}).
+		Select("id", "rendered_html", "rendered_css", "media_indexed").
+		Where(map[string]interface{}{"media_indexed": false}).
+		FindInBatches(&revisions, 100, func(tx *gorm.DB, batch int) error {
+			for i := range revisions {
+				if err := ensureMediaIndexed(database.Database, &revisions[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	return batchRes.Error
+}
+
+// WhereMediaUsed finds the non-deleted blocks using an upload in any of their revisions
+func WhereMediaUsed(mediaId int) ([]MediaUsage, error) {
+	var published []int
+	selectRes := database.Database.Model(&BlockMediaReference{}).
+		Joins("inner join blocks on blocks.published_revision_id = block_media_references.revision_id and blocks.deleted_at is null").
+		Where(map[string]interface{}{"block_media_references.media_id": mediaId}).
+		Pluck("blocks.id", &published)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	var drafts []int
+	selectRes = database.Database.Model(&BlockMediaReference{}).
+		Joins("inner join block_revisions on block_revisions.id = block_media_references.revision_id").
+		Where("block_revisions.id = (select max(latest.id) from block_revisions latest where latest.block_id = block_revisions.block_id)").
+		Where(map[string]interface{}{"block_media_references.media_id": mediaId}).
+		Pluck("block_revisions.block_id", &drafts)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	var history []int
+	selectRes = database.Database.Model(&BlockMediaReference{}).
+		Distinct("block_revisions.block_id").
+		Joins("inner join block_revisions on block_revisions.id = block_media_references.revision_id").
+		Where(map[string]interface{}{"block_media_references.media_id": mediaId}).
+		Pluck("block_revisions.block_id", &history)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+
+	usageByBlock := make(map[int]*MediaUsage)
+	var blockIds []int
+	usageOf := func(blockId int) *MediaUsage {
+		if usage, ok := usageByBlock[blockId]; ok {
+			return usage
+		}
+		usageByBlock[blockId] = &MediaUsage{}
+		blockIds = append(blockIds, blockId)
+		return usageByBlock[blockId]
+	}
+	for _, blockId := range published {
+		usageOf(blockId).InPublished = true
+	}
+	for _, blockId := range drafts {
+		usageOf(blockId).InDraft = true
+	}
+	for _, blockId := range history {
+		usageOf(blockId)
+	}
+	if len(blockIds) < 1 {
+		return nil, nil
+	}
+
+	var blocks []Block
+	selectRes = database.Database.Model(&Block{}).
+		Where(map[string]interface{}{"id": blockIds}).
+		Order("name asc").
+		Find(&blocks)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	usages := make([]MediaUsage, len(blocks))
+	for i, row := range blocks {
+		usages[i] = *usageByBlock[row.ID]
+		usages[i].Block = row
+	}
+	return usages, nil
+}
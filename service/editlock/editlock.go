@@ -0,0 +1,92 @@
+/*
+Package editlock is for services related to exclusive page edit locks
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editlock
+
+import (
+	"time"
+)
+
+// Ttl is how long a lock lasts without a heartbeat from the editor holding it
+const Ttl = 2 * time.Minute
+
+type Lock struct {
+	PageId    int
+	UserId    int
+	ExpiresAt time.Time
+}
+
+// Store keeps leases on pages. Expired leases are treated as if they did not exist.
+type Store interface {
+	// Acquire takes or renews the lock for the user. If someone else holds it, nothing changes and their lock is
+	// returned instead.
+	Acquire(pageId int, userId int, ttl time.Duration) (holder *Lock, err error)
+	// Renew extends the lock if the user still holds it, and reports whether they did. Unlike Acquire, it never takes
+	// a lock that has expired or been broken.
+	Renew(pageId int, userId int, ttl time.Duration) (renewed bool, err error)
+	// Release gives up the lock if the user holds it
+	Release(pageId int, userId int) error
+	// Break removes the lock no matter who holds it
+	Break(pageId int) error
+	// GetLocks returns the current locks among the pages, keyed by page ID
+	GetLocks(pageIds []int) (map[int]Lock, error)
+}
+
+var store Store = &postgresStore{}
+
+// InitStore keeps locks in Redis when a connection is configured, and in Postgres otherwise
+func InitStore(redisConn string) error {
+	if redisConn == "" {
+		store = &postgresStore{}
+		return nil
+	}
+	redisStore, err := newRedisStore(redisConn)
+	if err != nil {
+		return err
+	}
+	store = redisStore
+	return nil
+}
+
+func Acquire(pageId int, userId int) (holder *Lock, err error) {
+	return store.Acquire(pageId, userId, Ttl)
+}
+
+func Renew(pageId int, userId int) (renewed bool, err error) {
+	return store.Renew(pageId, userId, Ttl)
+}
+
+func Release(pageId int, userId int) error {
+	return store.Release(pageId, userId)
+}
+
+func Break(pageId int) error {
+	return store.Break(pageId)
+}
+
+func GetLocks(pageIds []int) (map[int]Lock, error) {
+	return store.GetLocks(pageIds)
+}
+
+// GetLock returns the current lock on a page, or nil if it is free
+func GetLock(pageId int) (*Lock, error) {
+	locks, err := store.GetLocks([]int{pageId})
+	if err != nil {
+		return nil, err
+	}
+	lock, ok := locks[pageId]
+	if !ok {
+		return nil, nil
+	}
+	return &lock, nil
+}
@@ -0,0 +1,85 @@
+/*
+Package editlock is for services related to exclusive page edit locks
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editlock
+
+import (
+	"github.com/pgray64/tinypress/database"
+	"time"
+)
+
+// EditLock is the Postgres storage for locks, used when Redis is not configured
+type EditLock struct {
+	PageId    int       `gorm:"primaryKey;autoIncrement:false"`
+	UserId    int       `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+type postgresStore struct{}
+
+func (s *postgresStore) Acquire(pageId int, userId int, ttl time.Duration) (*Lock, error) {
+	now := time.Now()
+	// Only take over the row if it is ours already or has expired. The row is always updated, to itself otherwise, so
+	// that it comes back with whoever holds the lock after this statement.
+	var row EditLock
+	upsertRes := database.Database.Raw(`insert into edit_locks (page_id, user_id, expires_at) values (?, ?, ?)
+on conflict (page_id) do update set
+user_id = case when edit_locks.user_id = excluded.user_id or edit_locks.expires_at < ? then excluded.user_id else edit_locks.user_id end,
+expires_at = case when edit_locks.user_id = excluded.user_id or edit_locks.expires_at < ? then excluded.expires_at else edit_locks.expires_at end
+returning page_id, user_id, expires_at`, pageId, userId, now.Add(ttl), now, now).Scan(&row)
+	if upsertRes.Error != nil {
+		return nil, upsertRes.Error
+	}
+	if row.UserId == userId {
+		return nil, nil
+	}
+	return &Lock{PageId: row.PageId, UserId: row.UserId, ExpiresAt: row.ExpiresAt}, nil
+}
+
+func (s *postgresStore) Renew(pageId int, userId int, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	updateRes := database.Database.Model(&EditLock{}).
+		Where(map[string]interface{}{"page_id": pageId, "user_id": userId}).
+		Where("expires_at >= ?", now).
+		Update("expires_at", now.Add(ttl))
+	return updateRes.RowsAffected > 0, updateRes.Error
+}
+
+func (s *postgresStore) Release(pageId int, userId int) error {
+	deleteRes := database.Database.Where(map[string]interface{}{"page_id": pageId, "user_id": userId}).Delete(&EditLock{})
+	return deleteRes.Error
+}
+
+func (s *postgresStore) Break(pageId int) error {
+	deleteRes := database.Database.Where(map[string]interface{}{"page_id": pageId}).Delete(&EditLock{})
+	return deleteRes.Error
+}
+
+func (s *postgresStore) GetLocks(pageIds []int) (map[int]Lock, error) {
+	var rows []EditLock
+	locks := make(map[int]Lock)
+	if len(pageIds) < 1 {
+		return locks, nil
+	}
+	selectRes := database.Database.Model(&EditLock{}).
+		Where(map[string]interface{}{"page_id": pageIds}).
+		Where("expires_at >= ?", time.Now()).
+		Find(&rows)
+	if selectRes.Error != nil {
+		return locks, selectRes.Error
+	}
+	for _, row := range rows {
+		locks[row.PageId] = Lock{PageId: row.PageId, UserId: row.UserId, ExpiresAt: row.ExpiresAt}
+	}
+	return locks, nil
+}
@@ -0,0 +1,147 @@
+/*
+Package editlock is for services related to exclusive page edit locks
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package editlock
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redisKeyPrefix = "tinypress:edit-lock:"
+
+// Lock values are "userId:expiresAtUnixMilli", and the key expires along with the lock
+var acquireScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current and string.match(current, '^[^:]+') ~= ARGV[1] then
+	return current
+end
+redis.call('SET', KEYS[1], ARGV[1] .. ':' .. ARGV[2], 'PX', ARGV[3])
+return false
+`)
+
+var renewScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current and string.match(current, '^[^:]+') == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1] .. ':' .. ARGV[2], 'PX', ARGV[3])
+	return 1
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current and string.match(current, '^[^:]+') == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+end
+return false
+`)
+
+type redisStore struct {
+	client *redis.Client
+}
+
+func newRedisStore(redisConn string) (*redisStore, error) {
+	options, err := redis.ParseURL(redisConn)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(options)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+	return &redisStore{client: client}, nil
+}
+
+func redisKey(pageId int) string {
+	return redisKeyPrefix + strconv.Itoa(pageId)
+}
+
+func parseRedisLock(pageId int, value string) (*Lock, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed edit lock")
+	}
+	userId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{PageId: pageId, UserId: userId, ExpiresAt: time.UnixMilli(expiresAt)}, nil
+}
+
+func (s *redisStore) Acquire(pageId int, userId int, ttl time.Duration) (*Lock, error) {
+	expiresAt := time.Now().Add(ttl)
+	res, err := acquireScript.Run(context.Background(), s.client, []string{redisKey(pageId)},
+		strconv.Itoa(userId), strconv.FormatInt(expiresAt.UnixMilli(), 10), ttl.Milliseconds()).Text()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseRedisLock(pageId, res)
+}
+
+func (s *redisStore) Renew(pageId int, userId int, ttl time.Duration) (bool, error) {
+	expiresAt := time.Now().Add(ttl)
+	renewed, err := renewScript.Run(context.Background(), s.client, []string{redisKey(pageId)},
+		strconv.Itoa(userId), strconv.FormatInt(expiresAt.UnixMilli(), 10), ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+func (s *redisStore) Release(pageId int, userId int) error {
+	err := releaseScript.Run(context.Background(), s.client, []string{redisKey(pageId)}, strconv.Itoa(userId)).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+func (s *redisStore) Break(pageId int) error {
+	return s.client.Del(context.Background(), redisKey(pageId)).Err()
+}
+
+func (s *redisStore) GetLocks(pageIds []int) (map[int]Lock, error) {
+	locks := make(map[int]Lock)
+	if len(pageIds) < 1 {
+		return locks, nil
+	}
+	keys := make([]string, len(pageIds))
+	for i, pageId := range pageIds {
+		keys[i] = redisKey(pageId)
+	}
+	values, err := s.client.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		return locks, err
+	}
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		lock, err := parseRedisLock(pageIds[i], str)
+		if err != nil {
+			return locks, err
+		}
+		locks[lock.PageId] = *lock
+	}
+	return locks, nil
+}
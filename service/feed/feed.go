@@ -0,0 +1,192 @@
+/*
+Package feed is for services related to the RSS and Atom feeds of the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package feed
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// Size is how many of the latest items a feed includes
+const Size = 20
+
+// Feed is a list of published content, with every link already made absolute
+type Feed struct {
+	SiteName    string
+	Title       string
+	Description string
+	// Link is the page the feed is for, and SelfUrl the feed itself
+	Link    string
+	SelfUrl string
+	Items   []Item
+}
+
+type Item struct {
+	Title     string
+	Link      string
+	Published time.Time
+	Updated   time.Time
+	Summary   string
+	Author    string
+}
+
+// Updated is when the newest item in the feed last changed, or the zero time for an empty feed
+func (feed *Feed) Updated() time.Time {
+	var updated time.Time
+	for _, item := range feed.Items {
+		if item.Updated.After(updated) {
+			updated = item.Updated
+		}
+	}
+	return updated
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNs  string     `xml:"xmlns:atom,attr"`
+	DcNs    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	AtomLink      rssAtomLink `xml:"atom:link"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem   `xml:"item"`
+}
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description,omitempty"`
+	// RSS wants an email address in author, so the name goes in dc:creator instead
+	Creator string `xml:"dc:creator,omitempty"`
+}
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// WriteRss writes the feed as RSS 2.0
+func WriteRss(w io.Writer, feed *Feed) error {
+	document := rssDocument{
+		Version: "2.0",
+		AtomNs:  "http://www.w3.org/2005/Atom",
+		DcNs:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       feed.Title,
+			Link:        feed.Link,
+			Description: feed.Description,
+			AtomLink: rssAtomLink{
+				Href: feed.SelfUrl,
+				Rel:  "self",
+				Type: "application/rss+xml",
+			},
+			Items: make([]rssItem, len(feed.Items)),
+		},
+	}
+	if updated := feed.Updated(); !updated.IsZero() {
+		document.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+	for i, item := range feed.Items {
+		document.Channel.Items[i] = rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Guid:        rssGuid{IsPermaLink: true, Value: item.Link},
+			PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+			Description: item.Summary,
+			Creator:     item.Author,
+		}
+	}
+	return writeDocument(w, document)
+}
+
+type atomDocument struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	Id        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Summary   string      `xml:"summary,omitempty"`
+	Author    *atomPerson `xml:"author,omitempty"`
+}
+
+// WriteAtom writes the feed as Atom. Entries without an author fall back to the feed's author, which is the site.
+func WriteAtom(w io.Writer, feed *Feed) error {
+	updated := feed.Updated()
+	if updated.IsZero() {
+		// Atom requires a date even when there is nothing in the feed yet
+		updated = time.Unix(0, 0)
+	}
+	document := atomDocument{
+		Title:   feed.Title,
+		Id:      feed.SelfUrl,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: feed.Link, Rel: "alternate", Type: "text/html"},
+			{Href: feed.SelfUrl, Rel: "self", Type: "application/atom+xml"},
+		},
+		Author:  atomPerson{Name: feed.SiteName},
+		Entries: make([]atomEntry, len(feed.Items)),
+	}
+	for i, item := range feed.Items {
+		document.Entries[i] = atomEntry{
+			Title:     item.Title,
+			Id:        item.Link,
+			Link:      atomLink{Href: item.Link, Rel: "alternate", Type: "text/html"},
+			Published: item.Published.UTC().Format(time.RFC3339),
+			Updated:   item.Updated.UTC().Format(time.RFC3339),
+			Summary:   item.Summary,
+		}
+		if item.Author != "" {
+			document.Entries[i].Author = &atomPerson{Name: item.Author}
+		}
+	}
+	return writeDocument(w, document)
+}
+
+func writeDocument(w io.Writer, document interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(document)
+}
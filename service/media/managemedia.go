@@ -14,37 +14,113 @@ You should have received a copy of the GNU General Public License along with Tin
 package media
 
 import (
+	"bytes"
+	"errors"
 	"github.com/google/uuid"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/service/settings"
+	"gorm.io/gorm"
+	"io"
 	"os"
-	"path/filepath"
 )
 
-func TestImageDirectoryPath(imageDirectoryPath string) bool {
+// copyBatchSize is how many uploads CopyAll looks up at once
+const copyBatchSize = 100
+
+const StorageTestFailedMessage = "Media storage test failed - ensure the image directory exists and allows read and write access, or that the S3 bucket exists and the credentials can read, write and delete in it"
+
+// TestStorage checks that the storage backend in the settings can be reached, and allows writing, reading and deleting
+func TestStorage(siteSettings *settings.Settings) bool {
+	storage, err := NewStorage(siteSettings)
+	if err != nil {
+		return false
+	}
 	randPart, err := uuid.NewRandom()
 	if err != nil {
 		return false
 	}
 	fileName := "test_" + randPart.String()
-	fullPath := filepath.Join(imageDirectoryPath, fileName)
+	content := []byte(fileName)
 
 	// Ensure can write
-	f, err := os.Create(fullPath)
-	defer f.Close()
-	if err != nil {
+	if err = storage.Put(fileName, bytes.NewReader(content), int64(len(content)), "text/plain"); err != nil {
 		return false
 	}
 
 	// Ensure can read
-	_, err = os.ReadFile(fullPath)
+	file, err := storage.Open(fileName)
 	if err != nil {
 		return false
 	}
+	readContent, err := io.ReadAll(file)
+	file.Close()
+	if err != nil || !bytes.Equal(readContent, content) {
+		return false
+	}
 
 	// Ensure can delete
-	err = os.Remove(fullPath)
-	if err != nil {
+	if err = storage.Delete(fileName); err != nil {
 		return false
 	}
+	exists, err := storage.Exists(fileName)
+	return err == nil && !exists
+}
 
-	return true
+// CopyResult counts what CopyAll did. Missing files are skipped, since nothing can be done about them by copying.
+type CopyResult struct {
+	Copied  int
+	Skipped int
+	Missing int
+}
+
+// CopyAll copies every upload and its resized copies from one storage backend to another, skipping files that are
+// already there so that an interrupted copy can be run again
+func CopyAll(from Storage, to Storage) (result CopyResult, err error) {
+	var batch []Media
+	batchRes := database.Database.Model(&Media{}).Order("id").FindInBatches(&batch, copyBatchSize, func(tx *gorm.DB, _ int) error {
+		ids := make([]int, len(batch))
+		for i, row := range batch {
+			ids[i] = row.ID
+			if err := copyFile(from, to, row.StoredName(), row.Size, row.MimeType, &result); err != nil {
+				return err
+			}
+		}
+		var variants []MediaVariant
+		selectRes := database.Database.Model(&MediaVariant{}).Where(map[string]interface{}{"media_id": ids}).Find(&variants)
+		if selectRes.Error != nil {
+			return selectRes.Error
+		}
+		for _, variant := range variants {
+			if err := copyFile(from, to, variant.StoredName(), variant.Size, variant.MimeType, &result); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return result, batchRes.Error
+}
+
+func copyFile(from Storage, to Storage, name string, size int64, mimeType string, result *CopyResult) error {
+	exists, err := to.Exists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		result.Skipped++
+		return nil
+	}
+	file, err := from.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		result.Missing++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = to.Put(name, file, size, mimeType); err != nil {
+		return err
+	}
+	result.Copied++
+	return nil
 }
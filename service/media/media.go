@@ -0,0 +1,270 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/uploadrule"
+	"github.com/pgray64/tinypress/service/settings"
+	"github.com/pgray64/tinypress/service/user"
+	"gorm.io/gorm"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UrlPrefix is where media is served from on the public site, see route/public/media.go
+const UrlPrefix = "/media/"
+
+// Media is an uploaded file. Files are stored under the SHA-256 of their content, so uploading the same file twice
+// gives back the existing media.
+type Media struct {
+	ID       int    `gorm:"primaryKey;autoIncrement"`
+	Hash     string `gorm:"not null;size:64;uniqueIndex:idx_media_hash"`
+	Filename string `gorm:"not null;size:255"`
+	MimeType string `gorm:"not null;size:255"`
+	Size     int64  `gorm:"not null"`
+	// Width and Height are 0 for SVGs, which can be drawn at any size
+	Width        int       `gorm:"not null;default:0"`
+	Height       int       `gorm:"not null;default:0"`
+	UploadedById *int      `gorm:"index:idx_media_uploaded_by_id"`
+	UploadedBy   user.User `gorm:"PRELOAD:false;foreignKey:UploadedById;constraint:OnDelete:SET NULL"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	// VariantSet records the settings the resized copies were made with, see variants.go
+	VariantSet string `gorm:"not null;size:255;default:''"`
+	// UnreferencedSince is when the upload was last found not to be used anywhere, see usage.go
+	UnreferencedSince *time.Time `gorm:"index:idx_media_unreferenced_since"`
+}
+
+// extensions are the types that can be uploaded, with the extension their files are stored under
+var extensions = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+// typeNames describe the types that can be uploaded in messages
+var typeNames = map[string]string{
+	"image/jpeg":    "JPEG",
+	"image/png":     "PNG",
+	"image/gif":     "GIF",
+	"image/webp":    "WebP",
+	"image/svg+xml": "SVG",
+}
+
+// StoredName is the name of the file in the image directory and in the public URL
+func (media *Media) StoredName() string {
+	return media.Hash + extensions[media.MimeType]
+}
+
+func (media *Media) Url() string {
+	return UrlPrefix + media.StoredName()
+}
+
+// Store saves an upload once it passes every check, returning an UploadError if it fails one. Its type comes from its
+// content rather than its name or what the browser said, and metadata is stripped before it is stored.
+func Store(filename string, content io.Reader, uploadedById *int) (*Media, error) {
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+	storage, err := storageFor(siteSettings)
+	if err != nil {
+		return nil, err
+	}
+	newMedia, data, err := prepareUpload(siteSettings, filename, content)
+	if err != nil {
+		return nil, err
+	}
+	newMedia.UploadedById = uploadedById
+
+	existing, err := GetByHash(newMedia.Hash)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+	if err = storage.Put(newMedia.StoredName(), bytes.NewReader(data), newMedia.Size, newMedia.MimeType); err != nil {
+		return nil, err
+	}
+
+	insertRes := database.Database.Create(newMedia)
+	var pgErr *pgconn.PgError
+	if insertRes.Error != nil && errors.As(insertRes.Error, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		// The same file was uploaded at the same time
+		return GetByHash(newMedia.Hash)
+	}
+	if insertRes.Error != nil {
+		return nil, insertRes.Error
+	}
+	return newMedia, nil
+}
+
+// Check runs the checks Store does without storing anything, so that a batch of uploads can be rejected as a whole
+func Check(filename string, content io.Reader) error {
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		return err
+	}
+	_, _, err = prepareUpload(siteSettings, filename, content)
+	return err
+}
+
+// prepareUpload runs every check on an upload and strips its metadata, giving back the media to insert and the
+// content to store
+func prepareUpload(siteSettings *settings.Settings, filename string, content io.Reader) (*Media, []byte, error) {
+	limits, err := uploadLimits(siteSettings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(content, limits.Max+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	mimeType := detectType(data)
+	if mimeType == "" {
+		return nil, nil, &UploadError{
+			Rule:    uploadrule.UnsupportedType,
+			Message: "Only JPEG, PNG, GIF, WebP and SVG images can be uploaded",
+		}
+	}
+	if err = checkSize(int64(len(data)), mimeType, limits); err != nil {
+		return nil, nil, err
+	}
+
+	newMedia := Media{
+		Filename: cleanFilename(filename),
+		MimeType: mimeType,
+	}
+	if mimeType == "image/svg+xml" {
+		if err = checkSvg(data); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		// Matching the first bytes of a type isn't enough, the rest has to be readable as that type too
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, &UploadError{Rule: uploadrule.Corrupt, Message: "The file could not be read as a " + typeNames[mimeType] + " image"}
+		}
+		newMedia.Width = config.Width
+		newMedia.Height = config.Height
+	}
+	if data, err = stripMetadata(mimeType, data); err != nil {
+		return nil, nil, &UploadError{Rule: uploadrule.Corrupt, Message: "The file could not be read as a " + typeNames[mimeType] + " image"}
+	}
+
+	// The hash is of what is stored, so the same photo with different metadata is only stored once
+	hash := sha256.Sum256(data)
+	newMedia.Hash = hex.EncodeToString(hash[:])
+	newMedia.Size = int64(len(data))
+	return &newMedia, data, nil
+}
+
+// cleanFilename keeps the original name of an upload for display, without any directories
+func cleanFilename(filename string) string {
+	filename = strings.TrimSpace(filepath.Base(strings.ReplaceAll(filename, "\\", "/")))
+	if filename == "." || filename == "/" {
+		filename = ""
+	}
+	if len(filename) > 255 {
+		filename = filename[len(filename)-255:]
+	}
+	return filename
+}
+
+func GetMedia(mediaId int) (*Media, error) {
+	var media []Media
+	selectRes := database.Database.Model(&Media{}).Where(map[string]interface{}{"id": mediaId}).Find(&media)
+	if selectRes.Error != nil || len(media) < 1 {
+		return nil, selectRes.Error
+	}
+	return &media[0], nil
+}
+
+func GetByHash(hash string) (*Media, error) {
+	var media []Media
+	selectRes := database.Database.Model(&Media{}).Where(map[string]interface{}{"hash": hash}).Find(&media)
+	if selectRes.Error != nil || len(media) < 1 {
+		return nil, selectRes.Error
+	}
+	return &media[0], nil
+}
+
+// HasMedia checks whether anything has been uploaded
+func HasMedia() (bool, error) {
+	var count int64
+	countRes := database.Database.Model(&Media{}).Limit(1).Count(&count)
+	return count > 0, countRes.Error
+}
+
+// ListMedia returns uploads newest first, only including those with the query in their filename if it is set
+func ListMedia(query string, page int, perPage int) (media []Media, totalCount int64, err error) {
+	listQuery := database.Database.Model(&Media{})
+	if query != "" {
+		escaped := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(query)
+		listQuery = listQuery.Where("filename ilike ?", "%"+escaped+"%")
+	}
+	countRes := listQuery.Session(&gorm.Session{}).Count(&totalCount)
+	if countRes.Error != nil {
+		return media, 0, countRes.Error
+	}
+	offset := perPage * page
+	selectRes := listQuery.
+		Order("id desc").
+		Offset(offset).
+		Limit(perPage).
+		Find(&media)
+	return media, totalCount, selectRes.Error
+}
+
+// DeleteMedia removes an upload along with its file and its resized copies
+func DeleteMedia(mediaId int) error {
+	existing, err := GetMedia(mediaId)
+	if err != nil || existing == nil {
+		return err
+	}
+	storage, err := currentStorage()
+	if err != nil {
+		return err
+	}
+	if err = deleteVariantFiles(storage, mediaId); err != nil {
+		return err
+	}
+	deleteRes := database.Database.Where(map[string]interface{}{"id": mediaId}).Delete(&Media{})
+	if deleteRes.Error != nil {
+		return deleteRes.Error
+	}
+	return storage.Delete(existing.StoredName())
+}
+
+// Open returns a stored file, either an upload or one of its resized copies
+func Open(storedName string) (io.ReadSeekCloser, error) {
+	storage, err := currentStorage()
+	if err != nil {
+		return nil, err
+	}
+	return storage.Open(storedName)
+}
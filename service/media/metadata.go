@@ -0,0 +1,274 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+)
+
+var errMalformedImage = errors.New("image is malformed")
+
+// stripMetadata removes metadata that can give away where and when a photo was taken, and on what. Only JPEGs and
+// PNGs carry it in places this knows about, and everything else is left as it is.
+func stripMetadata(mimeType string, content []byte) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJpegMetadata(content)
+	case "image/png":
+		return stripPngMetadata(content)
+	}
+	return content, nil
+}
+
+const (
+	jpegApp0  = 0xE0
+	jpegApp1  = 0xE1
+	jpegApp2  = 0xE2
+	jpegApp14 = 0xEE
+	jpegApp15 = 0xEF
+	jpegSos   = 0xDA
+	jpegCom   = 0xFE
+)
+
+// stripJpegMetadata drops the segments holding EXIF, XMP, comments and the like. JFIF, colour profiles and Adobe's
+// colour transform are kept since they change how the image looks. The EXIF orientation is kept too, so that photos
+// taken sideways still show the right way up.
+func stripJpegMetadata(content []byte) ([]byte, error) {
+	if len(content) < 4 || content[0] != 0xFF || content[1] != 0xD8 {
+		return nil, errMalformedImage
+	}
+	var stripped bytes.Buffer
+	stripped.Write(content[:2])
+	orientation := uint16(0)
+	// The new EXIF segment goes after the JFIF segment, which has to come first if it is there
+	insertAt := stripped.Len()
+	for i := 2; ; {
+		if i+1 >= len(content) || content[i] != 0xFF {
+			return nil, errMalformedImage
+		}
+		marker := content[i+1]
+		if marker == 0xFF {
+			// Fill byte before a marker
+			i++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			stripped.Write(content[i : i+2])
+			i += 2
+			continue
+		}
+		if marker == jpegSos {
+			// The image data follows, which has no more metadata in it
+			stripped.Write(content[i:])
+			break
+		}
+		if i+4 > len(content) {
+			return nil, errMalformedImage
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(content[i+2:i+4]))
+		if end > len(content) || end < i+4 {
+			return nil, errMalformedImage
+		}
+		segment := content[i:end]
+		if marker == jpegApp1 {
+			if found := exifOrientation(segment[4:]); found != 0 {
+				orientation = found
+			}
+		}
+		if !isJpegMetadata(marker) {
+			stripped.Write(segment)
+			if marker == jpegApp0 && insertAt == 2 {
+				insertAt = stripped.Len()
+			}
+		}
+		i = end
+	}
+
+	if orientation <= 1 {
+		return stripped.Bytes(), nil
+	}
+	result := stripped.Bytes()
+	withOrientation := make([]byte, 0, len(result)+36)
+	withOrientation = append(withOrientation, result[:insertAt]...)
+	withOrientation = append(withOrientation, orientationSegment(orientation)...)
+	return append(withOrientation, result[insertAt:]...), nil
+}
+
+// isJpegMetadata covers comments and the application segments other than JFIF, colour profiles and Adobe's
+func isJpegMetadata(marker byte) bool {
+	if marker == jpegCom {
+		return true
+	}
+	return marker >= jpegApp0 && marker <= jpegApp15 && marker != jpegApp0 && marker != jpegApp2 && marker != jpegApp14
+}
+
+// exifOrientation reads the orientation tag from the first directory of an EXIF segment, or returns 0 if it has none
+func exifOrientation(data []byte) uint16 {
+	if len(data) < 14 || string(data[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := data[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	for entry := offset + 2; entry+12 <= len(tiff) && count > 0; entry, count = entry+12, count-1 {
+		// Orientation is a single SHORT, stored in the start of the value field
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 && order.Uint16(tiff[entry+2:entry+4]) == 3 {
+			orientation := order.Uint16(tiff[entry+8 : entry+10])
+			if orientation > 8 {
+				return 0
+			}
+			return orientation
+		}
+	}
+	return 0
+}
+
+// jpegOrientation finds the EXIF orientation of a JPEG, or returns 0 if it has none
+func jpegOrientation(content []byte) uint16 {
+	if len(content) < 4 || content[0] != 0xFF || content[1] != 0xD8 {
+		return 0
+	}
+	for i := 2; i+4 <= len(content) && content[i] == 0xFF; {
+		marker := content[i+1]
+		if marker == jpegSos {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(content[i+2:i+4]))
+		if end > len(content) || end < i+4 {
+			break
+		}
+		if marker == jpegApp1 {
+			if found := exifOrientation(content[i+4 : end]); found != 0 {
+				return found
+			}
+		}
+		i = end
+	}
+	return 0
+}
+
+// orient turns a decoded image the way its EXIF orientation says it should be shown, since decoding ignores it.
+// Orientations 5 to 8 swap the width and height.
+func orient(img image.Image, orientation uint16) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			// Where the pixel shown at x, y is stored
+			var srcX, srcY int
+			switch orientation {
+			case 2:
+				srcX, srcY = w-1-x, y
+			case 3:
+				srcX, srcY = w-1-x, h-1-y
+			case 4:
+				srcX, srcY = x, h-1-y
+			case 5:
+				srcX, srcY = y, x
+			case 6:
+				srcX, srcY = y, h-1-x
+			case 7:
+				srcX, srcY = w-1-y, h-1-x
+			case 8:
+				srcX, srcY = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(srcX, srcY):src.PixOffset(srcX, srcY)+4])
+		}
+	}
+	return dst
+}
+
+// orientationSegment is an EXIF segment with nothing but the orientation in it
+func orientationSegment(orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	// One entry: tag, type SHORT, count 1, value padded to 4 bytes
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	// No next directory
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+
+	data := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, jpegApp1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(data)+2))
+	return append(segment, data...)
+}
+
+// pngMetadataChunks hold EXIF, text such as comments and camera details, and when the image was last changed
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPngMetadata(content []byte) ([]byte, error) {
+	const signatureLength = 8
+	if len(content) < signatureLength {
+		return nil, errMalformedImage
+	}
+	var stripped bytes.Buffer
+	stripped.Write(content[:signatureLength])
+	for i := signatureLength; i < len(content); {
+		// Length, type, data and CRC
+		if i+8 > len(content) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(content[i : i+4]))
+		end := i + 12 + length
+		if length < 0 || end > len(content) || end < i {
+			return nil, errMalformedImage
+		}
+		chunkType := string(content[i+4 : i+8])
+		if !pngMetadataChunks[chunkType] {
+			stripped.Write(content[i:end])
+		}
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return stripped.Bytes(), nil
+}
@@ -0,0 +1,325 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 30), G: uint8(y * 60), B: 100, A: 255})
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, data []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(data)+2))
+	return append(segment, data...)
+}
+
+// exifData is an EXIF segment's contents with a camera make, then the orientation, then some trailing data
+func exifData(order binary.ByteOrder, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(&tiff, order, uint16(42))
+	binary.Write(&tiff, order, uint32(8))
+	binary.Write(&tiff, order, uint16(2))
+	// Make, as ASCII short enough to be stored in the value field
+	binary.Write(&tiff, order, []uint16{0x010F, 2})
+	binary.Write(&tiff, order, uint32(4))
+	tiff.WriteString("Cam\x00")
+	binary.Write(&tiff, order, []uint16{0x0112, 3})
+	binary.Write(&tiff, order, uint32(1))
+	binary.Write(&tiff, order, []uint16{orientation, 0})
+	binary.Write(&tiff, order, uint32(0))
+	tiff.WriteString("secret GPS position")
+	return append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+}
+
+// withSegments puts segments right after the start of image marker of an encoded JPEG
+func withSegments(encoded []byte, segments ...[]byte) []byte {
+	result := append([]byte{}, encoded[:2]...)
+	for _, segment := range segments {
+		result = append(result, segment...)
+	}
+	return append(result, encoded[2:]...)
+}
+
+// jpegMarkers lists the markers of the segments before the image data
+func jpegMarkers(t *testing.T, content []byte) []byte {
+	var markers []byte
+	for i := 2; i+4 <= len(content); {
+		marker := content[i+1]
+		markers = append(markers, marker)
+		if marker == jpegSos {
+			return markers
+		}
+		i += 2 + int(binary.BigEndian.Uint16(content[i+2:i+4]))
+	}
+	t.Fatal("no start of scan found")
+	return nil
+}
+
+func TestStripJpegMetadata(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	jfif := jpegSegment(jpegApp0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	iccProfile := jpegSegment(jpegApp2, []byte("ICC_PROFILE\x00\x01\x01profile"))
+	xmp := jpegSegment(jpegApp1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>secret creator</x:xmpmeta>"))
+	comment := jpegSegment(jpegCom, []byte("secret comment"))
+	photoshop := jpegSegment(0xED, []byte("Photoshop 3.0\x00secret caption"))
+
+	tests := []struct {
+		name            string
+		orientation     uint16
+		order           binary.ByteOrder
+		wantOrientation uint16
+	}{
+		{"rotated, little endian", 6, binary.LittleEndian, 6},
+		{"rotated, big endian", 8, binary.BigEndian, 8},
+		{"upright", 1, binary.BigEndian, 0},
+		{"invalid orientation", 9, binary.BigEndian, 0},
+	}
+	for _, test := range tests {
+		exif := jpegSegment(jpegApp1, exifData(test.order, test.orientation))
+		content := withSegments(encoded.Bytes(), jfif, exif, xmp, iccProfile, comment, photoshop)
+		stripped, err := stripMetadata("image/jpeg", content)
+		if err != nil {
+			t.Fatalf("%s: stripMetadata failed: %v", test.name, err)
+		}
+		if bytes.Contains(stripped, []byte("secret")) {
+			t.Errorf("%s: metadata is left in the stripped image", test.name)
+		}
+		if !bytes.Contains(stripped, []byte("ICC_PROFILE")) {
+			t.Errorf("%s: the colour profile was removed", test.name)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(stripped))
+		if err != nil || config.Width != 8 || config.Height != 4 {
+			t.Errorf("%s: stripped image reads as %v, %v", test.name, config, err)
+		}
+
+		markers := jpegMarkers(t, stripped)
+		if markers[0] != jpegApp0 {
+			t.Errorf("%s: JFIF segment is no longer first: % x", test.name, markers)
+		}
+		var orientation uint16
+		app1Count := 0
+		for i := 2; ; {
+			marker := stripped[i+1]
+			if marker == jpegSos {
+				break
+			}
+			end := i + 2 + int(binary.BigEndian.Uint16(stripped[i+2:i+4]))
+			if marker == jpegApp1 {
+				app1Count++
+				orientation = exifOrientation(stripped[i+4 : end])
+			}
+			i = end
+		}
+		if test.wantOrientation == 0 && app1Count > 0 {
+			t.Errorf("%s: an EXIF segment was kept without an orientation to keep", test.name)
+		}
+		if test.wantOrientation != 0 && (app1Count != 1 || orientation != test.wantOrientation) {
+			t.Errorf("%s: got %d EXIF segments with orientation %d, want one with %d", test.name, app1Count, orientation,
+				test.wantOrientation)
+		}
+	}
+}
+
+func TestStripJpegMetadataMalformed(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"empty", []byte{}},
+		{"not a jpeg", []byte("GIF89a\x01\x00\x01\x00")},
+		{"truncated header", encoded.Bytes()[:5]},
+		{"segment past the end", withSegments([]byte{0xFF, 0xD8}, []byte{0xFF, jpegCom, 0x10, 0x00, 'x'})},
+		{"segment too short", withSegments([]byte{0xFF, 0xD8}, []byte{0xFF, jpegCom, 0x00, 0x01})},
+		{"no marker", withSegments([]byte{0xFF, 0xD8}, []byte{0x00, 0x00, 0x00, 0x00})},
+	}
+	for _, test := range tests {
+		if _, err := stripMetadata("image/jpeg", test.content); !errors.Is(err, errMalformedImage) {
+			t.Errorf("%s: stripMetadata() error = %v, want %v", test.name, err, errMalformedImage)
+		}
+	}
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+func TestStripPngMetadata(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	// The signature and the IHDR chunk come first
+	headerEnd := 8 + 12 + 13
+	var content []byte
+	content = append(content, encoded.Bytes()[:headerEnd]...)
+	content = append(content, pngChunk("gAMA", []byte{0, 0, 0xB1, 0x8F})...)
+	content = append(content, pngChunk("tEXt", []byte("Comment\x00secret comment"))...)
+	content = append(content, pngChunk("zTXt", []byte("Author\x00\x00secret"))...)
+	content = append(content, pngChunk("iTXt", []byte("Title\x00\x00\x00\x00\x00secret title"))...)
+	content = append(content, pngChunk("eXIf", exifData(binary.BigEndian, 6))...)
+	content = append(content, pngChunk("tIME", []byte{0x07, 0xE6, 1, 2, 3, 4, 5})...)
+	content = append(content, encoded.Bytes()[headerEnd:]...)
+	// Anything after the end of the image is dropped too
+	content = append(content, []byte("secret trailer")...)
+
+	stripped, err := stripMetadata("image/png", content)
+	if err != nil {
+		t.Fatalf("stripMetadata failed: %v", err)
+	}
+	for _, unwanted := range []string{"secret", "tEXt", "zTXt", "iTXt", "eXIf", "tIME"} {
+		if bytes.Contains(stripped, []byte(unwanted)) {
+			t.Errorf("%s is left in the stripped image", unwanted)
+		}
+	}
+	if !bytes.Contains(stripped, []byte("gAMA")) {
+		t.Errorf("the gamma chunk was removed")
+	}
+	decoded, err := png.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("stripped image can't be read: %v", err)
+	}
+	if decoded.Bounds() != testImage().Bounds() {
+		t.Errorf("stripped image is %v, want %v", decoded.Bounds(), testImage().Bounds())
+	}
+}
+
+func TestStripPngMetadataMalformed(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"shorter than the signature", []byte("\x89PNG")},
+		{"truncated chunk header", encoded.Bytes()[:12]},
+		{"chunk past the end", encoded.Bytes()[:20]},
+	}
+	for _, test := range tests {
+		if _, err := stripMetadata("image/png", test.content); !errors.Is(err, errMalformedImage) {
+			t.Errorf("%s: stripMetadata() error = %v, want %v", test.name, err, errMalformedImage)
+		}
+	}
+}
+
+func TestStripMetadataLeavesOtherTypes(t *testing.T) {
+	content := []byte("GIF89a\x01\x00\x01\x00 secret")
+	stripped, err := stripMetadata("image/gif", content)
+	if err != nil || !bytes.Equal(stripped, content) {
+		t.Errorf("stripMetadata() = %q, %v, want the content unchanged", stripped, err)
+	}
+}
+
+func TestJpegOrientation(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	xmp := jpegSegment(jpegApp1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))
+	tests := []struct {
+		name    string
+		content []byte
+		want    uint16
+	}{
+		{"no exif", encoded.Bytes(), 0},
+		{"exif after xmp", withSegments(encoded.Bytes(), xmp, jpegSegment(jpegApp1, exifData(binary.LittleEndian, 6))), 6},
+		{"as stored after stripping", withSegments(encoded.Bytes(), orientationSegment(8)), 8},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 0},
+		{"truncated", withSegments([]byte{0xFF, 0xD8}, []byte{0xFF, jpegApp1, 0x10, 0x00}), 0},
+	}
+	for _, test := range tests {
+		if got := jpegOrientation(test.content); got != test.want {
+			t.Errorf("%s: jpegOrientation() = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// Stored as a b c on top of d e f
+	colours := map[byte]color.RGBA{
+		'a': {R: 10, A: 255}, 'b': {R: 20, A: 255}, 'c': {R: 30, A: 255},
+		'd': {R: 40, A: 255}, 'e': {R: 50, A: 255}, 'f': {R: 60, A: 255},
+	}
+	stored := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i, name := range "abcdef" {
+		stored.Set(i%3, i/3, colours[byte(name)])
+	}
+	// How each orientation should be shown, row by row
+	tests := []struct {
+		orientation uint16
+		want        []string
+	}{
+		{1, []string{"abc", "def"}},
+		{2, []string{"cba", "fed"}},
+		{3, []string{"fed", "cba"}},
+		{4, []string{"def", "abc"}},
+		{5, []string{"ad", "be", "cf"}},
+		{6, []string{"da", "eb", "fc"}},
+		{7, []string{"fc", "eb", "da"}},
+		{8, []string{"cf", "be", "ad"}},
+		{9, []string{"abc", "def"}},
+	}
+	for _, test := range tests {
+		shown := orient(stored, test.orientation)
+		bounds := shown.Bounds()
+		if bounds.Dy() != len(test.want) || bounds.Dx() != len(test.want[0]) {
+			t.Errorf("orientation %d: shown as %dx%d, want %dx%d", test.orientation, bounds.Dx(), bounds.Dy(),
+				len(test.want[0]), len(test.want))
+			continue
+		}
+		for y, row := range test.want {
+			for x := range row {
+				if got := color.RGBAModel.Convert(shown.At(bounds.Min.X+x, bounds.Min.Y+y)); got != colours[row[x]] {
+					t.Errorf("orientation %d: pixel %d,%d is %v, want %c", test.orientation, x, y, got, row[x])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"context"
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pgray64/tinypress/enum/storagebackend"
+	"github.com/pgray64/tinypress/service/settings"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is somewhere media files are kept. Files are named by their stored name under a directory made of its first
+// two characters, the same way in every backend, so that they can be copied from one to another as they are.
+type Storage interface {
+	// Put writes a file all at once, replacing any file of the same name
+	Put(name string, content io.Reader, size int64, mimeType string) error
+	// Open returns an error matching os.ErrNotExist if there is no such file
+	Open(name string) (io.ReadSeekCloser, error)
+	// Delete succeeds if the file is already gone
+	Delete(name string) error
+	Exists(name string) (bool, error)
+}
+
+var ErrStorageNotConfigured = errors.New("media storage is not configured")
+
+// NewStorage sets up the backend picked in the site settings
+func NewStorage(siteSettings *settings.Settings) (Storage, error) {
+	return NewStorageFor(siteSettings, siteSettings.StorageBackend)
+}
+
+// NewStorageFor sets up a backend from the site settings, whether or not it is the one in use
+func NewStorageFor(siteSettings *settings.Settings, backend storagebackend.StorageBackend) (Storage, error) {
+	switch backend {
+	case storagebackend.Local:
+		if siteSettings.ImageDirectoryPath == "" {
+			return nil, ErrStorageNotConfigured
+		}
+		return &localStorage{directory: siteSettings.ImageDirectoryPath}, nil
+	case storagebackend.S3:
+		if siteSettings.S3Endpoint == "" || siteSettings.S3Bucket == "" {
+			return nil, ErrStorageNotConfigured
+		}
+		client, err := minio.New(siteSettings.S3Endpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(siteSettings.S3AccessKey, siteSettings.S3SecretKey, ""),
+			Secure: siteSettings.S3UseSsl,
+			Region: siteSettings.S3Region,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &s3Storage{client: client, bucket: siteSettings.S3Bucket}, nil
+	}
+	return nil, ErrStorageNotConfigured
+}
+
+// storageCacheTtl is how long a storage settings change made on another instance can take to be picked up here
+const storageCacheTtl = 30 * time.Second
+
+// storageCache keeps the backend in use between requests, so that serving media doesn't load the site settings and
+// set up a new S3 client every time
+var storageCache = struct {
+	sync.Mutex
+	storage  Storage
+	key      string
+	loadedAt time.Time
+}{}
+
+// currentStorage is the backend media is kept in right now
+func currentStorage() (Storage, error) {
+	storageCache.Lock()
+	storage := storageCache.storage
+	fresh := time.Since(storageCache.loadedAt) < storageCacheTtl
+	storageCache.Unlock()
+	if storage != nil && fresh {
+		return storage, nil
+	}
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+	return storageFor(siteSettings)
+}
+
+// storageFor reuses the cached backend if the settings still describe it, and sets up a new one otherwise
+func storageFor(siteSettings *settings.Settings) (Storage, error) {
+	key := strings.Join([]string{strconv.Itoa(int(siteSettings.StorageBackend)), siteSettings.ImageDirectoryPath,
+		siteSettings.S3Endpoint, siteSettings.S3Region, siteSettings.S3Bucket, siteSettings.S3AccessKey,
+		siteSettings.S3SecretKey, strconv.FormatBool(siteSettings.S3UseSsl)}, "\x00")
+	storageCache.Lock()
+	defer storageCache.Unlock()
+	if storageCache.storage != nil && storageCache.key == key {
+		storageCache.loadedAt = time.Now()
+		return storageCache.storage, nil
+	}
+	storage, err := NewStorage(siteSettings)
+	if err != nil {
+		return nil, err
+	}
+	storageCache.storage = storage
+	storageCache.key = key
+	storageCache.loadedAt = time.Now()
+	return storage, nil
+}
+
+// ClearStorageCache makes the next media access set up storage from the site settings again, for after they change
+func ClearStorageCache() {
+	storageCache.Lock()
+	storageCache.storage = nil
+	storageCache.Unlock()
+}
+
+// objectName spreads files over directories by the start of their name, which is a hash, to keep directories small
+func objectName(storedName string) string {
+	return storedName[:2] + "/" + storedName
+}
+
+type localStorage struct {
+	directory string
+}
+
+func (storage *localStorage) path(name string) string {
+	return filepath.Join(storage.directory, filepath.FromSlash(objectName(name)))
+}
+
+func (storage *localStorage) Put(name string, content io.Reader, size int64, mimeType string) error {
+	storedPath := storage.path(name)
+	if err := os.MkdirAll(filepath.Dir(storedPath), 0755); err != nil {
+		return err
+	}
+	// Write next to the final file and move it into place, so that it is never served half-written
+	tempFile, err := os.CreateTemp(filepath.Dir(storedPath), "upload_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err = io.Copy(tempFile, content); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err = tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), storedPath)
+}
+
+func (storage *localStorage) Open(name string) (io.ReadSeekCloser, error) {
+	return os.Open(storage.path(name))
+}
+
+func (storage *localStorage) Delete(name string) error {
+	if err := os.Remove(storage.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (storage *localStorage) Exists(name string) (bool, error) {
+	_, err := os.Stat(storage.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+// isNotFound checks for S3 errors meaning the object or bucket is not there
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+func (storage *s3Storage) Put(name string, content io.Reader, size int64, mimeType string) error {
+	_, err := storage.client.PutObject(context.Background(), storage.bucket, objectName(name), content, size, minio.PutObjectOptions{
+		ContentType: mimeType,
+	})
+	return err
+}
+
+func (storage *s3Storage) Open(name string) (io.ReadSeekCloser, error) {
+	object, err := storage.client.GetObject(context.Background(), storage.bucket, objectName(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// Nothing is fetched until the object is used, so check that it is there while errors can still be handled
+	if _, err = object.Stat(); err != nil {
+		object.Close()
+		if isNotFound(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+func (storage *s3Storage) Delete(name string) error {
+	return storage.client.RemoveObject(context.Background(), storage.bucket, objectName(name), minio.RemoveObjectOptions{})
+}
+
+func (storage *s3Storage) Exists(name string) (bool, error) {
+	_, err := storage.client.StatObject(context.Background(), storage.bucket, objectName(name), minio.StatObjectOptions{})
+	if err != nil && isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,167 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"github.com/pgray64/tinypress/database"
+	"gorm.io/gorm"
+	"regexp"
+	"time"
+)
+
+// mediaUrlPattern matches links to uploads and their resized copies, relative or absolute, in HTML and CSS
+var mediaUrlPattern = regexp.MustCompile(regexp.QuoteMeta(UrlPrefix) + `([0-9a-f]{64})[-.]`)
+
+// referencedCondition finds uploads, as media, that are in use: embedded in a revision of a page or a block, see
+// page.MediaReference and block.BlockMediaReference, or set as the featured or sharing image of a page
+const referencedCondition = `(exists (select 1 from media_references where media_references.media_id = media.id)
+or exists (select 1 from block_media_references where block_media_references.media_id = media.id)
+or exists (select 1 from pages where strpos(pages.featured_image, media.hash) > 0 or strpos(pages.og_image, media.hash) > 0))`
+
+// ReferencedIds finds the uploads linked to from rendered HTML and CSS, leaving out links to media that doesn't exist
+func ReferencedIds(tx *gorm.DB, texts ...string) ([]int, error) {
+	var hashes []string
+	for _, text := range texts {
+		for _, match := range mediaUrlPattern.FindAllStringSubmatch(text, -1) {
+			hashes = append(hashes, match[1])
+		}
+	}
+	var ids []int
+	if len(hashes) < 1 {
+		return ids, nil
+	}
+	selectRes := tx.Model(&Media{}).
+		Where(map[string]interface{}{"hash": hashes}).
+		Pluck("id", &ids)
+	return ids, selectRes.Error
+}
+
+// MarkOrphans notes when uploads stopped being used anywhere, and clears that for uploads that are used again
+func MarkOrphans() error {
+	updateRes := database.Database.Model(&Media{}).
+		Where("unreferenced_since is null and not "+referencedCondition).
+		Update("unreferenced_since", time.Now())
+	if updateRes.Error != nil {
+		return updateRes.Error
+	}
+	updateRes = database.Database.Model(&Media{}).
+		Where("unreferenced_since is not null and "+referencedCondition).
+		Update("unreferenced_since", nil)
+	return updateRes.Error
+}
+
+// ListOrphans returns uploads that have not been used anywhere since before the cutoff, longest unused first
+func ListOrphans(cutoff time.Time, page int, perPage int) (media []Media, totalCount int64, err error) {
+	query := database.Database.Model(&Media{}).
+		Where("unreferenced_since < ?", cutoff).
+		Where("not " + referencedCondition)
+	countRes := query.Session(&gorm.Session{}).Count(&totalCount)
+	if countRes.Error != nil {
+		return media, 0, countRes.Error
+	}
+	offset := perPage * page
+	selectRes := query.
+		Order("unreferenced_since").
+		Order("id").
+		Offset(offset).
+		Limit(perPage).
+		Find(&media)
+	return media, totalCount, selectRes.Error
+}
+
+// DeleteOrphans deletes uploads that have not been used anywhere since before the cutoff
+func DeleteOrphans(cutoff time.Time) error {
+	var orphans []Media
+	selectRes := database.Database.Model(&Media{}).
+		Where("unreferenced_since < ?", cutoff).
+		Where("not " + referencedCondition).
+		Find(&orphans)
+	if selectRes.Error != nil {
+		return selectRes.Error
+	}
+	if len(orphans) < 1 {
+		return nil
+	}
+	storage, err := currentStorage()
+	if err != nil {
+		return err
+	}
+	for _, orphan := range orphans {
+		if err = deleteOrphan(storage, &orphan, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOrphan deletes an upload only if it is still unused when the row is deleted, since it may have been added to
+// a draft after it was listed. Another instance may also have deleted it first.
+func deleteOrphan(storage Storage, orphan *Media, cutoff time.Time) error {
+	var variants []MediaVariant
+	selectRes := database.Database.Model(&MediaVariant{}).
+		Where(map[string]interface{}{"media_id": orphan.ID}).
+		Find(&variants)
+	if selectRes.Error != nil {
+		return selectRes.Error
+	}
+	deleteRes := database.Database.
+		Where(map[string]interface{}{"id": orphan.ID}).
+		Where("unreferenced_since < ?", cutoff).
+		Where("not " + referencedCondition).
+		Delete(&Media{})
+	if deleteRes.Error != nil || deleteRes.RowsAffected < 1 {
+		return deleteRes.Error
+	}
+	for _, variant := range variants {
+		if err := storage.Delete(variant.StoredName()); err != nil {
+			return err
+		}
+	}
+	return storage.Delete(orphan.StoredName())
+}
+
+// CountUses returns how many non-deleted pages and blocks use each upload, in any revision or as a page image.
+// Uploads that aren't used are left out.
+func CountUses(mediaIds []int) (map[int]int64, error) {
+	uses := make(map[int]int64)
+	if len(mediaIds) < 1 {
+		return uses, nil
+	}
+	var rows []struct {
+		MediaId int
+		Uses    int64
+	}
+	selectRes := database.Database.Raw(`select media_id, count(*) as uses from (
+select media_references.media_id, 'page' as kind, content_revisions.page_id as owner_id from media_references
+inner join content_revisions on content_revisions.id = media_references.revision_id
+inner join pages on pages.id = content_revisions.page_id and pages.deleted_at is null
+where media_references.media_id in ?
+union
+select media.id, 'page', pages.id from media
+inner join pages on pages.deleted_at is null and (strpos(pages.featured_image, media.hash) > 0 or strpos(pages.og_image, media.hash) > 0)
+where media.id in ?
+union
+select block_media_references.media_id, 'block', block_revisions.block_id from block_media_references
+inner join block_revisions on block_revisions.id = block_media_references.revision_id
+inner join blocks on blocks.id = block_revisions.block_id and blocks.deleted_at is null
+where block_media_references.media_id in ?
+) owners group by media_id`, mediaIds, mediaIds, mediaIds).Scan(&rows)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	for _, row := range rows {
+		uses[row.MediaId] = row.Uses
+	}
+	return uses, nil
+}
@@ -0,0 +1,240 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/pgray64/tinypress/enum/uploadrule"
+	"github.com/pgray64/tinypress/service/settings"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxUploadKb is the most the upload limits in the site settings can be raised to
+const MaxUploadKb = 100 * 1024
+
+var ErrInvalidTypeLimits = errors.New("upload type limits are invalid")
+
+// UploadError explains which check an upload failed, for showing to the person uploading it
+type UploadError struct {
+	Rule    uploadrule.UploadRule
+	Message string
+	// Limit is the size in bytes that was exceeded, for the size rules
+	Limit int64
+}
+
+func (err *UploadError) Error() string {
+	return err.Message
+}
+
+// UploadLimits are the most an upload can be in bytes, overall and for particular types
+type UploadLimits struct {
+	Max    int64
+	ByType map[string]int64
+}
+
+func GetUploadLimits() (*UploadLimits, error) {
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+	return uploadLimits(siteSettings)
+}
+
+func uploadLimits(siteSettings *settings.Settings) (*UploadLimits, error) {
+	byType, err := ParseTypeLimits(siteSettings.UploadTypeLimits)
+	if err != nil {
+		return nil, err
+	}
+	limits := UploadLimits{
+		Max:    int64(siteSettings.MaxUploadKb) << 10,
+		ByType: make(map[string]int64, len(byType)),
+	}
+	for mimeType, kb := range byType {
+		limits.ByType[mimeType] = int64(kb) << 10
+	}
+	return &limits, nil
+}
+
+// ParseTypeLimits reads limits in kilobytes for particular types, written as a comma-separated list of type=kb
+func ParseTypeLimits(raw string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		mimeType, rawKb, found := strings.Cut(part, "=")
+		mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+		kb, err := strconv.Atoi(strings.TrimSpace(rawKb))
+		if _, ok := extensions[mimeType]; !ok || !found || err != nil || kb < 1 || kb > MaxUploadKb {
+			return nil, ErrInvalidTypeLimits
+		}
+		limits[mimeType] = kb
+	}
+	return limits, nil
+}
+
+// FormatTypeLimits is the inverse of ParseTypeLimits, with the types in order
+func FormatTypeLimits(limits map[string]int) string {
+	parts := make([]string, 0, len(limits))
+	for mimeType, kb := range limits {
+		parts = append(parts, fmt.Sprintf("%s=%d", mimeType, kb))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// formatSize describes a limit in bytes for people
+func formatSize(size int64) string {
+	if size >= 1<<20 && size%(1<<20) == 0 {
+		return fmt.Sprintf("%d MB", size>>20)
+	}
+	return fmt.Sprintf("%d KB", size>>10)
+}
+
+// checkSize enforces the overall limit, then any lower limit for the type of the upload
+func checkSize(size int64, mimeType string, limits *UploadLimits) error {
+	if size > limits.Max {
+		return &UploadError{
+			Rule:    uploadrule.TooLarge,
+			Message: "Files can be at most " + formatSize(limits.Max),
+			Limit:   limits.Max,
+		}
+	}
+	if typeLimit, ok := limits.ByType[mimeType]; ok && size > typeLimit {
+		return &UploadError{
+			Rule:    uploadrule.TypeTooLarge,
+			Message: fmt.Sprintf("%s files can be at most %s", typeNames[mimeType], formatSize(typeLimit)),
+			Limit:   typeLimit,
+		}
+	}
+	return nil
+}
+
+// detectType works out the type of an upload from its first bytes, ignoring its name and what the browser said it
+// was. It returns an empty string for anything that can't be uploaded.
+func detectType(content []byte) string {
+	mimeType := http.DetectContentType(content)
+	if _, ok := extensions[mimeType]; ok {
+		return mimeType
+	}
+	// SVGs are XML, which sniffs as text, so check for an svg root element
+	if strings.HasPrefix(mimeType, "text/") && isSvg(content) {
+		return "image/svg+xml"
+	}
+	return ""
+}
+
+func isSvg(content []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "svg"
+		}
+	}
+}
+
+// unsafeSvgElements can run scripts or pull in other documents
+var unsafeSvgElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+}
+
+// checkSvg rejects SVGs that could run anything when opened in the browser, rather than trying to clean them up
+func checkSvg(content []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return &UploadError{Rule: uploadrule.Corrupt, Message: "The SVG is not valid XML"}
+		}
+		switch token := token.(type) {
+		case xml.Directive:
+			// A doctype can declare entities, which could expand to anything
+			return unsafeSvgError("SVGs can't have a DOCTYPE or other declarations")
+		case xml.ProcInst:
+			// Stylesheet instructions can pull in XSLT, which can run scripts
+			if token.Target != "xml" {
+				return unsafeSvgError("SVGs can't have processing instructions other than the XML declaration")
+			}
+		case xml.StartElement:
+			name := strings.ToLower(token.Name.Local)
+			if unsafeSvgElements[name] {
+				return unsafeSvgError(fmt.Sprintf("SVGs can't have %s elements", token.Name.Local))
+			}
+			for _, attr := range token.Attr {
+				attrName := strings.ToLower(attr.Name.Local)
+				if strings.HasPrefix(attrName, "on") {
+					return unsafeSvgError(fmt.Sprintf("SVGs can't have event handlers like %s", attr.Name.Local))
+				}
+				// Animations can change a link to a script after the fact
+				if (name == "animate" || name == "set") && attrName == "attributename" && strings.HasSuffix(strings.ToLower(attr.Value), "href") {
+					return unsafeSvgError("SVGs can't animate links")
+				}
+				if (attrName == "href" || attrName == "src") && !isSafeSvgLink(attr.Value) {
+					return unsafeSvgError("SVGs can only link to web pages, parts of themselves and embedded images")
+				}
+			}
+		}
+	}
+}
+
+func unsafeSvgError(message string) error {
+	return &UploadError{Rule: uploadrule.UnsafeSvg, Message: message}
+}
+
+// isSafeSvgLink allows links to parts of the same file, web pages and embedded raster images. Browsers ignore
+// whitespace and control characters in a scheme, so those are dropped before checking it.
+func isSafeSvgLink(link string) bool {
+	link = strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, link))
+	scheme, _, hasScheme := strings.Cut(link, ":")
+	if !hasScheme || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+	switch scheme {
+	case "http", "https", "mailto":
+		return true
+	case "data":
+		for _, mimeType := range []string{"image/png", "image/jpeg", "image/gif", "image/webp"} {
+			if strings.HasPrefix(link, "data:"+mimeType+";") || strings.HasPrefix(link, "data:"+mimeType+",") {
+				return true
+			}
+		}
+	}
+	return false
+}
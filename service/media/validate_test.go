@@ -0,0 +1,129 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"errors"
+	"github.com/pgray64/tinypress/enum/uploadrule"
+	"testing"
+)
+
+func TestCheckSvg(t *testing.T) {
+	tests := []struct {
+		name     string
+		svg      string
+		wantRule uploadrule.UploadRule
+	}{
+		{"plain", `<svg xmlns="http://www.w3.org/2000/svg"><circle r="5"/></svg>`, 0},
+		{"xml declaration", `<?xml version="1.0"?><svg><rect/></svg>`, 0},
+		{"internal link", `<svg><use href="#shape"/></svg>`, 0},
+		{"xlink to a web page", `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><a xlink:href="https://example.com">x</a></svg>`, 0},
+		{"embedded png", `<svg><image href="data:image/png;base64,AAAA"/></svg>`, 0},
+		{"style element", `<svg><style>circle { fill: red; }</style></svg>`, 0},
+		{"animation of a colour", `<svg><animate attributeName="fill" to="red"/></svg>`, 0},
+
+		{"not xml", `<svg><circle></svg>`, uploadrule.Corrupt},
+		{"doctype", `<!DOCTYPE svg [<!ENTITY x "y">]><svg>&x;</svg>`, uploadrule.UnsafeSvg},
+		{"stylesheet instruction", `<?xml-stylesheet href="evil.xsl" type="text/xsl"?><svg/>`, uploadrule.UnsafeSvg},
+		{"script", `<svg><script>alert(1)</script></svg>`, uploadrule.UnsafeSvg},
+		{"script in upper case", `<svg><SCRIPT>alert(1)</SCRIPT></svg>`, uploadrule.UnsafeSvg},
+		{"foreign object", `<svg><foreignObject><iframe src="https://example.com"/></foreignObject></svg>`, uploadrule.UnsafeSvg},
+		{"iframe", `<svg><iframe/></svg>`, uploadrule.UnsafeSvg},
+		{"embed", `<svg><embed src="x.swf"/></svg>`, uploadrule.UnsafeSvg},
+		{"object", `<svg><object data="x"/></svg>`, uploadrule.UnsafeSvg},
+		{"handler", `<svg><handler type="application/ecmascript">alert(1)</handler></svg>`, uploadrule.UnsafeSvg},
+		{"listener", `<svg><listener event="click"/></svg>`, uploadrule.UnsafeSvg},
+		{"onload", `<svg onload="alert(1)"/>`, uploadrule.UnsafeSvg},
+		{"onclick in mixed case", `<svg><rect OnClick="alert(1)"/></svg>`, uploadrule.UnsafeSvg},
+		{"javascript link", `<svg><a href="javascript:alert(1)">x</a></svg>`, uploadrule.UnsafeSvg},
+		{"javascript link with whitespace", `<svg><a href=" java&#x09;script:alert(1)">x</a></svg>`, uploadrule.UnsafeSvg},
+		{"javascript xlink", `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><a xlink:href="JavaScript:alert(1)">x</a></svg>`, uploadrule.UnsafeSvg},
+		{"embedded html", `<svg><image href="data:text/html;base64,AAAA"/></svg>`, uploadrule.UnsafeSvg},
+		{"embedded svg", `<svg><image href="data:image/svg+xml;base64,AAAA"/></svg>`, uploadrule.UnsafeSvg},
+		{"animated link", `<svg><a><animate attributeName="href" to="javascript:alert(1)"/></a></svg>`, uploadrule.UnsafeSvg},
+		{"set xlink", `<svg><a><set attributeName="xlink:href" to="javascript:alert(1)"/></a></svg>`, uploadrule.UnsafeSvg},
+	}
+	for _, test := range tests {
+		err := checkSvg([]byte(test.svg))
+		if test.wantRule == 0 {
+			if err != nil {
+				t.Errorf("%s: checkSvg rejected a safe SVG: %v", test.name, err)
+			}
+			continue
+		}
+		var uploadErr *UploadError
+		if !errors.As(err, &uploadErr) {
+			t.Errorf("%s: checkSvg() = %v, want an UploadError", test.name, err)
+			continue
+		}
+		if uploadErr.Rule != test.wantRule {
+			t.Errorf("%s: checkSvg() broke rule %d, want %d", test.name, uploadErr.Rule, test.wantRule)
+		}
+	}
+}
+
+func TestIsSafeSvgLink(t *testing.T) {
+	tests := []struct {
+		link string
+		want bool
+	}{
+		{"#shape", true},
+		{"other.svg#shape", true},
+		{"/media/photo.jpg", true},
+		{"path/with:colon", true},
+		{"https://example.com", true},
+		{"HTTP://example.com", true},
+		{"mailto:someone@example.com", true},
+		{"data:image/jpeg,AAAA", true},
+		{"data:image/webp;base64,AAAA", true},
+		{"javascript:alert(1)", false},
+		{"JAVASCRIPT:alert(1)", false},
+		{"java\nscript:alert(1)", false},
+		{" \tjavascript:alert(1)", false},
+		{"vbscript:msgbox(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"data:image/svg+xml,<svg/>", false},
+		{"data:image/pngx,AAAA", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, test := range tests {
+		if got := isSafeSvgLink(test.link); got != test.want {
+			t.Errorf("isSafeSvgLink(%q) = %v, want %v", test.link, got, test.want)
+		}
+	}
+}
+
+func TestDetectType(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "image/png"},
+		{"jpeg", "\xff\xd8\xff\xe0\x00\x10JFIF\x00", "image/jpeg"},
+		{"gif", "GIF89a\x01\x00\x01\x00", "image/gif"},
+		{"webp", "RIFF\x00\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"svg", `<svg xmlns="http://www.w3.org/2000/svg"/>`, "image/svg+xml"},
+		{"svg with declaration", `<?xml version="1.0"?>` + "\n" + `<svg/>`, "image/svg+xml"},
+		{"other xml", `<?xml version="1.0"?><html/>`, ""},
+		{"html", `<html><body><svg/></body></html>`, ""},
+		{"text", "just some text", ""},
+		{"pdf", "%PDF-1.4", ""},
+	}
+	for _, test := range tests {
+		if got := detectType([]byte(test.content)); got != test.want {
+			t.Errorf("%s: detectType() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
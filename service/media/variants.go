@@ -0,0 +1,439 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/imageformat"
+	"github.com/pgray64/tinypress/service/settings"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"gorm.io/gorm/clause"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MinVariantWidth = 16
+	MaxVariantWidth = 8192
+	MaxVariantSizes = 10
+	// maxVariantPixels keeps huge images from being decoded, since that takes 4 bytes of memory per pixel
+	maxVariantPixels = 50_000_000
+	// regenerateBatchSize is how many images the regeneration job resizes per run
+	regenerateBatchSize = 10
+)
+
+var ErrInvalidSizes = errors.New("image sizes are invalid")
+
+// MediaVariant is a resized copy of an uploaded image, stored next to the original
+type MediaVariant struct {
+	ID        int       `gorm:"primaryKey;autoIncrement"`
+	MediaId   int       `gorm:"not null;uniqueIndex:idx_media_variants_media_id_width"`
+	Media     Media     `gorm:"PRELOAD:false;constraint:OnDelete:CASCADE"`
+	MediaHash string    `gorm:"not null;size:64;index:idx_media_variants_media_hash"`
+	Width     int       `gorm:"not null;uniqueIndex:idx_media_variants_media_id_width"`
+	Height    int       `gorm:"not null"`
+	MimeType  string    `gorm:"not null;size:255"`
+	Size      int64     `gorm:"not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// StoredName is the name of the file in the image directory and in the public URL
+func (variant *MediaVariant) StoredName() string {
+	return fmt.Sprintf("%s-%dw%s", variant.MediaHash, variant.Width, extensions[variant.MimeType])
+}
+
+func (variant *MediaVariant) Url() string {
+	return UrlPrefix + variant.StoredName()
+}
+
+// ParseSizes reads a comma-separated list of widths, returning them sorted without duplicates
+func ParseSizes(raw string) ([]int, error) {
+	var sizes []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		width, err := strconv.Atoi(part)
+		if err != nil || width < MinVariantWidth || width > MaxVariantWidth {
+			return nil, ErrInvalidSizes
+		}
+		if !seen[width] {
+			seen[width] = true
+			sizes = append(sizes, width)
+		}
+	}
+	if len(sizes) > MaxVariantSizes {
+		return nil, ErrInvalidSizes
+	}
+	sort.Ints(sizes)
+	return sizes, nil
+}
+
+// FormatSizes is the inverse of ParseSizes
+func FormatSizes(sizes []int) string {
+	parts := make([]string, len(sizes))
+	for i, width := range sizes {
+		parts[i] = strconv.Itoa(width)
+	}
+	return strings.Join(parts, ",")
+}
+
+// variantVersion changes when the way variants are made does, so that copies made the old way are redone
+const variantVersion = 2
+
+// variantSet identifies the settings variants were made with, so that changing them gets every image redone
+func variantSet(siteSettings *settings.Settings) string {
+	return fmt.Sprintf("%s;%d;%d;%d", siteSettings.ImageSizes, siteSettings.ImageFormat, siteSettings.ImageQuality,
+		variantVersion)
+}
+
+// variantType picks the type a resized copy is saved as. Originals in types that can't be written, which is only GIF,
+// become PNGs to keep any transparency they have.
+func variantType(originalType string, format imageformat.ImageFormat) string {
+	switch format {
+	case imageformat.Jpeg:
+		return "image/jpeg"
+	case imageformat.Png:
+		return "image/png"
+	case imageformat.Webp:
+		return "image/webp"
+	}
+	if originalType == "image/jpeg" || originalType == "image/webp" {
+		return originalType
+	}
+	return "image/png"
+}
+
+// GenerateVariants resizes an upload to the widths in the site settings, replacing any copies made with earlier
+// settings. Images are never made larger, and GIFs are left alone since resizing would drop their animation.
+func GenerateVariants(media *Media) error {
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		return err
+	}
+	_, err = refreshVariants(media, siteSettings)
+	return err
+}
+
+// RegenerateStaleVariants redoes the resized copies of a batch of images made with settings that have since changed
+func RegenerateStaleVariants() error {
+	siteSettings, err := settings.GetSettings()
+	if err != nil {
+		return err
+	}
+	var stale []Media
+	selectRes := database.Database.Model(&Media{}).
+		Where("variant_set <> ?", variantSet(siteSettings)).
+		Order("id").
+		Limit(regenerateBatchSize).
+		Find(&stale)
+	if selectRes.Error != nil {
+		return selectRes.Error
+	}
+	for i := range stale {
+		if _, err = refreshVariants(&stale[i], siteSettings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshVariants claims an image by marking it as done with the current settings before doing the work, so that
+// several instances never resize the same image at once. It reports whether this call did the work.
+func refreshVariants(media *Media, siteSettings *settings.Settings) (bool, error) {
+	set := variantSet(siteSettings)
+	claimRes := database.Database.Model(&Media{}).
+		Where(map[string]interface{}{"id": media.ID}).
+		Where("variant_set <> ?", set).
+		Update("variant_set", set)
+	if claimRes.Error != nil || claimRes.RowsAffected < 1 {
+		return false, claimRes.Error
+	}
+	if err := writeVariants(media, siteSettings); err != nil {
+		// Leave it for the regeneration job to try again
+		database.Database.Model(&Media{}).Where(map[string]interface{}{"id": media.ID}).Update("variant_set", "")
+		return true, err
+	}
+	return true, nil
+}
+
+func writeVariants(media *Media, siteSettings *settings.Settings) error {
+	storage, err := storageFor(siteSettings)
+	if err != nil {
+		return err
+	}
+	var existing []MediaVariant
+	selectRes := database.Database.Model(&MediaVariant{}).
+		Where(map[string]interface{}{"media_id": media.ID}).
+		Find(&existing)
+	if selectRes.Error != nil {
+		return selectRes.Error
+	}
+
+	var created []MediaVariant
+	sizes, err := ParseSizes(siteSettings.ImageSizes)
+	if err != nil {
+		return err
+	}
+	resizable := media.MimeType != "image/gif" && media.Width > 0 && media.Width*media.Height <= maxVariantPixels
+	if resizable && len(sizes) > 0 && sizes[0] < media.Width {
+		created, err = resizeAll(storage, media, sizes, siteSettings)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove copies that are no longer wanted, or were replaced by one of another type
+	kept := make(map[string]bool, len(created))
+	for _, variant := range created {
+		kept[variant.StoredName()] = true
+	}
+	var removedIds []int
+	for _, variant := range existing {
+		if !kept[variant.StoredName()] {
+			if err = storage.Delete(variant.StoredName()); err != nil {
+				return err
+			}
+		}
+		if !containsWidth(created, variant.Width) {
+			removedIds = append(removedIds, variant.ID)
+		}
+	}
+	if len(removedIds) > 0 {
+		deleteRes := database.Database.Where(map[string]interface{}{"id": removedIds}).Delete(&MediaVariant{})
+		return deleteRes.Error
+	}
+	return nil
+}
+
+func containsWidth(variants []MediaVariant, width int) bool {
+	for _, variant := range variants {
+		if variant.Width == width {
+			return true
+		}
+	}
+	return false
+}
+
+// resizeAll writes and records a copy of an image at each of the sizes smaller than it
+func resizeAll(storage Storage, media *Media, sizes []int, siteSettings *settings.Settings) ([]MediaVariant, error) {
+	file, err := storage.Open(media.StoredName())
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	original, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		// Whatever could not be decoded is served as it is
+		return nil, nil
+	}
+	// Browsers show the original the way its EXIF orientation says, and the copies have no EXIF to go by
+	orientation := jpegOrientation(content)
+	original = orient(original, orientation)
+	shownWidth, shownHeight := media.Width, media.Height
+	if orientation >= 5 && orientation <= 8 {
+		shownWidth, shownHeight = media.Height, media.Width
+	}
+
+	preferredType := variantType(media.MimeType, siteSettings.ImageFormat)
+	var variants []MediaVariant
+	for _, width := range sizes {
+		if width >= shownWidth {
+			break
+		}
+		height := shownHeight * width / shownWidth
+		if height < 1 {
+			height = 1
+		}
+		mimeType := preferredType
+		if mimeType == "image/webp" && height > webpMaxDimension {
+			mimeType = "image/png"
+		}
+		content, err := encodeResized(original, width, height, mimeType, siteSettings.ImageQuality)
+		if err != nil {
+			return nil, err
+		}
+		variant := MediaVariant{
+			MediaId:   media.ID,
+			MediaHash: media.Hash,
+			Width:     width,
+			Height:    height,
+			MimeType:  mimeType,
+			Size:      int64(len(content)),
+		}
+		if err = storage.Put(variant.StoredName(), bytes.NewReader(content), variant.Size, mimeType); err != nil {
+			return nil, err
+		}
+		upsertRes := database.Database.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "media_id"}, {Name: "width"}},
+			DoUpdates: clause.AssignmentColumns([]string{"height", "mime_type", "size", "updated_at"}),
+		}).Create(&variant)
+		if upsertRes.Error != nil {
+			return nil, upsertRes.Error
+		}
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+func encodeResized(original image.Image, width int, height int, mimeType string, quality int) ([]byte, error) {
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	op := draw.Src
+	if mimeType == "image/jpeg" {
+		// JPEGs have no transparency, so put anything see-through on white rather than black
+		draw.Draw(resized, resized.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		op = draw.Over
+	}
+	draw.CatmullRom.Scale(resized, resized.Bounds(), original, original.Bounds(), op, nil)
+
+	var buffer bytes.Buffer
+	var err error
+	switch mimeType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buffer, resized, &jpeg.Options{Quality: quality})
+	case "image/webp":
+		// Lossless, so the quality doesn't apply
+		straight := image.NewNRGBA(resized.Bounds())
+		draw.Draw(straight, straight.Bounds(), resized, image.Point{}, draw.Src)
+		err = encodeWebp(&buffer, straight)
+	default:
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buffer, resized)
+	}
+	return buffer.Bytes(), err
+}
+
+func GetVariant(hash string, width int) (*MediaVariant, error) {
+	var variants []MediaVariant
+	selectRes := database.Database.Model(&MediaVariant{}).
+		Where(map[string]interface{}{"media_hash": hash, "width": width}).
+		Find(&variants)
+	if selectRes.Error != nil || len(variants) < 1 {
+		return nil, selectRes.Error
+	}
+	return &variants[0], nil
+}
+
+var (
+	imageTagPattern = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	mediaSrcPattern = regexp.MustCompile(`(?i)\ssrc\s*=\s*["']?` + regexp.QuoteMeta(UrlPrefix) + `([0-9a-f]{64})\.[a-z]+["'\s/>]`)
+	srcsetPattern   = regexp.MustCompile(`(?i)\ssrcset\s*=`)
+)
+
+// AddSrcsets gives images from the media library in rendered page HTML a srcset of their resized copies, so that
+// browsers can pick the smallest one that fits. Images that already have a srcset are left as they are.
+func AddSrcsets(html string) (string, error) {
+	hashes := srcsetHashes(html)
+	if len(hashes) < 1 {
+		return html, nil
+	}
+
+	var originals []Media
+	selectRes := database.Database.Model(&Media{}).
+		Where(map[string]interface{}{"hash": hashes}).
+		Find(&originals)
+	if selectRes.Error != nil {
+		return "", selectRes.Error
+	}
+	var variants []MediaVariant
+	selectRes = database.Database.Model(&MediaVariant{}).
+		Where(map[string]interface{}{"media_hash": hashes}).
+		Order("width").
+		Find(&variants)
+	if selectRes.Error != nil {
+		return "", selectRes.Error
+	}
+	return applySrcsets(html, srcsetAttributes(originals, variants)), nil
+}
+
+// srcsetHashes finds the uploads shown by image tags that don't have a srcset yet
+func srcsetHashes(html string) []string {
+	var hashes []string
+	for _, tag := range imageTagPattern.FindAllString(html, -1) {
+		if match := mediaSrcPattern.FindStringSubmatch(tag); match != nil && !srcsetPattern.MatchString(tag) {
+			hashes = append(hashes, match[1])
+		}
+	}
+	return hashes
+}
+
+// srcsetAttributes builds the srcset and sizes attributes for each upload with resized copies, by hash. Variants
+// should be ordered by width.
+func srcsetAttributes(originals []Media, variants []MediaVariant) map[string]string {
+	attributesByHash := make(map[string]string, len(originals))
+	for _, original := range originals {
+		var candidates []string
+		for _, variant := range variants {
+			if variant.MediaHash == original.Hash {
+				candidates = append(candidates, fmt.Sprintf("%s %dw", variant.Url(), variant.Width))
+			}
+		}
+		if len(candidates) < 1 {
+			continue
+		}
+		candidates = append(candidates, fmt.Sprintf("%s %dw", original.Url(), original.Width))
+		attributesByHash[original.Hash] = fmt.Sprintf(` srcset="%s" sizes="(max-width: %dpx) 100vw, %dpx"`,
+			strings.Join(candidates, ", "), original.Width, original.Width)
+	}
+	return attributesByHash
+}
+
+// applySrcsets adds the attributes to the end of each image tag showing one of the uploads
+func applySrcsets(html string, attributesByHash map[string]string) string {
+	return imageTagPattern.ReplaceAllStringFunc(html, func(tag string) string {
+		match := mediaSrcPattern.FindStringSubmatch(tag)
+		if match == nil || srcsetPattern.MatchString(tag) || attributesByHash[match[1]] == "" {
+			return tag
+		}
+		end := len(tag) - 1
+		if strings.HasSuffix(tag, "/>") {
+			end--
+		}
+		return tag[:end] + attributesByHash[match[1]] + tag[end:]
+	})
+}
+
+// deleteVariantFiles removes the resized copies of an upload. Their rows go along with the upload itself.
+func deleteVariantFiles(storage Storage, mediaId int) error {
+	var variants []MediaVariant
+	selectRes := database.Database.Model(&MediaVariant{}).Where(map[string]interface{}{"media_id": mediaId}).Find(&variants)
+	if selectRes.Error != nil {
+		return selectRes.Error
+	}
+	for _, variant := range variants {
+		if err := storage.Delete(variant.StoredName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSizes(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []int
+		wantErr bool
+	}{
+		{"480,960,1600", []int{480, 960, 1600}, false},
+		{" 1600, 480 ,960 ", []int{480, 960, 1600}, false},
+		{"960,480,960", []int{480, 960}, false},
+		{"480,,960,", []int{480, 960}, false},
+		{"", nil, false},
+		{"16,8192", []int{16, 8192}, false},
+		{"15", nil, true},
+		{"8193", nil, true},
+		{"0", nil, true},
+		{"-480", nil, true},
+		{"480px", nil, true},
+		{"4.5", nil, true},
+		{"100,200,300,400,500,600,700,800,900,1000", []int{100, 200, 300, 400, 500, 600, 700, 800, 900, 1000}, false},
+		{"100,200,300,400,500,600,700,800,900,1000,1100", nil, true},
+	}
+	for _, test := range tests {
+		got, err := ParseSizes(test.raw)
+		if test.wantErr {
+			if !errors.Is(err, ErrInvalidSizes) {
+				t.Errorf("ParseSizes(%q) error = %v, want %v", test.raw, err, ErrInvalidSizes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSizes(%q) failed: %v", test.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseSizes(%q) = %v, want %v", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestFormatSizesRoundTrip(t *testing.T) {
+	sizes := []int{480, 960, 1600}
+	got, err := ParseSizes(FormatSizes(sizes))
+	if err != nil || !reflect.DeepEqual(got, sizes) {
+		t.Errorf("ParseSizes(FormatSizes(%v)) = %v, %v", sizes, got, err)
+	}
+}
+
+var (
+	photoHash = strings.Repeat("a", 64)
+	logoHash  = strings.Repeat("b", 64)
+)
+
+func TestSrcsetHashes(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want []string
+	}{
+		{"no images", "<p>Hello</p>", nil},
+		{"double quotes", `<img src="/media/` + photoHash + `.jpg">`, []string{photoHash}},
+		{"single quotes", `<img alt='x' src='/media/` + photoHash + `.jpg'>`, []string{photoHash}},
+		{"no quotes", `<img src=/media/` + photoHash + `.jpg>`, []string{photoHash}},
+		{"upper case tag", `<IMG SRC="/media/` + photoHash + `.jpg">`, []string{photoHash}},
+		{"self closing", `<img src="/media/` + logoHash + `.png"/>`, []string{logoHash}},
+		{"already has a srcset", `<img src="/media/` + photoHash + `.jpg" srcset="a.jpg 1x">`, nil},
+		{"already a variant", `<img src="/media/` + photoHash + `-480w.jpg">`, nil},
+		{"other site", `<img src="https://example.com/media/` + photoHash + `.jpg">`, nil},
+		{"not an image", `<a href="/media/` + photoHash + `.jpg">`, nil},
+		{
+			"several",
+			`<img src="/media/` + photoHash + `.jpg"><p>text</p><img src="/media/` + logoHash + `.png">`,
+			[]string{photoHash, logoHash},
+		},
+	}
+	for _, test := range tests {
+		if got := srcsetHashes(test.html); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: srcsetHashes(%q) = %v, want %v", test.name, test.html, got, test.want)
+		}
+	}
+}
+
+func TestSrcsetAttributes(t *testing.T) {
+	originals := []Media{
+		{Hash: photoHash, MimeType: "image/jpeg", Width: 2000},
+		{Hash: logoHash, MimeType: "image/png", Width: 300},
+	}
+	variants := []MediaVariant{
+		{MediaHash: photoHash, MimeType: "image/jpeg", Width: 480},
+		{MediaHash: photoHash, MimeType: "image/jpeg", Width: 960},
+	}
+	got := srcsetAttributes(originals, variants)
+	want := map[string]string{
+		photoHash: ` srcset="/media/` + photoHash + `-480w.jpg 480w, /media/` + photoHash + `-960w.jpg 960w, /media/` +
+			photoHash + `.jpg 2000w" sizes="(max-width: 2000px) 100vw, 2000px"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("srcsetAttributes() = %v, want %v", got, want)
+	}
+}
+
+func TestApplySrcsets(t *testing.T) {
+	attributes := map[string]string{photoHash: ` srcset="x"`}
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			"plain tag",
+			`<p><img src="/media/` + photoHash + `.jpg" alt="Photo"></p>`,
+			`<p><img src="/media/` + photoHash + `.jpg" alt="Photo" srcset="x"></p>`,
+		},
+		{
+			"self closing",
+			`<img src="/media/` + photoHash + `.jpg"/>`,
+			`<img src="/media/` + photoHash + `.jpg" srcset="x"/>`,
+		},
+		{
+			"no resized copies",
+			`<img src="/media/` + logoHash + `.png">`,
+			`<img src="/media/` + logoHash + `.png">`,
+		},
+		{
+			"already has a srcset",
+			`<img src="/media/` + photoHash + `.jpg" srcset="y">`,
+			`<img src="/media/` + photoHash + `.jpg" srcset="y">`,
+		},
+		{
+			"text around it is untouched",
+			`before <img src="/media/` + photoHash + `.jpg"> after`,
+			`before <img src="/media/` + photoHash + `.jpg" srcset="x"> after`,
+		},
+	}
+	for _, test := range tests {
+		if got := applySrcsets(test.html, attributes); got != test.want {
+			t.Errorf("%s: applySrcsets(%q) = %q, want %q", test.name, test.html, got, test.want)
+		}
+	}
+}
+
+func TestAddSrcsetsWithoutMedia(t *testing.T) {
+	// Pages without media library images are returned as they are, without touching the database
+	html := `<p>Hello</p><img src="https://example.com/photo.jpg">`
+	got, err := AddSrcsets(html)
+	if err != nil || got != html {
+		t.Errorf("AddSrcsets(%q) = %q, %v", html, got, err)
+	}
+}
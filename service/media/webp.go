@@ -0,0 +1,445 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+// This is a lossless WebP (VP8L) encoder, since the only pure Go ones need a newer Go than this module targets. It
+// keeps to the parts of the format that do most of the work on photos and screenshots: subtracting green from red and
+// blue, predicting each pixel from its neighbours, and a prefix code per channel. It makes no backward references.
+
+const webpMaxDimension = 16384
+
+var errWebpTooLarge = errors.New("image is too large for WebP")
+
+const (
+	webpTransformPredictor     = 0
+	webpTransformSubtractGreen = 2
+	// webpPredictorBits gives the largest predictor tiles, since every tile uses the same predictor
+	webpPredictorBits = 9
+	// webpPredictorMode is ClampAddSubtractFull, left plus top minus top left, which suits photos and flat areas
+	webpPredictorMode           = 12
+	webpMaxCodeLength           = 15
+	webpMaxCodeLengthCodeLength = 7
+)
+
+// webpCodeLengthCodeOrder is the order the lengths of the code length code are written in
+var webpCodeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpAlphabetSizes are the sizes of the green, red, blue, alpha and distance codes. Green shares its code with
+// backward reference lengths.
+var webpAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+// encodeWebp writes the image as a lossless WebP
+func encodeWebp(w io.Writer, img *image.NRGBA) error {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if width < 1 || height < 1 || width > webpMaxDimension || height > webpMaxDimension {
+		return errWebpTooLarge
+	}
+	pix := make([]byte, 0, 4*width*height)
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):][:4*width]
+		pix = append(pix, row...)
+		for x := 3; x < len(row); x += 4 {
+			hasAlpha = hasAlpha || row[x] != 0xff
+		}
+	}
+	subtractGreen(pix)
+	residuals := predict(pix, width, height)
+
+	var bits bitWriter
+	bits.write(0x2f, 8)
+	bits.write(uint32(width-1), 14)
+	bits.write(uint32(height-1), 14)
+	if hasAlpha {
+		bits.write(1, 1)
+	} else {
+		bits.write(0, 1)
+	}
+	bits.write(0, 3)
+
+	// Transforms are undone in the opposite order, so the predictor is undone first
+	bits.write(1, 1)
+	bits.write(webpTransformSubtractGreen, 2)
+	bits.write(1, 1)
+	bits.write(webpTransformPredictor, 2)
+	bits.write(webpPredictorBits-2, 3)
+	tilesWide := (width + 1<<webpPredictorBits - 1) >> webpPredictorBits
+	tilesHigh := (height + 1<<webpPredictorBits - 1) >> webpPredictorBits
+	modes := make([]byte, 4*tilesWide*tilesHigh)
+	for i := 0; i < len(modes); i += 4 {
+		// The mode goes in the green channel
+		modes[i+1] = webpPredictorMode
+	}
+	writeWebpPixels(&bits, modes, false)
+	bits.write(0, 1)
+
+	writeWebpPixels(&bits, residuals, true)
+	data := bits.bytes()
+
+	// The VP8L chunk in a RIFF container, padded to an even length
+	chunkLength := len(data)
+	padding := chunkLength & 1
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+8+chunkLength+padding))
+	copy(header[8:12], "WEBP")
+	copy(header[12:16], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(chunkLength))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if padding == 1 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// subtractGreen leaves red and blue as their difference from green, which is small in most images. Pixels are in
+// RGBA order.
+func subtractGreen(pix []byte) {
+	for p := 0; p < len(pix); p += 4 {
+		pix[p] -= pix[p+1]
+		pix[p+2] -= pix[p+1]
+	}
+}
+
+// predict returns what is left of each pixel after taking away the prediction a decoder makes from the pixels before
+// it. The first pixel is predicted as opaque black, the rest of the first row from the left, the first column from
+// above, and everything else by webpPredictorMode.
+func predict(pix []byte, width int, height int) []byte {
+	residuals := make([]byte, len(pix))
+	stride := 4 * width
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := y*stride + 4*x
+			for c := 0; c < 4; c++ {
+				var prediction byte
+				switch {
+				case x == 0 && y == 0:
+					if c == 3 {
+						prediction = 0xff
+					}
+				case y == 0:
+					prediction = pix[p-4+c]
+				case x == 0:
+					prediction = pix[p-stride+c]
+				default:
+					prediction = clampAddSubtract(pix[p-4+c], pix[p-stride+c], pix[p-stride-4+c])
+				}
+				residuals[p+c] = pix[p+c] - prediction
+			}
+		}
+	}
+	return residuals
+}
+
+func clampAddSubtract(left byte, top byte, topLeft byte) byte {
+	value := int(left) + int(top) - int(topLeft)
+	if value < 0 {
+		return 0
+	}
+	if value > 255 {
+		return 255
+	}
+	return byte(value)
+}
+
+// writeWebpPixels writes an image as literal pixels with a prefix code per channel. The main image has a flag for a
+// meta image of codes by area, which the transform images don't.
+func writeWebpPixels(bits *bitWriter, pix []byte, isMain bool) {
+	// No color cache
+	bits.write(0, 1)
+	if isMain {
+		// One set of codes for the whole image
+		bits.write(0, 1)
+	}
+	var counts [5][]int
+	for i, size := range webpAlphabetSizes {
+		counts[i] = make([]int, size)
+	}
+	for p := 0; p < len(pix); p += 4 {
+		counts[0][pix[p+1]]++
+		counts[1][pix[p]]++
+		counts[2][pix[p+2]]++
+		counts[3][pix[p+3]]++
+	}
+	var codes [5]prefixCode
+	for i := range codes {
+		codes[i] = writePrefixCode(bits, counts[i])
+	}
+	for p := 0; p < len(pix); p += 4 {
+		codes[0].write(bits, int(pix[p+1]))
+		codes[1].write(bits, int(pix[p]))
+		codes[2].write(bits, int(pix[p+2]))
+		codes[3].write(bits, int(pix[p+3]))
+	}
+}
+
+type prefixCode struct {
+	codes   []uint32
+	lengths []int
+}
+
+func (code *prefixCode) write(bits *bitWriter, symbol int) {
+	length := code.lengths[symbol]
+	if length == 0 {
+		// The only symbol in its code takes no bits
+		return
+	}
+	// Codes are read a bit at a time from their most significant bit
+	reversed := uint32(0)
+	for i := 0; i < length; i++ {
+		reversed |= (code.codes[symbol] >> i & 1) << (length - 1 - i)
+	}
+	bits.write(reversed, length)
+}
+
+// writePrefixCode writes the code for symbols with the counts given and returns it. Codes for up to two symbols below
+// 256 are written as they are, and others as their code lengths, which are themselves prefix coded.
+func writePrefixCode(bits *bitWriter, counts []int) prefixCode {
+	var used []int
+	for symbol, count := range counts {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	code := prefixCode{codes: make([]uint32, len(counts)), lengths: make([]int, len(counts))}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		bits.write(1, 1)
+		bits.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bits.write(0, 1)
+			bits.write(uint32(used[0]), 1)
+		} else {
+			bits.write(1, 1)
+			bits.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bits.write(uint32(used[1]), 8)
+			code.codes[used[1]] = 1
+			code.lengths[used[0]] = 1
+			code.lengths[used[1]] = 1
+		}
+		return code
+	}
+
+	bits.write(0, 1)
+	lengths := codeLengths(counts, webpMaxCodeLength)
+
+	// Runs of zero lengths are written as one symbol, 17 for up to 10 and 18 for up to 138
+	type token struct{ symbol, extra, extraBits int }
+	var tokens []token
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens = append(tokens, token{symbol: lengths[i]})
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			tokens = append(tokens, token{symbol: 18, extra: run - 11, extraBits: 7})
+		case run >= 3:
+			tokens = append(tokens, token{symbol: 17, extra: run - 3, extraBits: 3})
+		default:
+			for j := 0; j < run; j++ {
+				tokens = append(tokens, token{symbol: 0})
+			}
+		}
+		i += run
+	}
+	lengthCounts := make([]int, 19)
+	for _, t := range tokens {
+		lengthCounts[t.symbol]++
+	}
+	lengthCode := prefixCode{lengths: codeLengths(lengthCounts, webpMaxCodeLengthCodeLength)}
+	lengthCode.codes = canonicalCodes(lengthCode.lengths)
+
+	written := 4
+	for i, symbol := range webpCodeLengthCodeOrder {
+		if lengthCode.lengths[symbol] > 0 && i+1 > written {
+			written = i + 1
+		}
+	}
+	bits.write(uint32(written-4), 4)
+	for _, symbol := range webpCodeLengthCodeOrder[:written] {
+		bits.write(uint32(lengthCode.lengths[symbol]), 3)
+	}
+	// Every length is written, rather than stopping after the last one used
+	bits.write(0, 1)
+	dropLoneCode(&lengthCode)
+	for _, t := range tokens {
+		lengthCode.write(bits, t.symbol)
+		if t.extraBits > 0 {
+			bits.write(uint32(t.extra), t.extraBits)
+		}
+	}
+
+	code.lengths = lengths
+	code.codes = canonicalCodes(lengths)
+	dropLoneCode(&code)
+	return code
+}
+
+// dropLoneCode accounts for decoders reading a code with only one symbol in it without taking any bits
+func dropLoneCode(code *prefixCode) {
+	only := -1
+	for symbol, length := range code.lengths {
+		if length > 0 {
+			if only >= 0 {
+				return
+			}
+			only = symbol
+		}
+	}
+	if only >= 0 {
+		code.lengths = append([]int{}, code.lengths...)
+		code.lengths[only] = 0
+	}
+}
+
+// canonicalCodes assigns codes in order of length, then symbol, as decoders expect
+func canonicalCodes(lengths []int) []uint32 {
+	var lengthCounts [webpMaxCodeLength + 1]uint32
+	for _, length := range lengths {
+		lengthCounts[length]++
+	}
+	lengthCounts[0] = 0
+	var next [webpMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for length := 1; length <= webpMaxCodeLength; length++ {
+		code = (code + lengthCounts[length-1]) << 1
+		next[length] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for symbol, length := range lengths {
+		if length > 0 {
+			codes[symbol] = next[length]
+			next[length]++
+		}
+	}
+	return codes
+}
+
+// codeLengths builds a Huffman code for the counts and returns the length of each symbol's code. Counts are evened
+// out until no code is longer than maxLength, which trades a little size for staying within what decoders accept.
+func codeLengths(counts []int, maxLength int) []int {
+	lengths := make([]int, len(counts))
+	var used []int
+	for symbol, count := range counts {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 1 {
+		lengths[used[0]] = 1
+		return lengths
+	}
+	for floor := 1; ; floor *= 2 {
+		nodes := make(huffmanHeap, 0, len(used))
+		for _, symbol := range used {
+			count := counts[symbol]
+			if count < floor {
+				count = floor
+			}
+			nodes = append(nodes, &huffmanNode{count: count, symbol: symbol})
+		}
+		heap.Init(&nodes)
+		for nodes.Len() > 1 {
+			left := heap.Pop(&nodes).(*huffmanNode)
+			right := heap.Pop(&nodes).(*huffmanNode)
+			heap.Push(&nodes, &huffmanNode{count: left.count + right.count, symbol: -1, left: left, right: right})
+		}
+		longest := 0
+		var walk func(node *huffmanNode, depth int)
+		walk = func(node *huffmanNode, depth int) {
+			if node.left == nil {
+				lengths[node.symbol] = depth
+				if depth > longest {
+					longest = depth
+				}
+				return
+			}
+			walk(node.left, depth+1)
+			walk(node.right, depth+1)
+		}
+		walk(nodes[0], 0)
+		if longest <= maxLength {
+			return lengths
+		}
+	}
+}
+
+type huffmanNode struct {
+	count       int
+	symbol      int
+	left, right *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	node := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return node
+}
+
+// bitWriter packs bits starting from the least significant bit of each byte, as VP8L does
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nBits int
+}
+
+func (w *bitWriter) write(value uint32, n int) {
+	w.acc |= uint64(value) << w.nBits
+	w.nBits += n
+	for w.nBits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nBits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nBits > 0 {
+		return append(w.buf, byte(w.acc))
+	}
+	return w.buf
+}
@@ -0,0 +1,126 @@
+/*
+Package media is for services related to media storage and management
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package media
+
+import (
+	"bytes"
+	"golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestEncodeWebp(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name          string
+		width, height int
+		colour        func(x, y int) color.NRGBA
+	}{
+		{"single pixel", 1, 1, func(x, y int) color.NRGBA { return color.NRGBA{R: 200, G: 100, B: 50, A: 255} }},
+		{"one colour", 40, 30, func(x, y int) color.NRGBA { return color.NRGBA{R: 9, G: 9, B: 9, A: 255} }},
+		{"gradient", 300, 200, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: 255}
+		}},
+		{"transparency", 70, 50, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: 255, G: uint8(x * 3), B: 0, A: uint8(y * 5)}
+		}},
+		{"noise", 700, 3, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: 255}
+		}},
+		{"two colours", 9, 600, func(x, y int) color.NRGBA {
+			if (x+y)%2 == 0 {
+				return color.NRGBA{A: 255}
+			}
+			return color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		}},
+	}
+	for _, test := range tests {
+		img := image.NewNRGBA(image.Rect(0, 0, test.width, test.height))
+		for y := 0; y < test.height; y++ {
+			for x := 0; x < test.width; x++ {
+				img.SetNRGBA(x, y, test.colour(x, y))
+			}
+		}
+		var encoded bytes.Buffer
+		if err := encodeWebp(&encoded, img); err != nil {
+			t.Errorf("%s: encodeWebp failed: %v", test.name, err)
+			continue
+		}
+		decoded, err := webp.Decode(bytes.NewReader(encoded.Bytes()))
+		if err != nil {
+			t.Errorf("%s: encoded image can't be read: %v", test.name, err)
+			continue
+		}
+		if decoded.Bounds() != img.Bounds() {
+			t.Errorf("%s: decoded as %v, want %v", test.name, decoded.Bounds(), img.Bounds())
+			continue
+		}
+		mismatch := false
+		for y := 0; y < test.height && !mismatch; y++ {
+			for x := 0; x < test.width && !mismatch; x++ {
+				got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+				if want := img.NRGBAAt(x, y); got != want {
+					t.Errorf("%s: pixel %d,%d is %v, want %v", test.name, x, y, got, want)
+					mismatch = true
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeWebpTooLarge(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, webpMaxDimension+1))
+	if err := encodeWebp(&bytes.Buffer{}, img); err != errWebpTooLarge {
+		t.Errorf("encodeWebp() error = %v, want %v", err, errWebpTooLarge)
+	}
+}
+
+func TestCodeLengths(t *testing.T) {
+	// Counts growing like the Fibonacci numbers make the deepest Huffman tree there is
+	fibonacci := []int{1, 1}
+	for len(fibonacci) < 30 {
+		fibonacci = append(fibonacci, fibonacci[len(fibonacci)-1]+fibonacci[len(fibonacci)-2])
+	}
+	tests := []struct {
+		name      string
+		counts    []int
+		maxLength int
+	}{
+		{"even", []int{5, 5, 5, 5}, 15},
+		{"skewed", []int{1000, 1, 0, 1, 30}, 15},
+		{"limited", fibonacci, 15},
+		{"code length code", fibonacci[:19], 7},
+	}
+	for _, test := range tests {
+		lengths := codeLengths(test.counts, test.maxLength)
+		// A complete code uses up the whole code space: the sum of 2^-length over the symbols is 1
+		kraft := 0
+		for symbol, length := range lengths {
+			if (length > 0) != (test.counts[symbol] > 0) {
+				t.Errorf("%s: symbol %d with count %d has length %d", test.name, symbol, test.counts[symbol], length)
+			}
+			if length > test.maxLength {
+				t.Errorf("%s: symbol %d has length %d, longer than %d", test.name, symbol, length, test.maxLength)
+			}
+			if length > 0 {
+				kraft += 1 << (test.maxLength - length)
+			}
+		}
+		if kraft != 1<<test.maxLength {
+			t.Errorf("%s: code is not complete, lengths %v", test.name, lengths)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+/*
+Package menu is for services related to site navigation menus
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package menu
+
+import (
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/menuitemtype"
+	"github.com/pgray64/tinypress/service/page"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxDepth is how deeply menu items can be nested, counting the top level
+const MaxDepth = 3
+
+type Menu struct {
+	ID        int       `gorm:"primaryKey;autoIncrement"`
+	Name      string    `gorm:"not null;size:100;uniqueIndex:idx_menus_name"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// MenuItem is a link in a menu. Only the field matching ItemType is used for the link target.
+type MenuItem struct {
+	ID       int        `gorm:"primaryKey;autoIncrement"`
+	MenuId   int        `gorm:"not null;index:idx_menu_items_menu_id"`
+	Menu     Menu       `gorm:"PRELOAD:false;constraint:OnDelete:CASCADE"`
+	ParentId *int       `gorm:"index:idx_menu_items_parent_id"`
+	Children []MenuItem `gorm:"PRELOAD:false;foreignKey:ParentId;constraint:OnDelete:CASCADE"`
+	Position int        `gorm:"not null"`
+	// Label may be left empty for page items to use the title of the page
+	Label    string                    `gorm:"not null;size:255"`
+	ItemType menuitemtype.MenuItemType `gorm:"not null"`
+	// Items for purged pages are removed along with the page
+	PageId    *int      `gorm:"index:idx_menu_items_page_id"`
+	Page      page.Page `gorm:"PRELOAD:false;foreignKey:PageId;constraint:OnDelete:CASCADE"`
+	Url       string    `gorm:"not null;size:2048;default:''"`
+	Anchor    string    `gorm:"not null;size:255;default:''"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// ItemInput is a menu item along with the items nested under it, as edited in the menu builder
+type ItemInput struct {
+	Label    string
+	ItemType menuitemtype.MenuItemType
+	PageId   *int
+	Url      string
+	Anchor   string
+	Children []ItemInput
+}
+
+// Link is a resolved menu item, ready to be shown to site visitors
+type Link struct {
+	Label    string
+	Url      string
+	Children []Link
+}
+
+var (
+	ErrInvalidItem = errors.New("menu item is invalid")
+	ErrTooDeep     = errors.New("menu items are nested too deeply")
+)
+
+var anchorPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_:.-]*$`)
+
+func (menu *Menu) Create() (isDup bool, err error) {
+	insertRes := database.Database.Create(menu)
+	return checkDup(insertRes.Error)
+}
+
+func RenameMenu(menuId int, name string) (isDup bool, err error) {
+	updateRes := database.Database.Model(&Menu{}).
+		Where(map[string]interface{}{"id": menuId}).
+		Updates(&Menu{Name: name})
+	return checkDup(updateRes.Error)
+}
+
+func checkDup(err error) (isDup bool, _ error) {
+	var pgErr *pgconn.PgError
+	if err != nil && errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return true, nil
+	}
+	return false, err
+}
+
+func GetMenu(menuId int) (*Menu, error) {
+	var menus []Menu
+	selectRes := database.Database.Model(&Menu{}).Where(map[string]interface{}{"id": menuId}).Find(&menus)
+	if selectRes.Error != nil || len(menus) < 1 {
+		return nil, selectRes.Error
+	}
+	return &menus[0], nil
+}
+
+func ListMenus() (menus []Menu, err error) {
+	selectRes := database.Database.Model(&Menu{}).Order("name asc").Find(&menus)
+	return menus, selectRes.Error
+}
+
+// DeleteMenu removes a menu, and its items with it
+func DeleteMenu(menuId int) error {
+	deleteRes := database.Database.Where(map[string]interface{}{"id": menuId}).Delete(&Menu{})
+	return deleteRes.Error
+}
+
+// ListItems returns the items of a menu ordered so that parents come before their children
+func ListItems(menuIds ...int) (items []MenuItem, err error) {
+	selectRes := database.Database.Model(&MenuItem{}).
+		Where(map[string]interface{}{"menu_id": menuIds}).
+		Order("parent_id asc nulls first, position asc").
+		Find(&items)
+	return items, selectRes.Error
+}
+
+// ReplaceItems swaps out all the items of a menu, since the menu builder edits the whole tree at once
+func ReplaceItems(menuId int, items []ItemInput) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		var menus []Menu
+		if res := tx.Model(&Menu{}).Where(map[string]interface{}{"id": menuId}).Find(&menus); res.Error != nil {
+			return res.Error
+		}
+		if len(menus) < 1 {
+			return errors.New("menu does not exist")
+		}
+		if res := tx.Where(map[string]interface{}{"menu_id": menuId}).Delete(&MenuItem{}); res.Error != nil {
+			return res.Error
+		}
+		if err := insertItems(tx, menuId, nil, items, 1); err != nil {
+			return err
+		}
+		return tx.Model(&Menu{}).Where(map[string]interface{}{"id": menuId}).Update("updated_at", time.Now()).Error
+	})
+}
+
+func insertItems(tx *gorm.DB, menuId int, parentId *int, items []ItemInput, depth int) error {
+	if len(items) > 0 && depth > MaxDepth {
+		return ErrTooDeep
+	}
+	for i, input := range items {
+		item := MenuItem{
+			MenuId:   menuId,
+			ParentId: parentId,
+			Position: i,
+			Label:    strings.TrimSpace(input.Label),
+			ItemType: input.ItemType,
+		}
+		if err := setTarget(tx, &item, input); err != nil {
+			return err
+		}
+		if res := tx.Create(&item); res.Error != nil {
+			return res.Error
+		}
+		if err := insertItems(tx, menuId, &item.ID, input.Children, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setTarget checks the link target of an item against its type
+func setTarget(tx *gorm.DB, item *MenuItem, input ItemInput) error {
+	switch input.ItemType {
+	case menuitemtype.Page:
+		if input.PageId == nil {
+			return ErrInvalidItem
+		}
+		var count int64
+		if res := tx.Model(&page.Page{}).Where(map[string]interface{}{"id": *input.PageId}).Count(&count); res.Error != nil {
+			return res.Error
+		}
+		if count < 1 {
+			return ErrInvalidItem
+		}
+		item.PageId = input.PageId
+	case menuitemtype.Url:
+		url := strings.TrimSpace(input.Url)
+		if item.Label == "" || !(strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "/")) {
+			return ErrInvalidItem
+		}
+		item.Url = url
+	case menuitemtype.Anchor:
+		anchor := strings.TrimPrefix(strings.TrimSpace(input.Anchor), "#")
+		if item.Label == "" || !anchorPattern.MatchString(anchor) {
+			return ErrInvalidItem
+		}
+		item.Anchor = anchor
+	default:
+		return ErrInvalidItem
+	}
+	return nil
+}
+
+// ResolveMenus builds the links of every menu by name. Page items point at the current address of the page, and are
+// left out along with their children while the page is unpublished or in the trash.
+func ResolveMenus() (map[string][]Link, error) {
+	menus, err := ListMenus()
+	if err != nil || len(menus) < 1 {
+		return nil, err
+	}
+	menuIds := make([]int, len(menus))
+	for i, row := range menus {
+		menuIds[i] = row.ID
+	}
+	items, err := ListItems(menuIds...)
+	if err != nil {
+		return nil, err
+	}
+
+	var pageIds []int
+	for _, item := range items {
+		if item.PageId != nil {
+			pageIds = append(pageIds, *item.PageId)
+		}
+	}
+	pagesById := make(map[int]page.Page)
+	if len(pageIds) > 0 {
+		var pages []page.Page
+		selectRes := database.Database.Model(&page.Page{}).
+			Select("id", "title", "path").
+			Where(map[string]interface{}{"id": pageIds}).
+			Where("published_revision_id is not null").
+			Find(&pages)
+		if selectRes.Error != nil {
+			return nil, selectRes.Error
+		}
+		for _, row := range pages {
+			pagesById[row.ID] = row
+		}
+	}
+
+	childItems := make(map[int][]MenuItem)
+	topItems := make(map[int][]MenuItem)
+	for _, item := range items {
+		if item.ParentId == nil {
+			topItems[item.MenuId] = append(topItems[item.MenuId], item)
+		} else {
+			childItems[*item.ParentId] = append(childItems[*item.ParentId], item)
+		}
+	}
+	var buildLinks func(items []MenuItem) []Link
+	buildLinks = func(items []MenuItem) []Link {
+		var links []Link
+		for _, item := range items {
+			link := Link{Label: item.Label}
+			switch item.ItemType {
+			case menuitemtype.Page:
+				target, ok := pagesById[*item.PageId]
+				if !ok {
+					continue
+				}
+				link.Url = target.Permalink()
+				if link.Label == "" {
+					link.Label = target.Title
+				}
+			case menuitemtype.Url:
+				link.Url = item.Url
+			case menuitemtype.Anchor:
+				link.Url = "#" + item.Anchor
+			}
+			link.Children = buildLinks(childItems[item.ID])
+			links = append(links, link)
+		}
+		return links
+	}
+
+	resolved := make(map[string][]Link, len(menus))
+	for _, row := range menus {
+		resolved[row.Name] = buildLinks(topItems[row.ID])
+	}
+	return resolved, nil
+}
@@ -0,0 +1,148 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffInsert DiffOp = "insert"
+	DiffDelete DiffOp = "delete"
+)
+
+// DiffChunk is a run of consecutive lines that were kept, inserted or deleted
+type DiffChunk struct {
+	Op    DiffOp
+	Lines []string
+}
+
+// maxDiffEdits bounds the work done comparing two very different revisions, past which they are shown as replaced
+const maxDiffEdits = 2000
+
+// DiffContent compares two pieces of editor output line by line
+func DiffContent(from string, to string) []DiffChunk {
+	return DiffLines(splitForDiff(from), splitForDiff(to))
+}
+
+// splitForDiff breaks content into lines. Editor output is usually a single line, so JSON is indented and HTML is
+// split between tags first.
+func splitForDiff(content string) []string {
+	if content == "" {
+		return []string{}
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, []byte(content), "", "  ") == nil {
+		content = indented.String()
+	} else {
+		content = strings.ReplaceAll(content, "><", ">\n<")
+	}
+	return strings.Split(content, "\n")
+}
+
+// DiffLines finds the shortest edit script between two sets of lines using Myers' algorithm
+func DiffLines(from []string, to []string) []DiffChunk {
+	n, m := len(from), len(to)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds the furthest reaching x for diagonals -d-1..d+1 before step d
+	var trace [][]int
+
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffEdits {
+			return replaceAll(from, to)
+		}
+		snapshot := make([]int, 2*d+3)
+		copy(snapshot, v[offset-d-1:offset+d+2])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && from[x] == to[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, from, to)
+			}
+		}
+	}
+	return replaceAll(from, to)
+}
+
+func backtrack(trace [][]int, from []string, to []string) []DiffChunk {
+	var chunks []DiffChunk
+	// Chunks are built back to front, so lines are prepended
+	add := func(op DiffOp, line string) {
+		if len(chunks) > 0 && chunks[0].Op == op {
+			chunks[0].Lines = append([]string{line}, chunks[0].Lines...)
+			return
+		}
+		chunks = append([]DiffChunk{{Op: op, Lines: []string{line}}}, chunks...)
+	}
+
+	x, y := len(from), len(to)
+	for d := len(trace) - 1; d >= 0; d-- {
+		snapshot := trace[d]
+		furthest := func(k int) int {
+			return snapshot[k+d+1]
+		}
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && furthest(k-1) < furthest(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := furthest(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			add(DiffEqual, from[x-1])
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				add(DiffInsert, to[y-1])
+			} else {
+				add(DiffDelete, from[x-1])
+			}
+		}
+		x, y = prevX, prevY
+	}
+	return chunks
+}
+
+func replaceAll(from []string, to []string) []DiffChunk {
+	var chunks []DiffChunk
+	if len(from) > 0 {
+		chunks = append(chunks, DiffChunk{Op: DiffDelete, Lines: from})
+	}
+	if len(to) > 0 {
+		chunks = append(chunks, DiffChunk{Op: DiffInsert, Lines: to})
+	}
+	return chunks
+}
@@ -0,0 +1,164 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rebuild puts both sides back together from a diff, to check that no line was lost or made up
+func rebuild(chunks []DiffChunk) (from []string, to []string) {
+	from, to = []string{}, []string{}
+	for _, chunk := range chunks {
+		if chunk.Op != DiffInsert {
+			from = append(from, chunk.Lines...)
+		}
+		if chunk.Op != DiffDelete {
+			to = append(to, chunk.Lines...)
+		}
+	}
+	return from, to
+}
+
+// editCount is how many lines a diff inserts or deletes
+func editCount(chunks []DiffChunk) int {
+	count := 0
+	for _, chunk := range chunks {
+		if chunk.Op != DiffEqual {
+			count += len(chunk.Lines)
+		}
+	}
+	return count
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		from []string
+		to   []string
+		want []DiffChunk
+	}{
+		{"both empty", []string{}, []string{}, nil},
+		{"all inserted", []string{}, []string{"a", "b"}, []DiffChunk{{DiffInsert, []string{"a", "b"}}}},
+		{"all deleted", []string{"a", "b"}, []string{}, []DiffChunk{{DiffDelete, []string{"a", "b"}}}},
+		{"unchanged", []string{"a", "b"}, []string{"a", "b"}, []DiffChunk{{DiffEqual, []string{"a", "b"}}}},
+		{
+			"line added in the middle",
+			[]string{"a", "c"},
+			[]string{"a", "b", "c"},
+			[]DiffChunk{{DiffEqual, []string{"a"}}, {DiffInsert, []string{"b"}}, {DiffEqual, []string{"c"}}},
+		},
+		{
+			"line removed at the end",
+			[]string{"a", "b", "c"},
+			[]string{"a", "b"},
+			[]DiffChunk{{DiffEqual, []string{"a", "b"}}, {DiffDelete, []string{"c"}}},
+		},
+		{
+			"line replaced",
+			[]string{"a", "b", "c"},
+			[]string{"a", "x", "c"},
+			[]DiffChunk{{DiffEqual, []string{"a"}}, {DiffDelete, []string{"b"}}, {DiffInsert, []string{"x"}}, {DiffEqual, []string{"c"}}},
+		},
+	}
+	for _, test := range tests {
+		if got := DiffLines(test.from, test.to); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: DiffLines(%v, %v) = %v, want %v", test.name, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestDiffLinesIsMinimal(t *testing.T) {
+	tests := []struct {
+		from      string
+		to        string
+		wantEdits int
+	}{
+		// The classic example from Myers' paper, which needs 5 edits
+		{"abcabba", "cbabac", 5},
+		{"abc", "abc", 0},
+		{"abc", "xyz", 6},
+		{"abcdef", "abdef", 1},
+		{"kitten", "sitting", 5},
+	}
+	for _, test := range tests {
+		from, to := strings.Split(test.from, ""), strings.Split(test.to, "")
+		chunks := DiffLines(from, to)
+		gotFrom, gotTo := rebuild(chunks)
+		if !reflect.DeepEqual(gotFrom, from) || !reflect.DeepEqual(gotTo, to) {
+			t.Errorf("DiffLines(%q, %q) rebuilds as %q and %q", test.from, test.to, gotFrom, gotTo)
+		}
+		if got := editCount(chunks); got != test.wantEdits {
+			t.Errorf("DiffLines(%q, %q) makes %d edits, want %d", test.from, test.to, got, test.wantEdits)
+		}
+	}
+}
+
+func TestDiffLinesMergesChunks(t *testing.T) {
+	chunks := DiffLines([]string{"a", "b", "c", "d"}, []string{"x", "y", "c", "d"})
+	for i := 1; i < len(chunks); i++ {
+		if chunks[i].Op == chunks[i-1].Op {
+			t.Errorf("chunks %d and %d are both %s: %v", i-1, i, chunks[i].Op, chunks)
+		}
+	}
+}
+
+func TestDiffLinesTooManyEdits(t *testing.T) {
+	from := make([]string, maxDiffEdits)
+	to := make([]string, maxDiffEdits)
+	for i := range from {
+		from[i] = fmt.Sprint("old ", i)
+		to[i] = fmt.Sprint("new ", i)
+	}
+	want := []DiffChunk{{DiffDelete, from}, {DiffInsert, to}}
+	if got := DiffLines(from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("revisions past the edit limit should be shown as replaced, got %d chunks", len(got))
+	}
+}
+
+func TestDiffContent(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want []DiffChunk
+	}{
+		{"empty", "", "", nil},
+		{
+			"html is split between tags",
+			"<p>One</p><p>Two</p>",
+			"<p>One</p><p>Three</p>",
+			[]DiffChunk{{DiffEqual, []string{"<p>One</p>"}}, {DiffDelete, []string{"<p>Two</p>"}}, {DiffInsert, []string{"<p>Three</p>"}}},
+		},
+		{
+			"json is indented",
+			`{"a":1,"b":2}`,
+			`{"a":1,"b":3}`,
+			[]DiffChunk{
+				{DiffEqual, []string{"{", `  "a": 1,`}},
+				{DiffDelete, []string{`  "b": 2`}},
+				{DiffInsert, []string{`  "b": 3`}},
+				{DiffEqual, []string{"}"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := DiffContent(test.from, test.to); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: DiffContent(%q, %q) = %q, want %q", test.name, test.from, test.to, got, test.want)
+		}
+	}
+}
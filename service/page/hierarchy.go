@@ -0,0 +1,274 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/pagetype"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const MaxPathLength = 2048
+
+// hierarchyLockKey serializes changes to the page tree, so two concurrent moves can't combine into a cycle
+const hierarchyLockKey = 7306273
+
+var (
+	ErrParentNotFound = errors.New("parent page does not exist")
+	ErrParentCycle    = errors.New("page can't be moved under itself or one of its subpages")
+	ErrPathTooLong    = errors.New("page path is too long")
+	ErrHasChildren    = errors.New("page has subpages")
+	ErrPostNesting    = errors.New("posts can't have a parent or subpages")
+)
+
+// joinPath builds the path of a page from the path of its parent, which is empty for top level pages
+func joinPath(parentPath string, slug string) string {
+	if parentPath == "" {
+		return slug
+	}
+	return parentPath + "/" + slug
+}
+
+// parentPathOf returns the path that a page of the given type is nested under. Posts always live under the blog,
+// while pages are under their parent or at the top level.
+func parentPathOf(tx *gorm.DB, pageType pagetype.PageType, parentId *int) (string, error) {
+	if pageType == pagetype.Post {
+		if parentId != nil {
+			return "", ErrPostNesting
+		}
+		return BlogPath, nil
+	}
+	if parentId == nil {
+		return "", nil
+	}
+	var parents []Page
+	selectRes := tx.Model(&Page{}).Where(map[string]interface{}{"id": *parentId}).Find(&parents)
+	if selectRes.Error != nil {
+		return "", selectRes.Error
+	}
+	if len(parents) < 1 {
+		return "", ErrParentNotFound
+	}
+	if parents[0].PageType == pagetype.Post {
+		return "", ErrPostNesting
+	}
+	return parents[0].Path, nil
+}
+
+// MovePage nests a page under a new parent, or at the top level if parentId is nil. The paths of the page and all of
+// its subpages change, and their old paths redirect to the new ones.
+func MovePage(pageId int, parentId *int) (isDup bool, err error) {
+	txErr := database.Database.Transaction(func(tx *gorm.DB) error {
+		movedPage, err := lockHierarchy(tx, pageId)
+		if err != nil {
+			return err
+		}
+		return relocate(tx, movedPage, parentId, movedPage.Slug)
+	})
+	return checkDupPath(txErr)
+}
+
+// UpdateSlug changes the address of a page and its subpages, keeping the old ones as aliases. Given a transaction, it
+// runs in a savepoint of it, so that the change is only committed along with the rest of the transaction.
+func UpdateSlug(db *gorm.DB, pageId int, slug string) (isDup bool, err error) {
+	if !IsValidSlug(slug) {
+		return false, errors.New("slug is invalid")
+	}
+	txErr := db.Transaction(func(tx *gorm.DB) error {
+		renamedPage, err := lockHierarchy(tx, pageId)
+		if err != nil {
+			return err
+		}
+		return relocate(tx, renamedPage, renamedPage.ParentId, slug)
+	})
+	return checkDupPath(txErr)
+}
+
+func checkDupPath(err error) (isDup bool, _ error) {
+	var pgErr *pgconn.PgError
+	if err != nil && errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return true, nil
+	}
+	return false, err
+}
+
+// lockTree takes the page tree lock for the rest of the transaction, which is also held while picking a free path
+func lockTree(tx *gorm.DB) error {
+	return tx.Exec("select pg_advisory_xact_lock(?)", hierarchyLockKey).Error
+}
+
+// lockHierarchy takes the page tree lock for the rest of the transaction and returns the page being changed
+func lockHierarchy(tx *gorm.DB, pageId int) (*Page, error) {
+	if err := lockTree(tx); err != nil {
+		return nil, err
+	}
+	var pages []Page
+	if res := tx.Model(&Page{}).Where(map[string]interface{}{"id": pageId}).Find(&pages); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(pages) < 1 {
+		return nil, errors.New("page does not exist")
+	}
+	return &pages[0], nil
+}
+
+// checkCycle walks up from the new parent to make sure the page isn't one of its ancestors
+func checkCycle(tx *gorm.DB, pageId int, parentId *int) error {
+	for nextId := parentId; nextId != nil; {
+		if *nextId == pageId {
+			return ErrParentCycle
+		}
+		var parents []Page
+		selectRes := tx.Model(&Page{}).Select("id", "parent_id").Where(map[string]interface{}{"id": *nextId}).Find(&parents)
+		if selectRes.Error != nil {
+			return selectRes.Error
+		}
+		if len(parents) < 1 {
+			return ErrParentNotFound
+		}
+		nextId = parents[0].ParentId
+	}
+	return nil
+}
+
+// relocate gives a page a new parent and slug, then rewrites the paths of its subpages to match
+func relocate(tx *gorm.DB, movedPage *Page, parentId *int, slug string) error {
+	if err := checkCycle(tx, movedPage.ID, parentId); err != nil {
+		return err
+	}
+	if movedPage.PageType == pagetype.Post && isArchiveSlug(slug) {
+		return ErrArchiveSlug
+	}
+	parentPath, err := parentPathOf(tx, movedPage.PageType, parentId)
+	if err != nil {
+		return err
+	}
+	newPath := joinPath(parentPath, slug)
+	if newPath == movedPage.Path {
+		return nil
+	}
+	if len(newPath) > MaxPathLength {
+		return ErrPathTooLong
+	}
+	if res := tx.Model(&Page{}).Where(map[string]interface{}{"id": movedPage.ID}).
+		Updates(map[string]interface{}{"parent_id": parentId, "slug": slug}); res.Error != nil {
+		return res.Error
+	}
+	if err = changePath(tx, movedPage.ID, movedPage.Path, newPath); err != nil {
+		return err
+	}
+
+	// Work down the tree a level at a time, since each path is built from the new path of its parent
+	newPaths := map[int]string{movedPage.ID: newPath}
+	parentIds := []int{movedPage.ID}
+	for len(parentIds) > 0 {
+		var children []Page
+		selectRes := tx.Model(&Page{}).
+			Select("id", "parent_id", "slug", "path").
+			Where(map[string]interface{}{"parent_id": parentIds}).
+			Find(&children)
+		if selectRes.Error != nil {
+			return selectRes.Error
+		}
+		parentIds = parentIds[:0]
+		for _, child := range children {
+			childPath := joinPath(newPaths[*child.ParentId], child.Slug)
+			if len(childPath) > MaxPathLength {
+				return ErrPathTooLong
+			}
+			if err = changePath(tx, child.ID, child.Path, childPath); err != nil {
+				return err
+			}
+			newPaths[child.ID] = childPath
+			parentIds = append(parentIds, child.ID)
+		}
+	}
+	return nil
+}
+
+// changePath moves a page to a new path and keeps the old one as an alias. The old path may already be an alias of a
+// page that used it before, in which case this page takes it over.
+func changePath(tx *gorm.DB, pageId int, oldPath string, newPath string) error {
+	if res := tx.Model(&Page{}).Where(map[string]interface{}{"id": pageId}).
+		Update("path", newPath); res.Error != nil {
+		return res.Error
+	}
+	if oldPath != "" {
+		if res := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "path"}},
+			DoUpdates: clause.AssignmentColumns([]string{"page_id"}),
+		}).Create(&PageAlias{PageId: pageId, Path: oldPath}); res.Error != nil {
+			return res.Error
+		}
+	}
+	return tx.Where(map[string]interface{}{"page_id": pageId, "path": newPath}).Delete(&PageAlias{}).Error
+}
+
+// GetAncestors returns the published parents of a page, starting from the top level. Unpublished ancestors are left
+// out so their titles aren't shown to site visitors.
+func GetAncestors(child *Page) ([]Page, error) {
+	var ancestors []Page
+	for nextId := child.ParentId; nextId != nil; {
+		var parents []Page
+		selectRes := database.Database.Model(&Page{}).Where(map[string]interface{}{"id": *nextId}).Find(&parents)
+		if selectRes.Error != nil {
+			return nil, selectRes.Error
+		}
+		if len(parents) < 1 {
+			break
+		}
+		if parents[0].PublishedRevisionId != nil {
+			ancestors = append([]Page{parents[0]}, ancestors...)
+		}
+		nextId = parents[0].ParentId
+	}
+	return ancestors, nil
+}
+
+// ListPageTree returns every non-deleted page ordered by path, so parents come before their children. Posts are left
+// out since they are listed by date instead.
+func ListPageTree() (pages []Page, err error) {
+	selectRes := database.Database.Model(&Page{}).
+		Select("id", "parent_id", "title", "slug", "path", "published_revision_id").
+		Where(map[string]interface{}{"page_type": pagetype.Page}).
+		Order("path asc").
+		Find(&pages)
+	return pages, selectRes.Error
+}
+
+func hasChildren(tx *gorm.DB, pageId int) (bool, error) {
+	var count int64
+	countRes := tx.Model(&Page{}).Where(map[string]interface{}{"parent_id": pageId}).Count(&count)
+	return count > 0, countRes.Error
+}
+
+// BackfillPaths gives a path to pages created before pages could be nested, and drops the index that used to keep
+// slugs unique across the whole site
+func BackfillPaths() error {
+	migrator := database.Database.Migrator()
+	if migrator.HasIndex(&Page{}, "idx_pages_slug") {
+		if err := migrator.DropIndex(&Page{}, "idx_pages_slug"); err != nil {
+			return err
+		}
+	}
+	updateRes := database.Database.Unscoped().Model(&Page{}).
+		Where("parent_id is null and (path is null or path = '')").
+		Where("slug is not null and slug <> ''").
+		Update("path", gorm.Expr("slug"))
+	return updateRes.Error
+}
@@ -0,0 +1,163 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/service/media"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MediaReference records that a revision links to an upload from the media library, in its HTML or CSS. Revisions
+// never change, so they are indexed once when they are saved.
+type MediaReference struct {
+	RevisionId int             `gorm:"primaryKey"`
+	Revision   ContentRevision `gorm:"PRELOAD:false;constraint:OnDelete:CASCADE"`
+	MediaId    int             `gorm:"primaryKey;index:idx_media_references_media_id"`
+	Media      media.Media     `gorm:"PRELOAD:false;constraint:OnDelete:CASCADE"`
+}
+
+// MediaUsage is a page using an upload, in its published revision, its current draft or as its featured or sharing
+// image. A page with none of these set only has the upload in older revisions.
+type MediaUsage struct {
+	Page        Page
+	InPublished bool
+	InDraft     bool
+	AsImage     bool
+}
+
+// indexMediaReferences records the uploads a new revision links to. Revisions should be saved with MediaIndexed set
+// in the same transaction.
+func indexMediaReferences(tx *gorm.DB, revision *ContentRevision) error {
+	mediaIds, err := media.ReferencedIds(tx, revision.RenderedHtml, revision.RenderedCss)
+	if err != nil || len(mediaIds) < 1 {
+		return err
+	}
+	references := make([]MediaReference, len(mediaIds))
+	for i, mediaId := range mediaIds {
+		references[i] = MediaReference{RevisionId: revision.ID, MediaId: mediaId}
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&references).Error
+}
+
+// ensureMediaIndexed indexes a revision saved before media references were tracked
+func ensureMediaIndexed(tx *gorm.DB, revision *ContentRevision) error {
+	if revision.MediaIndexed {
+		return nil
+	}
+	if err := indexMediaReferences(tx, revision); err != nil {
+		return err
+	}
+	revision.MediaIndexed = true
+	return tx.Model(&ContentRevision{}).
+		Where(map[string]interface{}{"id": revision.ID}).
+		Update("media_indexed", true).Error
+}
+
+// BackfillMediaReferences indexes the revisions saved before media references were tracked
+func BackfillMediaReferences() error {
+	var revisions []ContentRevision
+	batchRes := database.Database.Model(&ContentRevision{}).
+		Select("id", "rendered_html", "rendered_css", "media_indexed").
+		Where(map[string]interface{}{"media_indexed": false}).
+		FindInBatches(&revisions, 100, func(tx *gorm.DB, batch int) error {
+			for i := range revisions {
+				if err := ensureMediaIndexed(database.Database, &revisions[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	return batchRes.Error
+}
+
+// WhereMediaUsed finds the non-deleted pages using an upload, in any of their revisions or as an image
+func WhereMediaUsed(upload *media.Media) ([]MediaUsage, error) {
+	var published []int
+	selectRes := database.Database.Model(&MediaReference{}).
+		Joins("inner join pages on pages.published_revision_id = media_references.revision_id and pages.deleted_at is null").
+		Where(map[string]interface{}{"media_references.media_id": upload.ID}).
+		Pluck("pages.id", &published)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	var drafts []int
+	selectRes = database.Database.Model(&MediaReference{}).
+		Joins("inner join content_revisions on content_revisions.id = media_references.revision_id").
+		Where("content_revisions.id = (select max(latest.id) from content_revisions latest where latest.page_id = content_revisions.page_id)").
+		Where(map[string]interface{}{"media_references.media_id": upload.ID}).
+		Pluck("content_revisions.page_id", &drafts)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	var images []int
+	selectRes = database.Database.Model(&Page{}).
+		Where("strpos(featured_image, ?) > 0 or strpos(og_image, ?) > 0", upload.Hash, upload.Hash).
+		Pluck("id", &images)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	var history []int
+	selectRes = database.Database.Model(&MediaReference{}).
+		Distinct("content_revisions.page_id").
+		Joins("inner join content_revisions on content_revisions.id = media_references.revision_id").
+		Where(map[string]interface{}{"media_references.media_id": upload.ID}).
+		Pluck("content_revisions.page_id", &history)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+
+	usageByPage := make(map[int]*MediaUsage)
+	var pageIds []int
+	usageOf := func(pageId int) *MediaUsage {
+		if usage, ok := usageByPage[pageId]; ok {
+			return usage
+		}
+		usageByPage[pageId] = &MediaUsage{}
+		pageIds = append(pageIds, pageId)
+		return usageByPage[pageId]
+	}
+	for _, pageId := range published {
+		usageOf(pageId).InPublished = true
+	}
+	for _, pageId := range drafts {
+		usageOf(pageId).InDraft = true
+	}
+	for _, pageId := range images {
+		usageOf(pageId).AsImage = true
+	}
+	for _, pageId := range history {
+		usageOf(pageId)
+	}
+	if len(pageIds) < 1 {
+		return nil, nil
+	}
+
+	// Trashed pages are left out, although they still keep the upload from being cleaned up
+	var pages []Page
+	selectRes = database.Database.Model(&Page{}).
+		Where(map[string]interface{}{"id": pageIds}).
+		Order("title asc").
+		Find(&pages)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	usages := make([]MediaUsage, len(pages))
+	for i, row := range pages {
+		usages[i] = *usageByPage[row.ID]
+		usages[i].Page = row
+	}
+	return usages, nil
+}
@@ -0,0 +1,55 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"errors"
+	"github.com/pgray64/tinypress/database"
+	"gorm.io/gorm"
+)
+
+// errDupSlug rolls back a metadata update whose slug is taken
+var errDupSlug = errors.New("slug is already in use")
+
+// UpdateMetadata saves the title, slug, layout and SEO fields of a page without touching its content
+func UpdateMetadata(updated *Page) (isDup bool, err error) {
+	if updated.ID < 1 {
+		return false, errors.New("page id is invalid")
+	}
+	err = database.Database.Transaction(func(tx *gorm.DB) error {
+		if updated.Slug != "" {
+			isDup, err := UpdateSlug(tx, updated.ID, updated.Slug)
+			if err != nil {
+				return err
+			}
+			if isDup {
+				return errDupSlug
+			}
+		}
+		// Select lets fields be cleared, which Updates would otherwise skip as zero values
+		updateRes := tx.Model(&Page{}).
+			Where(map[string]interface{}{"id": updated.ID}).
+			Select("title", "meta_description", "canonical_url", "og_title", "og_description", "og_image", "no_index", "layout", "updated_at").
+			Updates(updated)
+		if updateRes.Error != nil {
+			return updateRes.Error
+		}
+		// The title is part of the search document of a published page
+		return refreshSearchDocument(tx, updated.ID)
+	})
+	if errors.Is(err, errDupSlug) {
+		return true, nil
+	}
+	return false, err
+}
@@ -15,54 +15,120 @@ package page
 
 import (
 	"errors"
-	"github.com/jackc/pgconn"
-	"github.com/jackc/pgerrcode"
 	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/pagetype"
+	"github.com/pgray64/tinypress/enum/reviewstate"
+	"github.com/pgray64/tinypress/service/user"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
 type Page struct {
-	ID                  int    `gorm:"primaryKey;autoIncrement"`
-	Title               string `gorm:"not null;size:255"`
+	ID       int               `gorm:"primaryKey;autoIncrement"`
+	PageType pagetype.PageType `gorm:"not null;default:1;index:idx_pages_page_type"`
+	Title    string            `gorm:"not null;size:255"`
+	// Slug is the last segment of Path, and both are nullable so they can be added to existing installs
+	Slug                string `gorm:"size:255"`
+	Path                string `gorm:"size:2048;uniqueIndex:idx_pages_path,where:deleted_at is null"`
+	ParentId            *int   `gorm:"index:idx_pages_parent_id"`
 	PublishedRevisionId *int
 	PublishedRevision   ContentRevision `gorm:"PRELOAD:false"`
-	CreatedAt           time.Time       `gorm:"autoCreateTime"`
-	UpdatedAt           time.Time       `gorm:"autoUpdateTime"`
-	DeletedAt           gorm.DeletedAt
+	MetaDescription     string          `gorm:"not null;size:500;default:''"`
+	CanonicalUrl        string          `gorm:"not null;size:2048;default:''"`
+	OgTitle             string          `gorm:"not null;size:255;default:''"`
+	OgDescription       string          `gorm:"not null;size:500;default:''"`
+	OgImage             string          `gorm:"not null;size:2048;default:''"`
+	NoIndex             bool            `gorm:"not null;default:false"`
+	// Layout is the name of a layout in the active theme, and empty for the default one
+	Layout string `gorm:"not null;size:100;default:''"`
+	// Users are only ever soft-deleted, but attribution is kept even if one is removed by hand
+	CreatedById   *int
+	CreatedBy     user.User `gorm:"PRELOAD:false;foreignKey:CreatedById;constraint:OnDelete:SET NULL"`
+	PublishedById *int
+	PublishedBy   user.User `gorm:"PRELOAD:false;foreignKey:PublishedById;constraint:OnDelete:SET NULL"`
+	PublishedAt   *time.Time
+	// PostDate, Excerpt, FeaturedImage and Author are only used by posts. The date shown on a post is PostDate if it
+	// was set by hand, or PublishedAt otherwise.
+	PostDate      *time.Time
+	Excerpt       string `gorm:"not null;size:1000;default:''"`
+	FeaturedImage string `gorm:"not null;size:2048;default:''"`
+	AuthorId      *int
+	Author        user.User `gorm:"PRELOAD:false;foreignKey:AuthorId;constraint:OnDelete:SET NULL"`
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
+	DeletedAt     gorm.DeletedAt
 }
 
 // ContentRevision contains the content for every version of the page, with the latest one being the current revision
 type ContentRevision struct {
-	ID            int       `gorm:"primaryKey;autoIncrement"`
-	PageId        int       `gorm:"not null;index:idx_content_revision_page_id;foreignKey:PageId"`
-	RenderedHtml  string    `gorm:"not null"`
-	RenderedCss   string    `gorm:"not null"`
-	EditorContent string    `gorm:"not null"`
-	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	ID            int    `gorm:"primaryKey;autoIncrement"`
+	PageId        int    `gorm:"not null;index:idx_content_revision_page_id;foreignKey:PageId"`
+	RenderedHtml  string `gorm:"not null"`
+	RenderedCss   string `gorm:"not null"`
+	EditorContent string `gorm:"not null"`
+	// SearchText is RenderedHtml as plain text, for full-text search
+	SearchText string `gorm:"not null;default:''"`
+	// MediaIndexed is set once the uploads the revision links to are recorded, see media.go
+	MediaIndexed bool `gorm:"not null;default:false"`
+	// ReviewState is where the revision is in the editorial workflow, and only approved revisions can be published
+	ReviewState reviewstate.ReviewState `gorm:"not null;default:1"`
+	CreatedById *int
+	CreatedBy   user.User `gorm:"PRELOAD:false;foreignKey:CreatedById;constraint:OnDelete:SET NULL"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
 }
 
 func (page *Page) Create(content *ContentRevision) (id int, isDup bool, err error) {
-	insertPageRes := database.Database.Create(page)
-	var pgErr *pgconn.PgError
-
-	if insertPageRes.Error != nil {
-		if insertPageRes.Error != nil && errors.As(insertPageRes.Error, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			return 0, true, nil
+	if page.PageType == 0 {
+		page.PageType = pagetype.Page
+	}
+	// The tree lock keeps a restore from the trash taking the slug between picking it and inserting the page
+	err = database.Database.Transaction(func(tx *gorm.DB) error {
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+		parentPath, err := parentPathOf(tx, page.PageType, page.ParentId)
+		if err != nil {
+			return err
+		}
+		if page.Slug == "" {
+			slug := Slugify(page.Title)
+			if page.PageType == pagetype.Post && isArchiveSlug(slug) {
+				slug += "-post"
+			}
+			page.Slug, err = uniqueSlug(tx, parentPath, slug)
+			if err != nil {
+				return err
+			}
+		} else if page.PageType == pagetype.Post && isArchiveSlug(page.Slug) {
+			return ErrArchiveSlug
+		}
+		page.Path = joinPath(parentPath, page.Slug)
+		if len(page.Path) > MaxPathLength {
+			return ErrPathTooLong
 		}
-		return 0, false, insertPageRes.Error
+		return tx.Create(page).Error
+	})
+	if isDup, err = checkDupPath(err); isDup || err != nil {
+		return 0, isDup, err
 	}
 
 	content.PageId = page.ID
-	insertContentRes := database.Database.Create(content)
+	content.SearchText = ExtractText(content.RenderedHtml)
+	content.MediaIndexed = true
+	txErr := database.Database.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Create(content); res.Error != nil {
+			return res.Error
+		}
+		return indexMediaReferences(tx, content)
+	})
 
-	return page.ID, false, insertContentRes.Error
+	return page.ID, false, txErr
 
 }
 
 func GetPageWithDraft(pageId int) (*Page, *ContentRevision, error) {
 	var pages []Page
-	var drafts []ContentRevision
 	var selectPageRes = database.Database.Model(&Page{}).Where(map[string]interface{}{"id": pageId}).Find(&pages)
 	if selectPageRes.Error != nil {
 		return nil, nil, selectPageRes.Error
@@ -70,23 +136,72 @@ func GetPageWithDraft(pageId int) (*Page, *ContentRevision, error) {
 	if len(pages) < 1 {
 		return nil, nil, nil
 	}
-	var selectDraftRes = database.Database.Model(&ContentRevision{}).
+	draft, err := latestRevision(database.Database, pageId)
+	return &pages[0], draft, err
+}
+
+// latestRevision returns the current draft of a page, which is always its newest revision
+func latestRevision(tx *gorm.DB, pageId int) (*ContentRevision, error) {
+	var drafts []ContentRevision
+	var selectDraftRes = tx.Model(&ContentRevision{}).
 		Where(map[string]interface{}{"page_id": pageId}).
 		Order("id desc").
 		Limit(1).
 		Find(&drafts)
 	if selectDraftRes.Error != nil || len(drafts) < 1 {
-		return &pages[0], nil, selectDraftRes.Error
+		return nil, selectDraftRes.Error
 	}
-	return &pages[0], &drafts[0], nil
+	return &drafts[0], nil
 }
 
 func SaveDraft(draft *ContentRevision) error {
 	if draft.ID > 0 {
 		return errors.New("you can only append to drafts")
 	}
-	insertRes := database.Database.Create(draft)
-	return insertRes.Error
+	draft.SearchText = ExtractText(draft.RenderedHtml)
+	draft.MediaIndexed = true
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Create(draft); res.Error != nil {
+			return res.Error
+		}
+		return indexMediaReferences(tx, draft)
+	})
+}
+
+// SaveDraftOnto appends a draft only if baseRevisionId is still the latest revision of the page. Otherwise nothing is
+// saved and the newer revision is returned, so the editor can review it before deciding to overwrite it.
+func SaveDraftOnto(draft *ContentRevision, baseRevisionId int) (newer *ContentRevision, err error) {
+	if draft.ID > 0 {
+		return nil, errors.New("you can only append to drafts")
+	}
+	txErr := database.Database.Transaction(func(tx *gorm.DB) error {
+		// Lock the page so that concurrent saves are checked one at a time
+		var pages []Page
+		if res := tx.Model(&Page{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(map[string]interface{}{"id": draft.PageId}).
+			Find(&pages); res.Error != nil {
+			return res.Error
+		}
+		if len(pages) < 1 {
+			return errors.New("page does not exist")
+		}
+		latest, err := latestRevision(tx, draft.PageId)
+		if err != nil {
+			return err
+		}
+		if latest != nil && latest.ID != baseRevisionId {
+			newer = latest
+			return nil
+		}
+		draft.SearchText = ExtractText(draft.RenderedHtml)
+		draft.MediaIndexed = true
+		if res := tx.Create(draft); res.Error != nil {
+			return res.Error
+		}
+		return indexMediaReferences(tx, draft)
+	})
+	return newer, txErr
 }
 
 func UpdateRecentlyEditedPage(pageId int) error {
@@ -99,13 +214,20 @@ func UpdateRecentlyEditedPage(pageId int) error {
 	return updateRes.Error
 }
 
-func PublishDraft(draftId int) error {
+// PublishDraft makes an approved draft the live version of its page
+func PublishDraft(draftId int, userId int) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		return publishDraft(tx, draftId, &userId)
+	})
+}
+
+func publishDraft(tx *gorm.DB, draftId int, userId *int) error {
 	if draftId < 1 {
 		return errors.New("draft id is invalid")
 	}
 	var drafts []ContentRevision
 	// Doing this will prevent publishing a soft-deleted draft
-	var selectRes = database.Database.Model(&ContentRevision{}).Where(map[string]interface{}{"id": draftId}).Find(&drafts)
+	var selectRes = tx.Model(&ContentRevision{}).Where(map[string]interface{}{"id": draftId}).Find(&drafts)
 	if selectRes.Error != nil {
 		return selectRes.Error
 	}
@@ -114,22 +236,89 @@ func PublishDraft(draftId int) error {
 	}
 	draft := drafts[0]
 	pageId := draft.PageId
-	updateRes := database.Database.Where(map[string]interface{}{"id": pageId}).Updates(&Page{
+	if err := ensureMediaIndexed(tx, &draft); err != nil {
+		return err
+	}
+	if err := transitionReview(tx, draft.ID, reviewstate.Published, "", userId); err != nil {
+		return err
+	}
+	publishedAt := time.Now()
+	updateRes := tx.Where(map[string]interface{}{"id": pageId}).Updates(&Page{
 		PublishedRevisionId: &draft.ID,
+		PublishedById:       userId,
+		PublishedAt:         &publishedAt,
 	})
-	return updateRes.Error
+	if updateRes.Error != nil {
+		return updateRes.Error
+	}
+	return refreshSearchDocument(tx, pageId)
+}
+
+// unpublish takes the page off the public site while keeping all of its revisions
+func unpublish(tx *gorm.DB, pageId int) error {
+	updateRes := tx.Model(&Page{}).
+		Where(map[string]interface{}{"id": pageId}).
+		Updates(map[string]interface{}{"published_revision_id": nil, "published_by_id": nil, "published_at": nil})
+	if updateRes.Error != nil {
+		return updateRes.Error
+	}
+	return refreshSearchDocument(tx, pageId)
+}
+
+// ListRecentlyEditedPages returns pages by when they were last edited, only including those with a term if termId is
+// set
+func ListRecentlyEditedPages(termId int, page int, perPage int) (pages []Page, totalCount int64, err error) {
+	return listRecentlyEdited(pagetype.Page, termId, page, perPage)
 }
 
-func ListRecentlyEditedPages(page int, perPage int) (pages []Page, totalCount int64, err error) {
-	countRes := database.Database.Model(&Page{}).Count(&totalCount)
+func listRecentlyEdited(pageType pagetype.PageType, termId int, page int, perPage int) (pages []Page, totalCount int64, err error) {
+	query := database.Database.Model(&Page{}).Where(map[string]interface{}{"page_type": pageType})
+	if termId > 0 {
+		// page_terms belongs to service/taxonomy, which depends on this package
+		query = query.Where("pages.id in (select page_terms.page_id from page_terms where page_terms.term_id = ?)", termId)
+	}
+	countRes := query.Session(&gorm.Session{}).Count(&totalCount)
 	if countRes.Error != nil {
 		return pages, 0, countRes.Error
 	}
 	offset := perPage * page
-	var selectRes = database.Database.Model(&Page{}).
+	var selectRes = query.
 		Offset(offset).
 		Limit(perPage).
 		Order("updated_at desc").
 		Find(&pages)
 	return pages, totalCount, selectRes.Error
 }
+
+// GetPublishedPage returns the page with its published revision, or nil if the page is unpublished or deleted
+func GetPublishedPage(pageId int) (*Page, *ContentRevision, error) {
+	var pages []Page
+	var revisions []ContentRevision
+	var selectPageRes = database.Database.Model(&Page{}).
+		Where(map[string]interface{}{"id": pageId}).
+		Where("published_revision_id is not null").
+		Find(&pages)
+	if selectPageRes.Error != nil {
+		return nil, nil, selectPageRes.Error
+	}
+	if len(pages) < 1 {
+		return nil, nil, nil
+	}
+	var selectRevisionRes = database.Database.Model(&ContentRevision{}).
+		Where(map[string]interface{}{"id": *pages[0].PublishedRevisionId, "page_id": pageId}).
+		Find(&revisions)
+	if selectRevisionRes.Error != nil || len(revisions) < 1 {
+		return nil, nil, selectRevisionRes.Error
+	}
+	return &pages[0], &revisions[0], nil
+}
+
+// GetPage returns the non-deleted page, or nil if it does not exist
+func GetPage(pageId int) (*Page, error) {
+	var pages []Page
+	var selectRes = database.Database.Model(&Page{}).Where(map[string]interface{}{"id": pageId}).Find(&pages)
+	if selectRes.Error != nil || len(pages) < 1 {
+		return nil, selectRes.Error
+	}
+	return &pages[0], nil
+}
@@ -0,0 +1,101 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"errors"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/pagetype"
+	"gorm.io/gorm"
+	"regexp"
+	"time"
+)
+
+// BlogPath is where the blog index and archives live, with every post under it
+const BlogPath = "blog"
+
+// DisplayDateColumn is the date a post is listed under, which for regular pages is just when they were published
+const DisplayDateColumn = "coalesce(pages.post_date, pages.published_at)"
+
+var ErrArchiveSlug = errors.New("post slug can't be a year or month")
+
+var archiveSlugPattern = regexp.MustCompile(`^[0-9]{1,4}$`)
+
+// isArchiveSlug checks for post slugs that would be shadowed by the year and month archives of the blog
+func isArchiveSlug(slug string) bool {
+	return archiveSlugPattern.MatchString(slug)
+}
+
+// DisplayDate is the date shown on a post, or nil if it has never been published
+func (page *Page) DisplayDate() *time.Time {
+	if page.PostDate != nil {
+		return page.PostDate
+	}
+	return page.PublishedAt
+}
+
+// ListRecentlyEditedPosts returns posts by when they were last edited, only including those with a term if termId is
+// set
+func ListRecentlyEditedPosts(termId int, page int, perPage int) (posts []Page, totalCount int64, err error) {
+	return listRecentlyEdited(pagetype.Post, termId, page, perPage)
+}
+
+// ListPublishedPosts returns published posts newest first. If from and to are set, only posts dated in that range are
+// included, which is how the yearly and monthly archives are built. Posts dated in the future are left out until then.
+func ListPublishedPosts(from *time.Time, to *time.Time, page int, perPage int) (posts []Page, totalCount int64, err error) {
+	query := database.Database.Model(&Page{}).
+		Where(map[string]interface{}{"page_type": pagetype.Post}).
+		Where("published_revision_id is not null").
+		Where(DisplayDateColumn+" <= ?", time.Now())
+	if from != nil && to != nil {
+		query = query.Where(DisplayDateColumn+" >= ? and "+DisplayDateColumn+" < ?", *from, *to)
+	}
+	countRes := query.Session(&gorm.Session{}).Count(&totalCount)
+	if countRes.Error != nil {
+		return posts, 0, countRes.Error
+	}
+	offset := perPage * page
+	selectRes := query.
+		Order(DisplayDateColumn + " desc").
+		Order("id desc").
+		Offset(offset).
+		Limit(perPage).
+		Find(&posts)
+	return posts, totalCount, selectRes.Error
+}
+
+// ListLatestPublished returns the most recently published pages and posts, for the site-wide feeds
+func ListLatestPublished(limit int) (pages []Page, err error) {
+	selectRes := database.Database.Model(&Page{}).
+		Where("published_revision_id is not null").
+		Where(DisplayDateColumn+" <= ?", time.Now()).
+		Order(DisplayDateColumn + " desc").
+		Order("id desc").
+		Limit(limit).
+		Find(&pages)
+	return pages, selectRes.Error
+}
+
+// UpdatePostDetails saves the fields that only posts have
+func UpdatePostDetails(updated *Page) error {
+	if updated.ID < 1 {
+		return errors.New("page id is invalid")
+	}
+	// Select lets fields be cleared, which Updates would otherwise skip as zero values
+	updateRes := database.Database.Model(&Page{}).
+		Where(map[string]interface{}{"id": updated.ID, "page_type": pagetype.Post}).
+		Select("post_date", "excerpt", "featured_image", "author_id", "updated_at").
+		Updates(updated)
+	return updateRes.Error
+}
@@ -0,0 +1,163 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"errors"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/reviewstate"
+	"github.com/pgray64/tinypress/enum/scheduleaction"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// ReviewEvent is a comment on a revision, along with the review state change it came with, if any
+type ReviewEvent struct {
+	ID         int                     `gorm:"primaryKey;autoIncrement"`
+	PageId     int                     `gorm:"not null;index:idx_review_events_page_id"`
+	RevisionId int                     `gorm:"not null;index:idx_review_events_revision_id"`
+	FromState  reviewstate.ReviewState `gorm:"not null"`
+	ToState    reviewstate.ReviewState `gorm:"not null"`
+	Comment    string                  `gorm:"not null"`
+	UserId     *int
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+var (
+	ErrInvalidTransition = errors.New("revision can't move to that review state")
+	ErrSelfApproval      = errors.New("revision can't be approved by its author")
+)
+
+// allowedTransitions lists the states a revision may be in before moving to each state
+var allowedTransitions = map[reviewstate.ReviewState][]reviewstate.ReviewState{
+	reviewstate.InReview:         {reviewstate.Draft, reviewstate.ChangesRequested},
+	reviewstate.ChangesRequested: {reviewstate.InReview},
+	reviewstate.Approved:         {reviewstate.InReview},
+	reviewstate.Published:        {reviewstate.Approved, reviewstate.Published},
+}
+
+// BackfillReviewStates moves revisions that predate content review out of the draft state. Published revisions are
+// marked published, and those with a pending publish schedule approved, so that the schedule can still run.
+func BackfillReviewStates() error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		updateRes := tx.Model(&ContentRevision{}).
+			Where(map[string]interface{}{"review_state": reviewstate.Draft}).
+			Where("id in (select published_revision_id from pages where published_revision_id is not null)").
+			Update("review_state", reviewstate.Published)
+		if updateRes.Error != nil {
+			return updateRes.Error
+		}
+		updateRes = tx.Model(&ContentRevision{}).
+			Where(map[string]interface{}{"review_state": reviewstate.Draft}).
+			Where("id in (select revision_id from publish_schedules where applied_at is null and canceled_at is null and action = ?)",
+				scheduleaction.Publish).
+			Update("review_state", reviewstate.Approved)
+		return updateRes.Error
+	})
+}
+
+// DeleteOrphanedReviewEvents removes the review history of pages that were purged before it was deleted along with them
+func DeleteOrphanedReviewEvents() error {
+	deleteRes := database.Database.
+		Where("not exists (select 1 from pages where pages.id = review_events.page_id)").
+		Delete(&ReviewEvent{})
+	return deleteRes.Error
+}
+
+func canTransition(from reviewstate.ReviewState, to reviewstate.ReviewState) bool {
+	for _, allowed := range allowedTransitions[to] {
+		if from == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition rejects moves the workflow doesn't allow, and authors approving their own revisions
+func checkTransition(revision ContentRevision, to reviewstate.ReviewState, userId *int) error {
+	if !canTransition(revision.ReviewState, to) {
+		return ErrInvalidTransition
+	}
+	if to == reviewstate.Approved && userId != nil && revision.CreatedById != nil && *userId == *revision.CreatedById {
+		return ErrSelfApproval
+	}
+	return nil
+}
+
+// TransitionReview moves a revision to a new review state, recording who did it and why
+func TransitionReview(revisionId int, to reviewstate.ReviewState, comment string, userId int) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		return transitionReview(tx, revisionId, to, comment, &userId)
+	})
+}
+
+func transitionReview(tx *gorm.DB, revisionId int, to reviewstate.ReviewState, comment string, userId *int) error {
+	var revisions []ContentRevision
+	if res := tx.Model(&ContentRevision{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(map[string]interface{}{"id": revisionId}).
+		Find(&revisions); res.Error != nil {
+		return res.Error
+	}
+	if len(revisions) < 1 {
+		return errors.New("revision does not exist")
+	}
+	revision := revisions[0]
+	if err := checkTransition(revision, to, userId); err != nil {
+		return err
+	}
+	if res := tx.Model(&ContentRevision{}).
+		Where(map[string]interface{}{"id": revisionId}).
+		Updates(&ContentRevision{ReviewState: to}); res.Error != nil {
+		return res.Error
+	}
+	return tx.Create(&ReviewEvent{
+		PageId:     revision.PageId,
+		RevisionId: revision.ID,
+		FromState:  revision.ReviewState,
+		ToState:    to,
+		Comment:    comment,
+		UserId:     userId,
+	}).Error
+}
+
+// AddReviewComment comments on a revision without changing its review state
+func AddReviewComment(revisionId int, comment string, userId int) error {
+	revision, err := GetRevision(revisionId)
+	if err != nil {
+		return err
+	}
+	if revision == nil {
+		return errors.New("revision does not exist")
+	}
+	insertRes := database.Database.Create(&ReviewEvent{
+		PageId:     revision.PageId,
+		RevisionId: revision.ID,
+		FromState:  revision.ReviewState,
+		ToState:    revision.ReviewState,
+		Comment:    comment,
+		UserId:     &userId,
+	})
+	return insertRes.Error
+}
+
+// ListReviewEvents returns the review history of every revision of a page, newest first
+func ListReviewEvents(pageId int) (events []ReviewEvent, err error) {
+	selectRes := database.Database.Model(&ReviewEvent{}).
+		Where(map[string]interface{}{"page_id": pageId}).
+		Order("id desc").
+		Find(&events)
+	return events, selectRes.Error
+}
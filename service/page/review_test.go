@@ -0,0 +1,73 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"errors"
+	"github.com/pgray64/tinypress/enum/reviewstate"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from reviewstate.ReviewState
+		to   reviewstate.ReviewState
+		want bool
+	}{
+		{reviewstate.Draft, reviewstate.InReview, true},
+		{reviewstate.ChangesRequested, reviewstate.InReview, true},
+		{reviewstate.InReview, reviewstate.ChangesRequested, true},
+		{reviewstate.InReview, reviewstate.Approved, true},
+		{reviewstate.Approved, reviewstate.Published, true},
+		// Republishing the live revision
+		{reviewstate.Published, reviewstate.Published, true},
+		{reviewstate.Draft, reviewstate.Approved, false},
+		{reviewstate.Draft, reviewstate.Published, false},
+		{reviewstate.InReview, reviewstate.Published, false},
+		{reviewstate.ChangesRequested, reviewstate.Approved, false},
+		{reviewstate.Approved, reviewstate.InReview, false},
+		{reviewstate.InReview, reviewstate.InReview, false},
+		{reviewstate.Published, reviewstate.InReview, false},
+		{reviewstate.Approved, reviewstate.Draft, false},
+	}
+	for _, test := range tests {
+		if got := canTransition(test.from, test.to); got != test.want {
+			t.Errorf("canTransition(%d, %d) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+	author, reviewer := 1, 2
+	tests := []struct {
+		name     string
+		revision ContentRevision
+		to       reviewstate.ReviewState
+		userId   *int
+		wantErr  error
+	}{
+		{"reviewer approves", ContentRevision{ReviewState: reviewstate.InReview, CreatedById: &author}, reviewstate.Approved, &reviewer, nil},
+		{"author approves", ContentRevision{ReviewState: reviewstate.InReview, CreatedById: &author}, reviewstate.Approved, &author, ErrSelfApproval},
+		{"author of deleted account", ContentRevision{ReviewState: reviewstate.InReview}, reviewstate.Approved, &author, nil},
+		{"author submits", ContentRevision{ReviewState: reviewstate.Draft, CreatedById: &author}, reviewstate.InReview, &author, nil},
+		{"author requests changes", ContentRevision{ReviewState: reviewstate.InReview, CreatedById: &author}, reviewstate.ChangesRequested, &author, nil},
+		{"scheduler publishes", ContentRevision{ReviewState: reviewstate.Approved, CreatedById: &author}, reviewstate.Published, nil, nil},
+		{"approving a draft", ContentRevision{ReviewState: reviewstate.Draft, CreatedById: &author}, reviewstate.Approved, &reviewer, ErrInvalidTransition},
+	}
+	for _, test := range tests {
+		if err := checkTransition(test.revision, test.to, test.userId); !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: checkTransition() error = %v, want %v", test.name, err, test.wantErr)
+		}
+	}
+}
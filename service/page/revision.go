@@ -0,0 +1,73 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"errors"
+	"github.com/pgray64/tinypress/database"
+)
+
+// ListRevisions returns the revisions of a page newest first, without their content
+func ListRevisions(pageId int, page int, perPage int) (revisions []ContentRevision, totalCount int64, err error) {
+	countRes := database.Database.Model(&ContentRevision{}).
+		Where(map[string]interface{}{"page_id": pageId}).
+		Count(&totalCount)
+	if countRes.Error != nil {
+		return revisions, 0, countRes.Error
+	}
+	offset := perPage * page
+	selectRes := database.Database.Model(&ContentRevision{}).
+		Select("id", "page_id", "review_state", "created_by_id", "created_at").
+		Where(map[string]interface{}{"page_id": pageId}).
+		Order("id desc").
+		Offset(offset).
+		Limit(perPage).
+		Find(&revisions)
+	return revisions, totalCount, selectRes.Error
+}
+
+// GetRevision returns a revision of a non-deleted page, or nil if there is none
+func GetRevision(revisionId int) (*ContentRevision, error) {
+	var revisions []ContentRevision
+	selectRes := database.Database.Model(&ContentRevision{}).
+		Joins("inner join pages on pages.id = content_revisions.page_id and pages.deleted_at is null").
+		Where(map[string]interface{}{"content_revisions.id": revisionId}).
+		Find(&revisions)
+	if selectRes.Error != nil || len(revisions) < 1 {
+		return nil, selectRes.Error
+	}
+	return &revisions[0], nil
+}
+
+// RestoreRevision appends a copy of an old revision, making it the current draft again
+func RestoreRevision(revisionId int, userId int) (*ContentRevision, error) {
+	revision, err := GetRevision(revisionId)
+	if err != nil {
+		return nil, err
+	}
+	if revision == nil {
+		return nil, errors.New("revision does not exist")
+	}
+	draft := ContentRevision{
+		PageId:        revision.PageId,
+		RenderedHtml:  revision.RenderedHtml,
+		RenderedCss:   revision.RenderedCss,
+		EditorContent: revision.EditorContent,
+		CreatedById:   &userId,
+	}
+	if err = SaveDraft(&draft); err != nil {
+		return nil, err
+	}
+	return &draft, UpdateRecentlyEditedPage(revision.PageId)
+}
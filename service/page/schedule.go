@@ -0,0 +1,184 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/reviewstate"
+	"github.com/pgray64/tinypress/enum/scheduleaction"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// PublishSchedule is a publish or unpublish of a page that the scheduler applies once RunAt has passed
+type PublishSchedule struct {
+	ID          int                           `gorm:"primaryKey;autoIncrement"`
+	PageId      int                           `gorm:"not null;index:idx_publish_schedules_page_id"`
+	RevisionId  *int                          // Only set when publishing
+	Action      scheduleaction.ScheduleAction `gorm:"not null"`
+	RunAt       time.Time                     `gorm:"not null;index:idx_publish_schedules_run_at"`
+	CreatedById *int
+	AppliedAt   *time.Time
+	CanceledAt  *time.Time
+	// FailureReason is why the scheduler canceled the schedule instead of applying it, if it did
+	FailureReason string    `gorm:"not null;size:1000;default:''"`
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+}
+
+// SchedulePublish queues a draft to go live at publishAt and/or the page to come down at expireAt. A new schedule
+// replaces any pending one for the same action.
+func SchedulePublish(draftId int, publishAt *time.Time, expireAt *time.Time, userId int) error {
+	if publishAt == nil && expireAt == nil {
+		return errors.New("nothing to schedule")
+	}
+	if publishAt != nil && expireAt != nil && !expireAt.After(*publishAt) {
+		return errors.New("expiry must be after publishing")
+	}
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		var drafts []ContentRevision
+		if res := tx.Model(&ContentRevision{}).Where(map[string]interface{}{"id": draftId}).Find(&drafts); res.Error != nil {
+			return res.Error
+		}
+		if len(drafts) < 1 {
+			return errors.New("draft does not exist")
+		}
+		pageId := drafts[0].PageId
+		if publishAt != nil && !canTransition(drafts[0].ReviewState, reviewstate.Published) {
+			return ErrInvalidTransition
+		}
+
+		var schedules []PublishSchedule
+		if publishAt != nil {
+			schedules = append(schedules, PublishSchedule{
+				PageId:      pageId,
+				RevisionId:  &drafts[0].ID,
+				Action:      scheduleaction.Publish,
+				RunAt:       *publishAt,
+				CreatedById: &userId,
+			})
+		}
+		if expireAt != nil {
+			schedules = append(schedules, PublishSchedule{
+				PageId:      pageId,
+				Action:      scheduleaction.Unpublish,
+				RunAt:       *expireAt,
+				CreatedById: &userId,
+			})
+		}
+		for _, schedule := range schedules {
+			if err := cancelPendingSchedules(tx, pageId, schedule.Action); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&schedules).Error
+	})
+}
+
+func cancelPendingSchedules(tx *gorm.DB, pageId int, action scheduleaction.ScheduleAction) error {
+	updateRes := tx.Model(&PublishSchedule{}).
+		Where(map[string]interface{}{"page_id": pageId, "action": action, "applied_at": nil, "canceled_at": nil}).
+		Updates(&PublishSchedule{CanceledAt: timePtr(time.Now())})
+	return updateRes.Error
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// CancelSchedule stops a pending schedule from being applied, returning false if it already ran or was canceled
+func CancelSchedule(scheduleId int) (bool, error) {
+	updateRes := database.Database.Model(&PublishSchedule{}).
+		Where(map[string]interface{}{"id": scheduleId, "applied_at": nil, "canceled_at": nil}).
+		Updates(&PublishSchedule{CanceledAt: timePtr(time.Now())})
+	return updateRes.RowsAffected > 0, updateRes.Error
+}
+
+// ListPendingSchedules returns the schedules of a page that have yet to run, soonest first
+func ListPendingSchedules(pageId int) (schedules []PublishSchedule, err error) {
+	selectRes := database.Database.Model(&PublishSchedule{}).
+		Where(map[string]interface{}{"page_id": pageId, "applied_at": nil, "canceled_at": nil}).
+		Order("run_at asc").
+		Find(&schedules)
+	return schedules, selectRes.Error
+}
+
+// ApplyDueSchedules runs every schedule whose time has come. Each one is claimed with a row lock and marked applied
+// in the same transaction, so it runs exactly once even with several Tinypress instances sharing the database. A
+// schedule that fails is canceled with the reason recorded, so that it doesn't hold up the ones after it, and the
+// first failure is returned once every due schedule has been handled.
+func ApplyDueSchedules() error {
+	var firstFailure error
+	for {
+		var claimed bool
+		txErr := database.Database.Transaction(func(tx *gorm.DB) error {
+			var due []PublishSchedule
+			selectRes := tx.Model(&PublishSchedule{}).
+				Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where(map[string]interface{}{"applied_at": nil, "canceled_at": nil}).
+				Where("run_at <= ?", time.Now()).
+				Order("run_at asc").
+				Limit(1).
+				Find(&due)
+			if selectRes.Error != nil || len(due) < 1 {
+				return selectRes.Error
+			}
+			claimed = true
+			schedule := due[0]
+
+			// Pages deleted since scheduling are left alone
+			var count int64
+			if res := tx.Model(&Page{}).Where(map[string]interface{}{"id": schedule.PageId}).Count(&count); res.Error != nil {
+				return res.Error
+			}
+			if count > 0 {
+				// The savepoint undoes anything the action wrote before failing, while keeping the schedule locked
+				err := tx.Transaction(func(actionTx *gorm.DB) error {
+					switch schedule.Action {
+					case scheduleaction.Publish:
+						return publishDraft(actionTx, *schedule.RevisionId, schedule.CreatedById)
+					case scheduleaction.Unpublish:
+						return unpublish(actionTx, schedule.PageId)
+					}
+					return nil
+				})
+				if err != nil {
+					// Such as the revision no longer being approved, which retrying won't fix
+					if firstFailure == nil {
+						firstFailure = fmt.Errorf("schedule %d failed: %w", schedule.ID, err)
+					}
+					// The column holds 1000 characters, and cutting bytes could split one and make the update fail
+					reason := []rune(err.Error())
+					if len(reason) > 1000 {
+						reason = reason[:1000]
+					}
+					return tx.Model(&PublishSchedule{}).
+						Where(map[string]interface{}{"id": schedule.ID}).
+						Updates(&PublishSchedule{CanceledAt: timePtr(time.Now()), FailureReason: string(reason)}).Error
+				}
+			}
+			return tx.Model(&PublishSchedule{}).
+				Where(map[string]interface{}{"id": schedule.ID}).
+				Updates(&PublishSchedule{AppliedAt: timePtr(time.Now())}).Error
+		})
+		if txErr != nil {
+			return txErr
+		}
+		if !claimed {
+			return firstFailure
+		}
+	}
+}
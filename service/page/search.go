@@ -0,0 +1,206 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/pagetype"
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchConfig is the Postgres text search configuration, which decides stemming and stop words
+const searchConfig = "english"
+
+// Snippets come back from ts_headline with matches between these, which can't appear in extracted text, so that the
+// rest of the snippet can be escaped before the matches are marked up
+const (
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+)
+
+const headlineOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MinWords=15, MaxWords=35, MaxFragments=2"
+
+// SearchDocument is the full-text index entry of a published page, made from its title and published content
+type SearchDocument struct {
+	PageId    int       `gorm:"primaryKey"`
+	Page      Page      `gorm:"PRELOAD:false;constraint:OnDelete:CASCADE"`
+	Vector    string    `gorm:"type:tsvector;not null;index:idx_search_documents_vector,type:gin"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+type SearchResult struct {
+	PageId      int
+	Title       string
+	Path        string
+	PageType    pagetype.PageType
+	IsPublished bool
+	// Snippet is HTML, with the matching words wrapped in <mark>
+	Snippet string
+}
+
+// skippedElements hold content that isn't text visitors read
+var skippedElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"template": true,
+}
+
+// ExtractText turns rendered HTML into the plain text that gets indexed, leaving out scripts and styles
+func ExtractText(renderedHtml string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(renderedHtml))
+	var text strings.Builder
+	skipDepth := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// Also the end of the input
+			return strings.Join(strings.FieldsFunc(text.String(), isTextSeparator), " ")
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if skippedElements[string(name)] {
+				skipDepth++
+			}
+			// Keep words in neighbouring elements apart
+			text.WriteByte(' ')
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if skippedElements[string(name)] && skipDepth > 0 {
+				skipDepth--
+			}
+			text.WriteByte(' ')
+		case html.SelfClosingTagToken:
+			text.WriteByte(' ')
+		case html.TextToken:
+			if skipDepth == 0 {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+func isTextSeparator(r rune) bool {
+	return unicode.IsSpace(r) || r == rune(highlightStart[0]) || r == rune(highlightStop[0])
+}
+
+// highlight escapes a snippet from ts_headline and marks up the matches in it
+func highlight(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, highlightStart, "<mark>")
+	return strings.ReplaceAll(snippet, highlightStop, "</mark>")
+}
+
+// indexPublished replaces the search documents of published pages matching the condition
+func indexPublished(tx *gorm.DB, condition string, args ...interface{}) error {
+	insertArgs := append([]interface{}{searchConfig, searchConfig}, args...)
+	insertRes := tx.Exec(`insert into search_documents (page_id, vector, updated_at)
+select pages.id,
+	setweight(to_tsvector(?::regconfig, pages.title), 'A') || setweight(to_tsvector(?::regconfig, content_revisions.search_text), 'B'),
+	now()
+from pages
+inner join content_revisions on content_revisions.id = pages.published_revision_id
+where pages.deleted_at is null and `+condition+`
+on conflict (page_id) do update set vector = excluded.vector, updated_at = excluded.updated_at`, insertArgs...)
+	return insertRes.Error
+}
+
+// refreshSearchDocument indexes a page as it is published now, or drops it from the index if it isn't published
+func refreshSearchDocument(tx *gorm.DB, pageId int) error {
+	deleteRes := tx.Where(map[string]interface{}{"page_id": pageId}).Delete(&SearchDocument{})
+	if deleteRes.Error != nil {
+		return deleteRes.Error
+	}
+	return indexPublished(tx, "pages.id = ?", pageId)
+}
+
+// BackfillSearch extracts the text of revisions saved before search existed, and indexes pages published before then
+func BackfillSearch() error {
+	var revisions []ContentRevision
+	batchRes := database.Database.Model(&ContentRevision{}).
+		Select("id", "rendered_html").
+		Where(map[string]interface{}{"search_text": ""}).
+		Where("rendered_html <> ''").
+		FindInBatches(&revisions, 100, func(tx *gorm.DB, batch int) error {
+			for _, revision := range revisions {
+				updateRes := database.Database.Model(&ContentRevision{}).
+					Where(map[string]interface{}{"id": revision.ID}).
+					Update("search_text", ExtractText(revision.RenderedHtml))
+				if updateRes.Error != nil {
+					return updateRes.Error
+				}
+			}
+			return nil
+		})
+	if batchRes.Error != nil {
+		return batchRes.Error
+	}
+	return indexPublished(database.Database, "not exists (select 1 from search_documents where search_documents.page_id = pages.id)")
+}
+
+// SearchPublished searches the published pages and posts visitors can see, best matches first
+func SearchPublished(query string, page int, perPage int) (results []SearchResult, totalCount int64, err error) {
+	from := `from search_documents
+inner join pages on pages.id = search_documents.page_id and pages.deleted_at is null
+inner join content_revisions on content_revisions.id = pages.published_revision_id
+cross join websearch_to_tsquery(?::regconfig, ?) query
+where search_documents.vector @@ query and ` + DisplayDateColumn + ` <= ?`
+	now := time.Now()
+	countRes := database.Database.Raw("select count(*) "+from, searchConfig, query, now).Scan(&totalCount)
+	if countRes.Error != nil {
+		return nil, 0, countRes.Error
+	}
+	selectRes := database.Database.Raw(`select pages.id as page_id, pages.title, pages.path, pages.page_type, true as is_published,
+	ts_headline(?::regconfig, content_revisions.search_text, query, ?) as snippet
+`+from+`
+order by ts_rank_cd(search_documents.vector, query) desc, pages.id desc
+limit ? offset ?`, searchConfig, headlineOptions, searchConfig, query, now, perPage, perPage*page).Scan(&results)
+	if selectRes.Error != nil {
+		return nil, 0, selectRes.Error
+	}
+	for i := range results {
+		results[i].Snippet = highlight(results[i].Snippet)
+	}
+	return results, totalCount, nil
+}
+
+// SearchDrafts searches the current drafts of every page and post for the editor, whether published or not
+func SearchDrafts(query string, page int, perPage int) (results []SearchResult, totalCount int64, err error) {
+	from := `from pages
+inner join lateral (select content_revisions.search_text from content_revisions
+	where content_revisions.page_id = pages.id order by content_revisions.id desc limit 1) draft on true
+cross join lateral (select setweight(to_tsvector(?::regconfig, pages.title), 'A') ||
+	setweight(to_tsvector(?::regconfig, draft.search_text), 'B') as vector) document
+cross join websearch_to_tsquery(?::regconfig, ?) query
+where pages.deleted_at is null and document.vector @@ query`
+	countRes := database.Database.Raw("select count(*) "+from, searchConfig, searchConfig, searchConfig, query).Scan(&totalCount)
+	if countRes.Error != nil {
+		return nil, 0, countRes.Error
+	}
+	selectRes := database.Database.Raw(`select pages.id as page_id, pages.title, pages.path, pages.page_type,
+	pages.published_revision_id is not null as is_published,
+	ts_headline(?::regconfig, draft.search_text, query, ?) as snippet
+`+from+`
+order by ts_rank_cd(document.vector, query) desc, pages.updated_at desc
+limit ? offset ?`, searchConfig, headlineOptions, searchConfig, searchConfig, searchConfig, query, perPage, perPage*page).Scan(&results)
+	if selectRes.Error != nil {
+		return nil, 0, selectRes.Error
+	}
+	for i := range results {
+		results[i].Snippet = highlight(results[i].Snippet)
+	}
+	return results, totalCount, nil
+}
@@ -0,0 +1,64 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"testing"
+)
+
+func TestExtractText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"plain text", "Hello world", "Hello world"},
+		{"neighbouring elements", "<h1>Title</h1><p>First</p><p>Second</p>", "Title First Second"},
+		{"inline elements", "<p>Some <strong>bold</strong> words</p>", "Some bold words"},
+		{"line breaks", "one<br>two<br/>three", "one two three"},
+		{"whitespace", "  <p>\n\tspread   out\n</p>  ", "spread out"},
+		{"entities", "<p>Fish &amp; chips &lt;3</p>", "Fish & chips <3"},
+		{"script", "<p>Before</p><script>var secret = 1;</script><p>After</p>", "Before After"},
+		{"style", "<style>.hidden { display: none }</style><p>Shown</p>", "Shown"},
+		{"template", "<template><p>Not yet</p></template><p>Now</p>", "Now"},
+		{"nested skipped elements", "<template><style>a{}</style>hidden</template>shown", "shown"},
+		{"stray end tag", "</script><p>Still here</p>", "Still here"},
+		{"attributes", `<img alt="picture" src="/media/a.jpg"><a href="/about">About</a>`, "About"},
+		{"highlight markers", "a\x01b\x02c", "a b c"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		if got := ExtractText(test.html); got != test.want {
+			t.Errorf("%s: ExtractText() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"no matches", "no matches"},
+		{"a \x01match\x02 here", "a <mark>match</mark> here"},
+		{"\x01two\x02 and \x01three\x02", "<mark>two</mark> and <mark>three</mark>"},
+		{"<script>alert(1)</script> \x01x\x02", "&lt;script&gt;alert(1)&lt;/script&gt; <mark>x</mark>"},
+		{"Fish & \"chips\"", "Fish &amp; &#34;chips&#34;"},
+	}
+	for _, test := range tests {
+		if got := highlight(test.snippet); got != test.want {
+			t.Errorf("highlight(%q) = %q, want %q", test.snippet, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"github.com/pgray64/tinypress/database"
+	"gorm.io/gorm"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PageAlias is a former address of a page, kept so that published links keep working after a slug change
+type PageAlias struct {
+	ID        int       `gorm:"primaryKey;autoIncrement"`
+	PageId    int       `gorm:"not null;index:idx_page_aliases_page_id"`
+	Path      string    `gorm:"not null;size:2048;uniqueIndex:idx_page_aliases_path"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+const MaxSlugLength = 255
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+var slugSeparatorPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// reservedSlugs are top level paths used by the admin app and the server itself
+var reservedSlugs = map[string]bool{
+	"api":       true,
+	"static":    true,
+	"dashboard": true,
+	"setup":     true,
+	"sign-in":   true,
+	"pages":     true,
+	"admin":     true,
+	// Files shipped with the active theme, see route/public/theme.go
+	"theme-assets": true,
+	// Post index and archives, see route/public/blog.go
+	BlogPath: true,
+	// Category and tag archives, see route/public/taxonomy.go
+	"category": true,
+	"tag":      true,
+	// RSS and Atom feeds, see route/public/feed.go
+	"feed": true,
+	// Uploaded files, see route/public/media.go
+	"media": true,
+}
+
+func IsReservedSlug(slug string) bool {
+	return reservedSlugs[slug]
+}
+
+func IsValidSlug(slug string) bool {
+	return len(slug) <= MaxSlugLength && slugPattern.MatchString(slug) && !IsReservedSlug(slug)
+}
+
+// Slugify turns a title into a slug, which may still need to be made unique
+func Slugify(title string) string {
+	slug := strings.Trim(slugSeparatorPattern.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(slug) > MaxSlugLength-10 {
+		// Leave room for a numeric suffix
+		slug = strings.TrimRight(slug[:MaxSlugLength-10], "-")
+	}
+	if slug == "" || IsReservedSlug(slug) {
+		slug = strings.TrimLeft(slug+"-page", "-")
+	}
+	return slug
+}
+
+// uniqueSlug appends a numeric suffix to the slug until no other non-deleted page under the same parent uses it
+func uniqueSlug(tx *gorm.DB, parentPath string, slug string) (string, error) {
+	return firstFreeSlug(slug, func(candidate string) (bool, error) {
+		var count int64
+		countRes := tx.Model(&Page{}).
+			Where(map[string]interface{}{"path": joinPath(parentPath, candidate)}).
+			Count(&count)
+		return count > 0, countRes.Error
+	})
+}
+
+// firstFreeSlug tries the slug, then the slug with -2, -3 and so on, until one isn't taken
+func firstFreeSlug(slug string, isTaken func(candidate string) (bool, error)) (string, error) {
+	candidate := slug
+	for i := 2; ; i++ {
+		taken, err := isTaken(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = slug + "-" + strconv.Itoa(i)
+	}
+}
+
+func (page *Page) Permalink() string {
+	return "/" + page.Path
+}
+
+// ResolvePath finds the non-deleted page at a URL path, falling back to former addresses of pages
+func ResolvePath(path string) (page *Page, isAlias bool, err error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, false, nil
+	}
+	var pages []Page
+	selectRes := database.Database.Model(&Page{}).Where(map[string]interface{}{"path": path}).Find(&pages)
+	if selectRes.Error != nil {
+		return nil, false, selectRes.Error
+	}
+	if len(pages) > 0 {
+		return &pages[0], false, nil
+	}
+
+	var aliases []PageAlias
+	selectAliasRes := database.Database.Model(&PageAlias{}).Where(map[string]interface{}{"path": path}).Find(&aliases)
+	if selectAliasRes.Error != nil {
+		return nil, false, selectAliasRes.Error
+	}
+	if len(aliases) < 1 {
+		return nil, false, nil
+	}
+	selectRes = database.Database.Model(&Page{}).Where(map[string]interface{}{"id": aliases[0].PageId}).Find(&pages)
+	if selectRes.Error != nil || len(pages) < 1 {
+		return nil, false, selectRes.Error
+	}
+	return &pages[0], true, nil
+}
+
+// BackfillSlugs gives a slug and a top level path to pages created before slugs existed
+func BackfillSlugs() error {
+	var pages []Page
+	selectRes := database.Database.Model(&Page{}).Where("slug is null or slug = ''").Find(&pages)
+	if selectRes.Error != nil {
+		return selectRes.Error
+	}
+	for _, row := range pages {
+		slug, err := uniqueSlug(database.Database, "", Slugify(row.Title))
+		if err != nil {
+			return err
+		}
+		updateRes := database.Database.Model(&Page{}).
+			Where(map[string]interface{}{"id": row.ID}).
+			Updates(&Page{Slug: slug, Path: slug})
+		if updateRes.Error != nil {
+			return updateRes.Error
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Hello World", "hello-world"},
+		{"  Spaces   and -- dashes  ", "spaces-and-dashes"},
+		{"Ünïcode & Symbols!", "n-code-symbols"},
+		{"2024", "2024"},
+		{"404", "404"},
+		{"Top 10 Tips", "top-10-tips"},
+		{"", "page"},
+		{"!!!", "page"},
+		{"Admin", "admin-page"},
+		{"API", "api-page"},
+		{"media", "media-page"},
+		{"Dashboard stats", "dashboard-stats"},
+	}
+	for _, test := range tests {
+		if got := Slugify(test.title); got != test.want {
+			t.Errorf("Slugify(%q) = %q, want %q", test.title, got, test.want)
+		}
+	}
+}
+
+func TestSlugifyLeavesRoomForSuffix(t *testing.T) {
+	slug := Slugify(strings.Repeat("long title ", 50))
+	if len(slug) > MaxSlugLength-10 {
+		t.Errorf("slug is %d characters, want at most %d", len(slug), MaxSlugLength-10)
+	}
+	if strings.HasSuffix(slug, "-") {
+		t.Errorf("slug %q ends with a separator", slug)
+	}
+	if !IsValidSlug(slug) {
+		t.Errorf("slug %q is not valid", slug)
+	}
+}
+
+func TestIsValidSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{"about", true},
+		{"about-us", true},
+		{"2024", true},
+		{"a", true},
+		{"", false},
+		{"About", false},
+		{"about--us", false},
+		{"-about", false},
+		{"about-", false},
+		{"about/us", false},
+		{"admin", false},
+		{"static", false},
+		{"feed", false},
+		{"admin-page", true},
+		{strings.Repeat("a", MaxSlugLength), true},
+		{strings.Repeat("a", MaxSlugLength+1), false},
+	}
+	for _, test := range tests {
+		if got := IsValidSlug(test.slug); got != test.want {
+			t.Errorf("IsValidSlug(%q) = %v, want %v", test.slug, got, test.want)
+		}
+	}
+}
+
+func TestFirstFreeSlug(t *testing.T) {
+	tests := []struct {
+		slug  string
+		taken []string
+		want  string
+	}{
+		{"about", nil, "about"},
+		{"about", []string{"about"}, "about-2"},
+		{"about", []string{"about", "about-2", "about-3"}, "about-4"},
+		{"about", []string{"about-2"}, "about"},
+		{"2024", []string{"2024"}, "2024-2"},
+		{"post-2", []string{"post-2"}, "post-2-2"},
+	}
+	for _, test := range tests {
+		taken := make(map[string]bool)
+		for _, slug := range test.taken {
+			taken[slug] = true
+		}
+		got, err := firstFreeSlug(test.slug, func(candidate string) (bool, error) {
+			return taken[candidate], nil
+		})
+		if err != nil {
+			t.Fatalf("firstFreeSlug(%q) failed: %v", test.slug, err)
+		}
+		if got != test.want {
+			t.Errorf("firstFreeSlug(%q) with %v taken = %q, want %q", test.slug, test.taken, got, test.want)
+		}
+	}
+}
+
+func TestFirstFreeSlugError(t *testing.T) {
+	lookupErr := errors.New("lookup failed")
+	_, err := firstFreeSlug("about", func(candidate string) (bool, error) {
+		return false, lookupErr
+	})
+	if !errors.Is(err, lookupErr) {
+		t.Errorf("got error %v, want %v", err, lookupErr)
+	}
+}
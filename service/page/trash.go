@@ -0,0 +1,216 @@
+/*
+Package page is for services related to website pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package page
+
+import (
+	"errors"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/scheduleaction"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrNotInTrash = errors.New("page is not in the trash")
+	ErrPublished  = errors.New("page is published")
+)
+
+// Unpublish takes a page off the public site, leaving the current draft in place
+func Unpublish(pageId int) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		if err := cancelPendingSchedules(tx, pageId, scheduleaction.Unpublish); err != nil {
+			return err
+		}
+		return unpublish(tx, pageId)
+	})
+}
+
+// TrashPage soft-deletes a page, which takes it off the public site and frees up its path. Pages with subpages can't
+// be trashed, since the subpages would be left without a parent, and published pages can only be trashed by users
+// who could unpublish them.
+func TrashPage(pageId int, canUnpublish bool) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockHierarchy(tx, pageId); err != nil {
+			return err
+		}
+		if !canUnpublish {
+			// Locked so that the page can't be published before it is trashed
+			var pages []Page
+			selectRes := tx.Model(&Page{}).
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("id", "published_revision_id").
+				Where(map[string]interface{}{"id": pageId}).
+				Find(&pages)
+			if selectRes.Error != nil {
+				return selectRes.Error
+			}
+			if len(pages) > 0 && pages[0].PublishedRevisionId != nil {
+				return ErrPublished
+			}
+		}
+		childFound, err := hasChildren(tx, pageId)
+		if err != nil {
+			return err
+		}
+		if childFound {
+			return ErrHasChildren
+		}
+		for _, action := range []scheduleaction.ScheduleAction{scheduleaction.Publish, scheduleaction.Unpublish} {
+			if err := cancelPendingSchedules(tx, pageId, action); err != nil {
+				return err
+			}
+		}
+		return tx.Where(map[string]interface{}{"id": pageId}).Delete(&Page{}).Error
+	})
+}
+
+func ListTrash(page int, perPage int) (pages []Page, totalCount int64, err error) {
+	countRes := database.Database.Unscoped().Model(&Page{}).Where("deleted_at is not null").Count(&totalCount)
+	if countRes.Error != nil {
+		return pages, 0, countRes.Error
+	}
+	offset := perPage * page
+	selectRes := database.Database.Unscoped().Model(&Page{}).
+		Where("deleted_at is not null").
+		Offset(offset).
+		Limit(perPage).
+		Order("deleted_at desc").
+		Find(&pages)
+	return pages, totalCount, selectRes.Error
+}
+
+func getTrashedPage(tx *gorm.DB, pageId int) (*Page, error) {
+	var pages []Page
+	selectRes := tx.Unscoped().Model(&Page{}).
+		Where(map[string]interface{}{"id": pageId}).
+		Where("deleted_at is not null").
+		Find(&pages)
+	if selectRes.Error != nil || len(pages) < 1 {
+		return nil, selectRes.Error
+	}
+	return &pages[0], nil
+}
+
+// RestorePage brings a page back from the trash. If another page has taken its slug in the meantime, it gets a
+// numbered one instead, and if its parent is gone it is restored at the top level.
+func RestorePage(pageId int) (restoredPage *Page, err error) {
+	err = database.Database.Transaction(func(tx *gorm.DB) error {
+		// Held until the restored page has its path, so that no other page can take it in between
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+		trashedPage, err := getTrashedPage(tx, pageId)
+		if err != nil {
+			return err
+		}
+		if trashedPage == nil {
+			return ErrNotInTrash
+		}
+		parentPath, err := parentPathOf(tx, trashedPage.PageType, trashedPage.ParentId)
+		if errors.Is(err, ErrParentNotFound) {
+			trashedPage.ParentId = nil
+		} else if err != nil {
+			return err
+		}
+		slug := trashedPage.Slug
+		if slug == "" {
+			slug = Slugify(trashedPage.Title)
+		}
+		slug, err = uniqueSlug(tx, parentPath, slug)
+		if err != nil {
+			return err
+		}
+		path := joinPath(parentPath, slug)
+		if len(path) > MaxPathLength {
+			return ErrPathTooLong
+		}
+		updateRes := tx.Unscoped().Model(&Page{}).
+			Where(map[string]interface{}{"id": pageId}).
+			Updates(map[string]interface{}{
+				"deleted_at": nil,
+				"parent_id":  trashedPage.ParentId,
+				"slug":       slug,
+				"path":       path,
+				"updated_at": time.Now(),
+			})
+		if updateRes.Error != nil {
+			return updateRes.Error
+		}
+		trashedPage.Slug = slug
+		trashedPage.Path = path
+		restoredPage = trashedPage
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restoredPage, nil
+}
+
+// PurgePage permanently deletes a trashed page along with all of its revisions
+func PurgePage(pageId int) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		trashedPage, err := getTrashedPage(tx, pageId)
+		if err != nil {
+			return err
+		}
+		if trashedPage == nil {
+			return ErrNotInTrash
+		}
+		where := map[string]interface{}{"page_id": pageId}
+		if res := tx.Where(where).Delete(&PublishSchedule{}); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Where(where).Delete(&PageAlias{}); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Where(where).Delete(&ReviewEvent{}); res.Error != nil {
+			return res.Error
+		}
+		// taxonomy.PageTerm also cascades, but this package can't import it and the rows should go either way
+		if res := tx.Exec("delete from page_terms where page_id = ?", pageId); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Where(where).Delete(&ContentRevision{}); res.Error != nil {
+			return res.Error
+		}
+		// Only trashed pages can still be children of a trashed page, and they will be restored at the top level
+		if res := tx.Unscoped().Model(&Page{}).
+			Where(map[string]interface{}{"parent_id": pageId}).
+			Update("parent_id", nil); res.Error != nil {
+			return res.Error
+		}
+		return tx.Unscoped().Where(map[string]interface{}{"id": pageId}).Delete(&Page{}).Error
+	})
+}
+
+// EmptyTrash purges pages that were trashed before the cutoff
+func EmptyTrash(cutoff time.Time) error {
+	var pages []Page
+	selectRes := database.Database.Unscoped().Model(&Page{}).
+		Select("id").
+		Where("deleted_at < ?", cutoff).
+		Find(&pages)
+	if selectRes.Error != nil {
+		return selectRes.Error
+	}
+	for _, row := range pages {
+		// Another instance may have purged it first
+		if err := PurgePage(row.ID); err != nil && !errors.Is(err, ErrNotInTrash) {
+			return err
+		}
+	}
+	return nil
+}
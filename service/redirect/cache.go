@@ -0,0 +1,116 @@
+/*
+Package redirect is for services related to redirecting old URLs
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package redirect
+
+import (
+	"github.com/pgray64/tinypress/database"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// cacheTtl is how long other instances can take to pick up a redirect change. Changes made by this instance clear its
+// cache straight away.
+const cacheTtl = 30 * time.Second
+
+// cache holds every redirect by source path, since every public request is checked against them and most requests
+// match none. A redirect table is small enough to keep whole, which also covers paths that don't match.
+var cache = struct {
+	sync.RWMutex
+	bySource map[string]Redirect
+	loadedAt time.Time
+}{}
+
+// pendingHits counts redirect hits by redirect ID until FlushHits writes them, so that a crawler following old links
+// doesn't cost a write per request. Counts not yet flushed are lost if the server stops.
+var pendingHits = struct {
+	sync.Mutex
+	counts map[int]int64
+}{counts: make(map[int]int64)}
+
+func cachedRedirects() (map[string]Redirect, error) {
+	cache.RLock()
+	bySource, loadedAt := cache.bySource, cache.loadedAt
+	cache.RUnlock()
+	if bySource != nil && time.Since(loadedAt) < cacheTtl {
+		return bySource, nil
+	}
+
+	// Loading with the lock held means concurrent requests wait for one query rather than each running their own
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.bySource != nil && time.Since(cache.loadedAt) < cacheTtl {
+		return cache.bySource, nil
+	}
+	var redirects []Redirect
+	selectRes := database.Database.Model(&Redirect{}).
+		Select("id", "source_path", "target_path", "target_page_id", "status_code").
+		Find(&redirects)
+	if selectRes.Error != nil {
+		return nil, selectRes.Error
+	}
+	bySource = make(map[string]Redirect, len(redirects))
+	for _, row := range redirects {
+		bySource[row.SourcePath] = row
+	}
+	cache.bySource = bySource
+	cache.loadedAt = time.Now()
+	return bySource, nil
+}
+
+// ClearCache is for redirects changed in another package's transaction, see AddMoved
+func ClearCache() {
+	clearCache()
+}
+
+func clearCache() {
+	cache.Lock()
+	cache.bySource = nil
+	cache.Unlock()
+}
+
+// RecordHit counts a visit to a redirect, which is written to the database by the next FlushHits
+func RecordHit(redirectId int) {
+	pendingHits.Lock()
+	pendingHits.counts[redirectId]++
+	pendingHits.Unlock()
+}
+
+// FlushHits adds the hits recorded since the last flush to the hit counts of their redirects
+func FlushHits() error {
+	pendingHits.Lock()
+	counts := pendingHits.counts
+	pendingHits.counts = make(map[int]int64)
+	pendingHits.Unlock()
+	if len(counts) < 1 {
+		return nil
+	}
+
+	for redirectId, count := range counts {
+		updateRes := database.Database.Model(&Redirect{}).
+			Where(map[string]interface{}{"id": redirectId}).
+			UpdateColumn("hit_count", gorm.Expr("hit_count + ?", count))
+		if updateRes.Error != nil {
+			// Keep the hits not written yet for the next flush
+			pendingHits.Lock()
+			for unwrittenId, unwritten := range counts {
+				pendingHits.counts[unwrittenId] += unwritten
+			}
+			pendingHits.Unlock()
+			return updateRes.Error
+		}
+		delete(counts, redirectId)
+	}
+	return nil
+}
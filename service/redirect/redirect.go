@@ -0,0 +1,295 @@
+/*
+Package redirect is for services related to redirecting old URLs
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package redirect
+
+import (
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/sitemap"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Redirect struct {
+	ID         int    `gorm:"primaryKey;autoIncrement"`
+	SourcePath string `gorm:"not null;size:2048;uniqueIndex:idx_redirects_source_path"`
+	// TargetPath is either a path on this site or an absolute URL, and is empty when TargetPageId or a 410 is used
+	TargetPath   string    `gorm:"not null;size:2048"`
+	TargetPageId *int      `gorm:"index:idx_redirects_target_page_id"`
+	StatusCode   int       `gorm:"not null"`
+	HitCount     int64     `gorm:"not null;default:0"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+}
+
+var (
+	ErrInvalidTarget = errors.New("redirect target is invalid")
+	ErrLoop          = errors.New("redirect would create a loop")
+	ErrChain         = errors.New("redirect would create a chain")
+	ErrReservedPath  = errors.New("redirect source is reserved")
+)
+
+// NormalizePath gives paths a leading slash and no trailing slash, so that /about and /about/ are the same source
+func NormalizePath(path string) string {
+	path = "/" + strings.Trim(strings.TrimSpace(path), "/")
+	return path
+}
+
+// IsReservedSource reports whether a path belongs to the admin app, the server or its generated files, where a
+// redirect could lock everyone out of the admin app or break the site
+func IsReservedSource(path string) bool {
+	firstSegment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	return page.IsReservedSlug(firstSegment) || sitemap.IsGeneratedPath(path)
+}
+
+// isInternalPath accepts paths on this site, and rejects anything a browser could take as another host: //host, /\host,
+// which browsers treat the same, or either with tabs or newlines in between, which browsers strip
+func isInternalPath(path string) bool {
+	if !strings.HasPrefix(path, "/") || strings.Contains(path, "\\") {
+		return false
+	}
+	for _, char := range path {
+		if char < 0x20 || char == 0x7f {
+			return false
+		}
+	}
+	parsed, err := url.Parse(path)
+	return err == nil && parsed.Scheme == "" && parsed.Host == ""
+}
+
+func (redirect *Redirect) Create() (isDup bool, err error) {
+	if err = redirect.prepare(); err != nil {
+		return false, err
+	}
+	insertRes := database.Database.Create(redirect)
+	clearCache()
+	return checkDup(insertRes.Error)
+}
+
+func (redirect *Redirect) Update() (isDup bool, err error) {
+	if redirect.ID < 1 {
+		return false, errors.New("redirect id is invalid")
+	}
+	if err = redirect.prepare(); err != nil {
+		return false, err
+	}
+	updateRes := database.Database.Model(&Redirect{}).
+		Where(map[string]interface{}{"id": redirect.ID}).
+		Select("source_path", "target_path", "target_page_id", "status_code").
+		Updates(redirect)
+	clearCache()
+	return checkDup(updateRes.Error)
+}
+
+func checkDup(err error) (isDup bool, _ error) {
+	var pgErr *pgconn.PgError
+	if err != nil && errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return true, nil
+	}
+	return false, err
+}
+
+// prepare normalizes the redirect and rejects it if following it could lead to another redirect
+func (redirect *Redirect) prepare() error {
+	if err := redirect.normalize(); err != nil {
+		return err
+	}
+	if redirect.StatusCode == http.StatusGone {
+		return nil
+	}
+
+	// Work out where visitors will actually end up
+	var target string
+	if redirect.TargetPageId != nil {
+		targetPage, err := page.GetPage(*redirect.TargetPageId)
+		if err != nil {
+			return err
+		}
+		if targetPage == nil {
+			return ErrInvalidTarget
+		}
+		target = targetPage.Permalink()
+	} else if isInternalPath(redirect.TargetPath) {
+		target = redirect.TargetPath
+	} else {
+		// External targets can't lead back into our redirects
+		return redirect.checkIncoming()
+	}
+
+	var next []Redirect
+	selectRes := database.Database.Model(&Redirect{}).
+		Where(map[string]interface{}{"source_path": target}).
+		Not(map[string]interface{}{"id": redirect.ID}).
+		Find(&next)
+	if selectRes.Error != nil {
+		return selectRes.Error
+	}
+	var nextRedirect *Redirect
+	if len(next) > 0 {
+		nextRedirect = &next[0]
+	}
+	if err := checkNext(redirect.SourcePath, target, nextRedirect); err != nil {
+		return err
+	}
+	return redirect.checkIncoming()
+}
+
+// normalize tidies up the source and target, keeping only the target that applies. It rejects reserved sources,
+// status codes other than 301, 302 and 410, and targets that are neither a path on this site nor an absolute http or
+// https URL.
+func (redirect *Redirect) normalize() error {
+	redirect.SourcePath = NormalizePath(redirect.SourcePath)
+	redirect.TargetPath = strings.TrimSpace(redirect.TargetPath)
+	if IsReservedSource(redirect.SourcePath) {
+		return ErrReservedPath
+	}
+
+	switch redirect.StatusCode {
+	case http.StatusGone:
+		redirect.TargetPath = ""
+		redirect.TargetPageId = nil
+		return nil
+	case http.StatusMovedPermanently, http.StatusFound:
+	default:
+		return ErrInvalidTarget
+	}
+
+	if redirect.TargetPageId != nil {
+		redirect.TargetPath = ""
+	} else if isInternalPath(redirect.TargetPath) {
+		redirect.TargetPath = NormalizePath(redirect.TargetPath)
+	} else if !strings.HasPrefix(redirect.TargetPath, "http://") && !strings.HasPrefix(redirect.TargetPath, "https://") {
+		return ErrInvalidTarget
+	}
+	return nil
+}
+
+// checkNext rejects a redirect that leads back to its own source, either directly or through the redirect from its
+// target, if there is one, or that leads on to another redirect
+func checkNext(source string, target string, next *Redirect) error {
+	if target == source {
+		return ErrLoop
+	}
+	if next != nil {
+		if next.TargetPath == source {
+			return ErrLoop
+		}
+		return ErrChain
+	}
+	return nil
+}
+
+// checkIncoming ensures no other redirect already points at the source, since it would now be followed by this one
+func (redirect *Redirect) checkIncoming() error {
+	conditions := database.Database.Where(map[string]interface{}{"target_path": redirect.SourcePath})
+	shadowedPage, isAlias, err := page.ResolvePath(redirect.SourcePath)
+	if err != nil {
+		return err
+	}
+	if shadowedPage != nil && !isAlias {
+		conditions = conditions.Or(map[string]interface{}{"target_page_id": shadowedPage.ID})
+	}
+	var count int64
+	countRes := database.Database.Model(&Redirect{}).
+		Where(conditions).
+		Not(map[string]interface{}{"id": redirect.ID}).
+		Count(&count)
+	if countRes.Error != nil {
+		return countRes.Error
+	}
+	if count > 0 {
+		return ErrChain
+	}
+	return nil
+}
+
+// AddMoved sends visitors from a path that content has moved away from to where it lives now. Redirects from the new
+// path are dropped, since there is content there again, and ones leading to the old path are pointed at the new one so
+// that no chains are created. It runs in the caller's transaction, which should call ClearCache once committed.
+func AddMoved(tx *gorm.DB, oldPath string, newPath string) error {
+	oldPath = NormalizePath(oldPath)
+	newPath = NormalizePath(newPath)
+	deleteRes := tx.Where(map[string]interface{}{"source_path": []string{oldPath, newPath}}).Delete(&Redirect{})
+	if deleteRes.Error != nil {
+		return deleteRes.Error
+	}
+	updateRes := tx.Model(&Redirect{}).
+		Where(map[string]interface{}{"target_path": oldPath}).
+		Update("target_path", newPath)
+	if updateRes.Error != nil {
+		return updateRes.Error
+	}
+	insertRes := tx.Create(&Redirect{
+		SourcePath: oldPath,
+		TargetPath: newPath,
+		StatusCode: http.StatusMovedPermanently,
+	})
+	return insertRes.Error
+}
+
+// DeleteBySourcePath drops the redirect from a path that content now lives at, which would otherwise hide it. It runs
+// in the caller's transaction, which should call ClearCache once committed.
+func DeleteBySourcePath(tx *gorm.DB, path string) error {
+	deleteRes := tx.Where(map[string]interface{}{"source_path": NormalizePath(path)}).Delete(&Redirect{})
+	return deleteRes.Error
+}
+
+// FindBySourcePath returns the redirect for a request path, or nil if there is none. It reads from the cache, see
+// cache.go, so only the fields needed to follow the redirect are set.
+func FindBySourcePath(path string) (*Redirect, error) {
+	bySource, err := cachedRedirects()
+	if err != nil {
+		return nil, err
+	}
+	match, ok := bySource[NormalizePath(path)]
+	if !ok {
+		return nil, nil
+	}
+	return &match, nil
+}
+
+func GetRedirect(redirectId int) (*Redirect, error) {
+	var redirects []Redirect
+	selectRes := database.Database.Model(&Redirect{}).Where(map[string]interface{}{"id": redirectId}).Find(&redirects)
+	if selectRes.Error != nil || len(redirects) < 1 {
+		return nil, selectRes.Error
+	}
+	return &redirects[0], nil
+}
+
+func ListRedirects(page int, perPage int) (redirects []Redirect, totalCount int64, err error) {
+	countRes := database.Database.Model(&Redirect{}).Count(&totalCount)
+	if countRes.Error != nil {
+		return redirects, 0, countRes.Error
+	}
+	offset := perPage * page
+	selectRes := database.Database.Model(&Redirect{}).
+		Offset(offset).
+		Limit(perPage).
+		Order("source_path asc").
+		Find(&redirects)
+	return redirects, totalCount, selectRes.Error
+}
+
+func DeleteRedirect(redirectId int) error {
+	deleteRes := database.Database.Where(map[string]interface{}{"id": redirectId}).Delete(&Redirect{})
+	clearCache()
+	return deleteRes.Error
+}
@@ -0,0 +1,204 @@
+/*
+Package redirect is for services related to redirecting old URLs
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package redirect
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestIsInternalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/about", true},
+		{"/about/team?tab=1#top", true},
+		{"/a//b", true},
+		{"", false},
+		{"about", false},
+		{"//evil.com", false},
+		{"//evil.com/path", false},
+		{"/\\evil.com", false},
+		{"\\\\evil.com", false},
+		{"/\\/evil.com", false},
+		{"/\t/evil.com", false},
+		{"/\n/evil.com", false},
+		{"/\r/evil.com", false},
+		{"/\x7f", false},
+		{"https://evil.com", false},
+		{"javascript:alert(1)", false},
+	}
+	for _, test := range tests {
+		if got := isInternalPath(test.path); got != test.want {
+			t.Errorf("isInternalPath(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"about", "/about"},
+		{"/about/", "/about"},
+		{"  /about/team/  ", "/about/team"},
+		{"///about///", "/about"},
+	}
+	for _, test := range tests {
+		if got := NormalizePath(test.path); got != test.want {
+			t.Errorf("NormalizePath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestIsReservedSource(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/dashboard", true},
+		{"/dashboard/pages", true},
+		{"/api/authed/v1/account/check-session", true},
+		{"/sign-in", true},
+		{"/media/photo.jpg", true},
+		{"/static/js/main.js", true},
+		{"/sitemap.xml", true},
+		{"/sitemap-2.xml", true},
+		{"/robots.txt", true},
+		{"/", false},
+		{"/about", false},
+		{"/dashboard-old", false},
+		{"/about/dashboard", false},
+		{"/sitemap", false},
+	}
+	for _, test := range tests {
+		if got := IsReservedSource(test.path); got != test.want {
+			t.Errorf("IsReservedSource(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	pageId := 3
+	tests := []struct {
+		name       string
+		redirect   Redirect
+		wantErr    error
+		wantSource string
+		wantTarget string
+		wantPageId bool
+	}{
+		{
+			name:       "internal target",
+			redirect:   Redirect{SourcePath: "old/", TargetPath: " /new/ ", StatusCode: http.StatusMovedPermanently},
+			wantSource: "/old",
+			wantTarget: "/new",
+		},
+		{
+			name:       "external target",
+			redirect:   Redirect{SourcePath: "/old", TargetPath: "https://example.com/new", StatusCode: http.StatusFound},
+			wantSource: "/old",
+			wantTarget: "https://example.com/new",
+		},
+		{
+			name:       "page target drops path",
+			redirect:   Redirect{SourcePath: "/old", TargetPath: "/ignored", TargetPageId: &pageId, StatusCode: http.StatusMovedPermanently},
+			wantSource: "/old",
+			wantPageId: true,
+		},
+		{
+			name:       "gone drops targets",
+			redirect:   Redirect{SourcePath: "/old", TargetPath: "/new", TargetPageId: &pageId, StatusCode: http.StatusGone},
+			wantSource: "/old",
+		},
+		{
+			name:     "unsupported status",
+			redirect: Redirect{SourcePath: "/old", TargetPath: "/new", StatusCode: http.StatusTemporaryRedirect},
+			wantErr:  ErrInvalidTarget,
+		},
+		{
+			name:     "protocol relative target",
+			redirect: Redirect{SourcePath: "/old", TargetPath: "//evil.com", StatusCode: http.StatusMovedPermanently},
+			wantErr:  ErrInvalidTarget,
+		},
+		{
+			name:     "backslash target",
+			redirect: Redirect{SourcePath: "/old", TargetPath: "/\\evil.com", StatusCode: http.StatusMovedPermanently},
+			wantErr:  ErrInvalidTarget,
+		},
+		{
+			name:     "other scheme",
+			redirect: Redirect{SourcePath: "/old", TargetPath: "javascript:alert(1)", StatusCode: http.StatusMovedPermanently},
+			wantErr:  ErrInvalidTarget,
+		},
+		{
+			name:     "reserved source",
+			redirect: Redirect{SourcePath: "dashboard/", TargetPath: "/new", StatusCode: http.StatusMovedPermanently},
+			wantErr:  ErrReservedPath,
+		},
+		{
+			name:     "relative target",
+			redirect: Redirect{SourcePath: "/old", TargetPath: "new", StatusCode: http.StatusMovedPermanently},
+			wantErr:  ErrInvalidTarget,
+		},
+	}
+	for _, test := range tests {
+		redirect := test.redirect
+		err := redirect.normalize()
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if redirect.SourcePath != test.wantSource {
+			t.Errorf("%s: got source %q, want %q", test.name, redirect.SourcePath, test.wantSource)
+		}
+		if redirect.TargetPath != test.wantTarget {
+			t.Errorf("%s: got target %q, want %q", test.name, redirect.TargetPath, test.wantTarget)
+		}
+		if (redirect.TargetPageId != nil) != test.wantPageId {
+			t.Errorf("%s: got target page %v, want one set: %v", test.name, redirect.TargetPageId, test.wantPageId)
+		}
+	}
+}
+
+func TestCheckNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		target  string
+		next    *Redirect
+		wantErr error
+	}{
+		{"no next redirect", "/a", "/b", nil, nil},
+		{"points at itself", "/a", "/a", nil, ErrLoop},
+		{"two step loop", "/a", "/b", &Redirect{SourcePath: "/b", TargetPath: "/a"}, ErrLoop},
+		{"chain", "/a", "/b", &Redirect{SourcePath: "/b", TargetPath: "/c"}, ErrChain},
+		{"chain to a page", "/a", "/b", &Redirect{SourcePath: "/b"}, ErrChain},
+		{"chain to a 410", "/a", "/b", &Redirect{SourcePath: "/b", StatusCode: http.StatusGone}, ErrChain},
+	}
+	for _, test := range tests {
+		if err := checkNext(test.source, test.target, test.next); !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.wantErr)
+		}
+	}
+}
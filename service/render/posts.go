@@ -0,0 +1,105 @@
+/*
+Package render is for services related to rendering the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package render
+
+import (
+	"bytes"
+	"github.com/pgray64/tinypress/service/page"
+	"html/template"
+	"time"
+)
+
+// BlogTitle is the title of the blog index, and where posts lead back to in their breadcrumbs
+const BlogTitle = "Blog"
+
+// PostSummary is a post as listed on the blog index and archives
+type PostSummary struct {
+	Title         string
+	Url           string
+	Date          *time.Time
+	Author        string
+	Excerpt       string
+	FeaturedImage string
+}
+
+// PostList is one page of a post listing, with links to the neighbouring pages if there are any
+type PostList struct {
+	Heading  string
+	Posts    []PostSummary
+	NewerUrl string
+	OlderUrl string
+}
+
+const postListHtml = `<section class="tp-post-list">
+<h1>{{.Heading}}</h1>
+{{- range .Posts}}
+<article class="tp-post-summary">
+{{- if .FeaturedImage}}
+<a href="{{.Url}}"><img class="tp-post-image" src="{{.FeaturedImage}}" alt=""></a>
+{{- end}}
+<h2><a href="{{.Url}}">{{.Title}}</a></h2>
+<p class="tp-post-meta">
+{{- with .Date}}<time datetime="{{.Format "2006-01-02"}}">{{.Format "January 2, 2006"}}</time>{{end}}
+{{- if .Author}} by {{.Author}}{{end}}</p>
+{{- if .Excerpt}}
+<p>{{.Excerpt}}</p>
+{{- end}}
+</article>
+{{- else}}
+<p>Nothing has been published here yet.</p>
+{{- end}}
+{{- if or .NewerUrl .OlderUrl}}
+<nav class="tp-post-pagination">
+{{- if .NewerUrl}}
+<a rel="prev" href="{{.NewerUrl}}">Newer posts</a>
+{{- end}}
+{{- if .OlderUrl}}
+<a rel="next" href="{{.OlderUrl}}">Older posts</a>
+{{- end}}
+</nav>
+{{- end}}
+</section>`
+
+var postListTemplate = template.Must(template.New("post-list").Parse(postListHtml))
+
+// NewPostSummary lists a published post, with authors mapped from user IDs to display names
+func NewPostSummary(post *page.Page, authors map[int]string) PostSummary {
+	summary := PostSummary{
+		Title:         post.Title,
+		Url:           post.Permalink(),
+		Date:          post.DisplayDate(),
+		Excerpt:       post.Excerpt,
+		FeaturedImage: post.FeaturedImage,
+	}
+	if post.AuthorId != nil {
+		summary.Author = authors[*post.AuthorId]
+	}
+	return summary
+}
+
+// NewPostListView builds a view of a post listing, so that it is wrapped in the default layout of the theme like any
+// other page
+func NewPostListView(siteName string, title string, path string, list PostList) (PageView, error) {
+	var buf bytes.Buffer
+	if err := postListTemplate.Execute(&buf, list); err != nil {
+		return PageView{}, err
+	}
+	return PageView{
+		SiteName:     siteName,
+		Title:        title,
+		Html:         template.HTML(buf.String()),
+		CanonicalUrl: AbsoluteUrl(path),
+		OgTitle:      title,
+	}, nil
+}
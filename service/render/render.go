@@ -0,0 +1,189 @@
+/*
+Package render is for services related to rendering the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package render
+
+import (
+	"bytes"
+	"github.com/pgray64/tinypress/conf"
+	"github.com/pgray64/tinypress/enum/pagetype"
+	"github.com/pgray64/tinypress/service/menu"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/theme"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// PageView is everything needed to render a published page as a full HTML document
+type PageView struct {
+	SiteName      string
+	Title         string
+	Html          template.HTML
+	Css           template.CSS
+	Description   string
+	CanonicalUrl  string
+	OgTitle       string
+	OgDescription string
+	OgImage       string
+	NoIndex       bool
+	// Breadcrumbs lead from the top level down to the parent of the page
+	Breadcrumbs []Breadcrumb
+	// Menus are the resolved navigation menus of the site by name
+	Menus map[string][]menu.Link
+	// Layout is the layout of the active theme that the page is rendered with
+	Layout string
+	// IsPost is set for blog posts, which also have a date, an author and maybe a featured image
+	IsPost        bool
+	PostDate      *time.Time
+	Author        string
+	FeaturedImage string
+}
+
+type Breadcrumb struct {
+	Title string
+	Url   string
+}
+
+const errorLayout = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Heading}} - {{.SiteName}}</title>
+</head>
+<body>
+<h1>{{.Heading}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`
+
+var errorTemplate = template.Must(template.New("error").Parse(errorLayout))
+
+// errorContentHtml is the content of an error page rendered with the theme, which brings its own document around it
+const errorContentHtml = `<section class="tp-error">
+<h1>{{.Heading}}</h1>
+<p>{{.Message}}</p>
+</section>`
+
+var errorContentTemplate = template.Must(template.New("error-content").Parse(errorContentHtml))
+
+type errorView struct {
+	SiteName string
+	Heading  string
+	Message  string
+}
+
+// NewPageView builds a view from a page, its published revision and its published ancestors. RenderedHtml and
+// RenderedCss come from the editor and are trusted.
+func NewPageView(siteName string, publishedPage *page.Page, revision *page.ContentRevision, ancestors []page.Page) PageView {
+	view := PageView{
+		SiteName:      siteName,
+		Title:         publishedPage.Title,
+		Html:          template.HTML(revision.RenderedHtml),
+		Css:           template.CSS(revision.RenderedCss),
+		Description:   publishedPage.MetaDescription,
+		CanonicalUrl:  publishedPage.CanonicalUrl,
+		OgTitle:       publishedPage.OgTitle,
+		OgDescription: publishedPage.OgDescription,
+		OgImage:       publishedPage.OgImage,
+		NoIndex:       publishedPage.NoIndex,
+		Layout:        publishedPage.Layout,
+		IsPost:        publishedPage.PageType == pagetype.Post,
+		FeaturedImage: publishedPage.FeaturedImage,
+	}
+	if view.IsPost {
+		view.PostDate = publishedPage.DisplayDate()
+		// Posts have no parent pages, but they do all live under the blog
+		view.Breadcrumbs = append(view.Breadcrumbs, Breadcrumb{
+			Title: BlogTitle,
+			Url:   "/" + page.BlogPath,
+		})
+	}
+	for _, ancestor := range ancestors {
+		view.Breadcrumbs = append(view.Breadcrumbs, Breadcrumb{
+			Title: ancestor.Title,
+			Url:   ancestor.Permalink(),
+		})
+	}
+	// Fall back to the regular fields for anything not set specifically for sharing
+	if view.CanonicalUrl == "" {
+		view.CanonicalUrl = AbsoluteUrl(publishedPage.Permalink())
+	}
+	if view.OgTitle == "" {
+		view.OgTitle = view.Title
+	}
+	if view.OgDescription == "" {
+		view.OgDescription = view.Description
+	}
+	if view.OgImage == "" {
+		view.OgImage = view.FeaturedImage
+	}
+	return view
+}
+
+// AbsoluteUrl prefixes a path with the configured site URL, or returns an empty string if there is none
+func AbsoluteUrl(path string) string {
+	if conf.Secrets.SiteUrl == "" {
+		return ""
+	}
+	return strings.TrimRight(conf.Secrets.SiteUrl, "/") + path
+}
+
+// RenderPage wraps the page in its layout from the theme
+func RenderPage(w io.Writer, siteTheme *theme.Theme, view PageView) error {
+	return siteTheme.Layout(view.Layout).Execute(w, view)
+}
+
+// RenderNotFound renders the 404 page. The view only needs the site name and menus, the rest is filled in here.
+func RenderNotFound(w io.Writer, siteTheme *theme.Theme, view PageView) error {
+	return renderError(w, siteTheme, view, errorView{
+		SiteName: view.SiteName,
+		Heading:  "Page not found",
+		Message:  "The page you are looking for does not exist.",
+	})
+}
+
+// RenderGone renders the 410 page for removed pages, in the same way as RenderNotFound
+func RenderGone(w io.Writer, siteTheme *theme.Theme, view PageView) error {
+	return renderError(w, siteTheme, view, errorView{
+		SiteName: view.SiteName,
+		Heading:  "Page removed",
+		Message:  "The page you are looking for has been permanently removed.",
+	})
+}
+
+// renderError wraps an error message in the default layout of the theme, so that visitors keep the header and menus
+// of the site. If there is no theme or it fails to render, the bare error page is written instead.
+func renderError(w io.Writer, siteTheme *theme.Theme, view PageView, errView errorView) error {
+	if siteTheme != nil {
+		var content bytes.Buffer
+		if err := errorContentTemplate.Execute(&content, errView); err != nil {
+			return err
+		}
+		view.Title = errView.Heading
+		view.OgTitle = errView.Heading
+		view.Html = template.HTML(content.String())
+		view.NoIndex = true
+		view.Layout = theme.DefaultLayout
+		// Render to a buffer first, so that a layout failing halfway doesn't leave half a page before the fallback
+		var buf bytes.Buffer
+		if err := RenderPage(&buf, siteTheme, view); err == nil {
+			_, err = buf.WriteTo(w)
+			return err
+		}
+	}
+	return errorTemplate.Execute(w, errView)
+}
@@ -16,6 +16,8 @@ package settings
 import (
 	"errors"
 	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/imageformat"
+	"github.com/pgray64/tinypress/enum/storagebackend"
 )
 
 type Settings struct {
@@ -26,6 +28,25 @@ type Settings struct {
 	SmtpPassword       string `gorm:"not null;size:255"`
 	SmtpPort           string `gorm:"not null;size:16"`
 	ImageDirectoryPath string `gorm:"not null;size:255"`
+	ActiveTheme        string `gorm:"not null;size:100;default:'default'"`
+	// StorageBackend picks where media files go, either ImageDirectoryPath or the S3 bucket
+	StorageBackend storagebackend.StorageBackend `gorm:"not null;default:1"`
+	S3Endpoint     string                        `gorm:"not null;size:255;default:''"`
+	S3Region       string                        `gorm:"not null;size:100;default:''"`
+	S3Bucket       string                        `gorm:"not null;size:255;default:''"`
+	S3AccessKey    string                        `gorm:"not null;size:255;default:''"`
+	S3SecretKey    string                        `gorm:"not null;size:255;default:''"`
+	S3UseSsl       bool                          `gorm:"not null;default:false"`
+	// RobotsTxt holds extra rules for robots.txt, and DiscourageSearchEngines asks crawlers to stay away entirely
+	RobotsTxt               string `gorm:"not null;default:''"`
+	DiscourageSearchEngines bool   `gorm:"not null;default:false"`
+	// ImageSizes are the widths uploaded images are resized to, separated by commas
+	ImageSizes   string                  `gorm:"not null;size:255;default:'480,960,1600'"`
+	ImageFormat  imageformat.ImageFormat `gorm:"not null;default:1"`
+	ImageQuality int                     `gorm:"not null;default:82"`
+	// MaxUploadKb applies to every upload, and UploadTypeLimits sets lower limits for some types as type=kb pairs
+	MaxUploadKb      int    `gorm:"not null;default:10240"`
+	UploadTypeLimits string `gorm:"not null;size:255;default:'image/gif=5120,image/svg+xml=512'"`
 }
 
 func (settings *Settings) Create() error {
@@ -55,13 +76,67 @@ func UpdateGeneralSettings(settings *Settings) error {
 	if !settings.Active {
 		return errors.New("inserting an inactive setting entry is now allowed")
 	}
-	insertRes := database.Database.Where(map[string]interface{}{"active": true}).Updates(&Settings{
-		SiteName:           settings.SiteName,
-		ImageDirectoryPath: settings.ImageDirectoryPath,
-	})
+	// Select lets the storage fields be cleared when switching backends, which Updates would otherwise skip
+	insertRes := database.Database.Model(&Settings{}).
+		Where(map[string]interface{}{"active": true}).
+		Select("site_name", "image_directory_path", "storage_backend", "s3_endpoint", "s3_region", "s3_bucket",
+			"s3_access_key", "s3_secret_key", "s3_use_ssl").
+		Updates(&Settings{
+			SiteName:           settings.SiteName,
+			ImageDirectoryPath: settings.ImageDirectoryPath,
+			StorageBackend:     settings.StorageBackend,
+			S3Endpoint:         settings.S3Endpoint,
+			S3Region:           settings.S3Region,
+			S3Bucket:           settings.S3Bucket,
+			S3AccessKey:        settings.S3AccessKey,
+			S3SecretKey:        settings.S3SecretKey,
+			S3UseSsl:           settings.S3UseSsl,
+		})
 	return insertRes.Error
 }
 
+// UpdateStorageBackend switches where media files are kept, once they have been copied over
+func UpdateStorageBackend(backend storagebackend.StorageBackend) error {
+	updateRes := database.Database.Model(&Settings{}).
+		Where(map[string]interface{}{"active": true}).
+		Update("storage_backend", backend)
+	return updateRes.Error
+}
+
+func UpdateActiveTheme(name string) error {
+	updateRes := database.Database.Model(&Settings{}).
+		Where(map[string]interface{}{"active": true}).
+		Update("active_theme", name)
+	return updateRes.Error
+}
+
+func UpdateSeoSettings(settings *Settings) error {
+	// Select lets the rules be cleared and the flag be turned off, which Updates would otherwise skip as zero values
+	updateRes := database.Database.Model(&Settings{}).
+		Where(map[string]interface{}{"active": true}).
+		Select("robots_txt", "discourage_search_engines").
+		Updates(&Settings{
+			RobotsTxt:               settings.RobotsTxt,
+			DiscourageSearchEngines: settings.DiscourageSearchEngines,
+		})
+	return updateRes.Error
+}
+
+func UpdateMediaSettings(settings *Settings) error {
+	// Select lets the sizes and type limits be cleared, which turns off resizing and the limits by type
+	updateRes := database.Database.Model(&Settings{}).
+		Where(map[string]interface{}{"active": true}).
+		Select("image_sizes", "image_format", "image_quality", "max_upload_kb", "upload_type_limits").
+		Updates(&Settings{
+			ImageSizes:       settings.ImageSizes,
+			ImageFormat:      settings.ImageFormat,
+			ImageQuality:     settings.ImageQuality,
+			MaxUploadKb:      settings.MaxUploadKb,
+			UploadTypeLimits: settings.UploadTypeLimits,
+		})
+	return updateRes.Error
+}
+
 func UpdateSmtpSettings(settings *Settings) error {
 	if !settings.Active {
 		return errors.New("inserting an inactive setting entry is now allowed")
@@ -0,0 +1,123 @@
+/*
+Package sitemap is for services related to the XML sitemap of the public website
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package sitemap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/service/page"
+	"gorm.io/gorm"
+	"io"
+	"strings"
+	"time"
+)
+
+// MaxUrls is the most a single sitemap file may list, past which the sitemap is split up behind a sitemap index
+const MaxUrls = 50000
+
+// IsGeneratedPath reports whether a path is the robots.txt or one of the sitemap files, see route/public/sitemap.go
+func IsGeneratedPath(path string) bool {
+	return path == "/robots.txt" || path == "/sitemap.xml" || strings.HasPrefix(path, "/sitemap-")
+}
+
+// Entry is a published page, with the date its published revision was made
+type Entry struct {
+	Path         string
+	LastModified time.Time
+}
+
+// listed is every published page and post that search engines are allowed to index
+func listed() *gorm.DB {
+	return database.Database.Model(&page.Page{}).
+		Joins("inner join content_revisions on content_revisions.id = pages.published_revision_id").
+		Where(map[string]interface{}{"pages.no_index": false}).
+		Where(page.DisplayDateColumn+" <= ?", time.Now())
+}
+
+func CountEntries() (count int64, err error) {
+	countRes := listed().Count(&count)
+	return count, countRes.Error
+}
+
+// PartCount is how many sitemap files the entries are split into, which is at least one even with no entries
+func PartCount(entryCount int64) int {
+	if entryCount <= MaxUrls {
+		return 1
+	}
+	return int((entryCount + MaxUrls - 1) / MaxUrls)
+}
+
+// ListEntries returns the entries of one sitemap file, counting from 0
+func ListEntries(part int) (entries []Entry, err error) {
+	selectRes := listed().
+		Select("pages.path as path", "content_revisions.created_at as last_modified").
+		Order("pages.id asc").
+		Offset(part * MaxUrls).
+		Limit(MaxUrls).
+		Scan(&entries)
+	return entries, selectRes.Error
+}
+
+type urlSet struct {
+	XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	Urls    []url    `xml:"url"`
+}
+type url struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// WriteUrlSet writes a sitemap file listing the entries, with links made absolute using baseUrl
+func WriteUrlSet(w io.Writer, baseUrl string, entries []Entry) error {
+	set := urlSet{Urls: make([]url, len(entries))}
+	for i, entry := range entries {
+		set.Urls[i] = url{
+			Loc:     baseUrl + "/" + entry.Path,
+			LastMod: entry.LastModified.UTC().Format(time.RFC3339),
+		}
+	}
+	return writeDocument(w, set)
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapRef `xml:"sitemap"`
+}
+type sitemapRef struct {
+	Loc string `xml:"loc"`
+}
+
+// PartPath is where a sitemap file is served from, counting from 0
+func PartPath(part int) string {
+	return fmt.Sprintf("/sitemap-%d.xml", part+1)
+}
+
+// WriteIndex writes a sitemap index pointing at each of the sitemap files
+func WriteIndex(w io.Writer, baseUrl string, partCount int) error {
+	index := sitemapIndex{Sitemaps: make([]sitemapRef, partCount)}
+	for i := range index.Sitemaps {
+		index.Sitemaps[i] = sitemapRef{Loc: baseUrl + PartPath(i)}
+	}
+	return writeDocument(w, index)
+}
+
+func writeDocument(w io.Writer, document interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(document)
+}
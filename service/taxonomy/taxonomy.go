@@ -0,0 +1,324 @@
+/*
+Package taxonomy is for services related to the categories and tags of pages
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package taxonomy
+
+import (
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/pgray64/tinypress/database"
+	"github.com/pgray64/tinypress/enum/termtype"
+	"github.com/pgray64/tinypress/service/page"
+	"github.com/pgray64/tinypress/service/redirect"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// MaxPathLength keeps archive paths short enough to be the source of a redirect when a term is renamed
+const MaxPathLength = 240
+
+// Archive paths by term type, see route/public/taxonomy.go
+const (
+	CategoryPath = "category"
+	TagPath      = "tag"
+)
+
+// Term is a category or a tag. Categories can be nested, in which case Path is the slugs of the parent categories and
+// the term joined by slashes.
+type Term struct {
+	ID        int               `gorm:"primaryKey;autoIncrement"`
+	TermType  termtype.TermType `gorm:"not null;uniqueIndex:idx_terms_term_type_path,priority:1"`
+	Name      string            `gorm:"not null;size:255"`
+	Slug      string            `gorm:"not null;size:255"`
+	Path      string            `gorm:"not null;size:255;uniqueIndex:idx_terms_term_type_path,priority:2"`
+	ParentId  *int              `gorm:"index:idx_terms_parent_id"`
+	CreatedAt time.Time         `gorm:"autoCreateTime"`
+	UpdatedAt time.Time         `gorm:"autoUpdateTime"`
+}
+
+// PageTerm assigns a term to a page or post. Assignments go away along with either of them.
+type PageTerm struct {
+	PageId int       `gorm:"primaryKey"`
+	Page   page.Page `gorm:"PRELOAD:false;constraint:OnDelete:CASCADE"`
+	TermId int       `gorm:"primaryKey;index:idx_page_terms_term_id"`
+	Term   Term      `gorm:"PRELOAD:false;constraint:OnDelete:CASCADE"`
+}
+
+var (
+	ErrTermNotFound  = errors.New("term does not exist")
+	ErrInvalidSlug   = errors.New("term slug is invalid")
+	ErrInvalidParent = errors.New("term parent is invalid")
+	ErrPathTooLong   = errors.New("term path is too long")
+	ErrHasChildren   = errors.New("term has child categories")
+)
+
+// Permalink is the address of the archive listing everything with the term
+func (term *Term) Permalink() string {
+	return archivePath(term.TermType, term.Path)
+}
+
+func archivePath(termType termtype.TermType, path string) string {
+	if termType == termtype.Category {
+		return "/" + CategoryPath + "/" + path
+	}
+	return "/" + TagPath + "/" + path
+}
+
+func IsValidTermType(termType termtype.TermType) bool {
+	return termType == termtype.Category || termType == termtype.Tag
+}
+
+func (term *Term) Create() (isDup bool, err error) {
+	if !IsValidTermType(term.TermType) {
+		return false, errors.New("term type is invalid")
+	}
+	err = database.Database.Transaction(func(tx *gorm.DB) error {
+		if err := term.preparePath(tx); err != nil {
+			return err
+		}
+		if err := tx.Create(term).Error; err != nil {
+			return err
+		}
+		// A redirect left behind by a renamed term would hide this one's archive
+		return redirect.DeleteBySourcePath(tx, term.Permalink())
+	})
+	redirect.ClearCache()
+	return checkDup(err)
+}
+
+// UpdateTerm renames or moves a term. When that changes the path of the term, and of any categories under it, their
+// old archive addresses are redirected to the new ones, and redirects from the new ones dropped.
+func UpdateTerm(updated *Term) (isDup bool, err error) {
+	defer redirect.ClearCache()
+	err = database.Database.Transaction(func(tx *gorm.DB) error {
+		existing, err := getTerm(tx, updated.ID)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return ErrTermNotFound
+		}
+		updated.TermType = existing.TermType
+		if err = updated.preparePath(tx); err != nil {
+			return err
+		}
+		if strings.HasPrefix(updated.Path, existing.Path+"/") {
+			// Moving a category under one of its children
+			return ErrInvalidParent
+		}
+		updateRes := tx.Model(&Term{}).
+			Where(map[string]interface{}{"id": updated.ID}).
+			Select("name", "slug", "path", "parent_id", "updated_at").
+			Updates(updated)
+		if updateRes.Error != nil {
+			return updateRes.Error
+		}
+		if updated.Path == existing.Path {
+			return nil
+		}
+		if err = redirect.AddMoved(tx, archivePath(existing.TermType, existing.Path), updated.Permalink()); err != nil {
+			return err
+		}
+
+		var descendants []Term
+		selectRes := tx.Model(&Term{}).
+			Where(map[string]interface{}{"term_type": existing.TermType}).
+			Where("path like ?", existing.Path+"/%").
+			Find(&descendants)
+		if selectRes.Error != nil {
+			return selectRes.Error
+		}
+		for _, descendant := range descendants {
+			newPath := updated.Path + strings.TrimPrefix(descendant.Path, existing.Path)
+			if len(newPath) > MaxPathLength {
+				return ErrPathTooLong
+			}
+			updateRes = tx.Model(&Term{}).
+				Where(map[string]interface{}{"id": descendant.ID}).
+				Update("path", newPath)
+			if updateRes.Error != nil {
+				return updateRes.Error
+			}
+			err = redirect.AddMoved(tx, archivePath(existing.TermType, descendant.Path), archivePath(existing.TermType, newPath))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return checkDup(err)
+}
+
+// preparePath fills in the slug if it is missing and works out the path of the term from its parent
+func (term *Term) preparePath(tx *gorm.DB) error {
+	term.Name = strings.TrimSpace(term.Name)
+	if term.Slug == "" {
+		term.Slug = page.Slugify(term.Name)
+	}
+	if term.Slug == "" || len(term.Slug) > page.MaxSlugLength {
+		return ErrInvalidSlug
+	}
+	term.Path = term.Slug
+	if term.ParentId != nil {
+		if term.TermType != termtype.Category || *term.ParentId == term.ID {
+			return ErrInvalidParent
+		}
+		parent, err := getTerm(tx, *term.ParentId)
+		if err != nil {
+			return err
+		}
+		if parent == nil || parent.TermType != termtype.Category {
+			return ErrInvalidParent
+		}
+		term.Path = parent.Path + "/" + term.Slug
+	}
+	if len(term.Path) > MaxPathLength {
+		return ErrPathTooLong
+	}
+	return nil
+}
+
+func checkDup(err error) (isDup bool, _ error) {
+	var pgErr *pgconn.PgError
+	if err != nil && errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return true, nil
+	}
+	return false, err
+}
+
+func getTerm(tx *gorm.DB, termId int) (*Term, error) {
+	var terms []Term
+	selectRes := tx.Model(&Term{}).Where(map[string]interface{}{"id": termId}).Find(&terms)
+	if selectRes.Error != nil || len(terms) < 1 {
+		return nil, selectRes.Error
+	}
+	return &terms[0], nil
+}
+
+func GetTerm(termId int) (*Term, error) {
+	return getTerm(database.Database, termId)
+}
+
+// ListTerms returns the terms of a type ordered by path, so that categories come right before their children
+func ListTerms(termType termtype.TermType) (terms []Term, err error) {
+	selectRes := database.Database.Model(&Term{}).
+		Where(map[string]interface{}{"term_type": termType}).
+		Order("path asc").
+		Find(&terms)
+	return terms, selectRes.Error
+}
+
+// GetAncestors returns the categories a category is nested under, starting from the top level
+func GetAncestors(term *Term) (ancestors []Term, err error) {
+	segments := strings.Split(term.Path, "/")
+	var paths []string
+	for i := 1; i < len(segments); i++ {
+		paths = append(paths, strings.Join(segments[:i], "/"))
+	}
+	if len(paths) < 1 {
+		return nil, nil
+	}
+	selectRes := database.Database.Model(&Term{}).
+		Where(map[string]interface{}{"term_type": term.TermType, "path": paths}).
+		Order("path asc").
+		Find(&ancestors)
+	return ancestors, selectRes.Error
+}
+
+// DeleteTerm removes a term and its assignments. Categories with children have to be emptied first.
+func DeleteTerm(termId int) error {
+	var childCount int64
+	countRes := database.Database.Model(&Term{}).Where(map[string]interface{}{"parent_id": termId}).Count(&childCount)
+	if countRes.Error != nil {
+		return countRes.Error
+	}
+	if childCount > 0 {
+		return ErrHasChildren
+	}
+	deleteRes := database.Database.Where(map[string]interface{}{"id": termId}).Delete(&Term{})
+	return deleteRes.Error
+}
+
+// SetPageTerms replaces the terms assigned to a page
+func SetPageTerms(pageId int, termIds []int) error {
+	uniqueIds := make(map[int]bool)
+	for _, termId := range termIds {
+		uniqueIds[termId] = true
+	}
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		countRes := tx.Model(&Term{}).Where(map[string]interface{}{"id": termIds}).Count(&count)
+		if countRes.Error != nil {
+			return countRes.Error
+		}
+		if count != int64(len(uniqueIds)) {
+			return ErrTermNotFound
+		}
+		deleteRes := tx.Where(map[string]interface{}{"page_id": pageId}).Delete(&PageTerm{})
+		if deleteRes.Error != nil {
+			return deleteRes.Error
+		}
+		for termId := range uniqueIds {
+			if insertRes := tx.Create(&PageTerm{PageId: pageId, TermId: termId}); insertRes.Error != nil {
+				return insertRes.Error
+			}
+		}
+		return nil
+	})
+}
+
+// ListPageTerms returns the terms assigned to a page, categories first
+func ListPageTerms(pageId int) (terms []Term, err error) {
+	selectRes := database.Database.Model(&Term{}).
+		Joins("inner join page_terms on page_terms.term_id = terms.id").
+		Where(map[string]interface{}{"page_terms.page_id": pageId}).
+		Order("terms.term_type asc, terms.path asc").
+		Find(&terms)
+	return terms, selectRes.Error
+}
+
+// ResolveArchive finds the term at an archive path, or returns nil if there is none
+func ResolveArchive(termType termtype.TermType, path string) (*Term, error) {
+	var terms []Term
+	selectRes := database.Database.Model(&Term{}).
+		Where(map[string]interface{}{"term_type": termType, "path": strings.Trim(path, "/")}).
+		Find(&terms)
+	if selectRes.Error != nil || len(terms) < 1 {
+		return nil, selectRes.Error
+	}
+	return &terms[0], nil
+}
+
+// ListPublished returns the published pages and posts with a term newest first. Category archives also include
+// everything in the categories under them.
+func ListPublished(term *Term, pageNumber int, perPage int) (pages []page.Page, totalCount int64, err error) {
+	query := database.Database.Model(&page.Page{}).
+		Where("published_revision_id is not null").
+		Where(page.DisplayDateColumn+" <= ?", time.Now()).
+		Where("pages.id in (select page_terms.page_id from page_terms inner join terms on terms.id = page_terms.term_id "+
+			"where terms.term_type = ? and (terms.path = ? or terms.path like ?))", term.TermType, term.Path, term.Path+"/%")
+	countRes := query.Session(&gorm.Session{}).Count(&totalCount)
+	if countRes.Error != nil {
+		return pages, 0, countRes.Error
+	}
+	offset := perPage * pageNumber
+	selectRes := query.
+		Order(page.DisplayDateColumn + " desc").
+		Order("id desc").
+		Offset(offset).
+		Limit(perPage).
+		Find(&pages)
+	return pages, totalCount, selectRes.Error
+}
@@ -0,0 +1,96 @@
+/*
+Package theme is for services related to public website themes
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package theme
+
+// builtinTemplates are the named slots every layout can use. "head" is the title, SEO tags and page styles, "content"
+// is the page body from the editor, "menu" renders a menu from .Menus, "breadcrumbs" links to the page's parents and
+// "post-header" shows the date, author and featured image of a blog post.
+const builtinTemplates = `{{define "head"}}<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - {{.SiteName}}</title>
+{{- if .Description}}
+<meta name="description" content="{{.Description}}">
+{{- end}}
+{{- if .NoIndex}}
+<meta name="robots" content="noindex">
+{{- end}}
+{{- if .CanonicalUrl}}
+<link rel="canonical" href="{{.CanonicalUrl}}">
+<meta property="og:url" content="{{.CanonicalUrl}}">
+{{- end}}
+<meta property="og:type" content="{{if .IsPost}}article{{else}}website{{end}}">
+<meta property="og:site_name" content="{{.SiteName}}">
+<meta property="og:title" content="{{.OgTitle}}">
+{{- if .OgDescription}}
+<meta property="og:description" content="{{.OgDescription}}">
+{{- end}}
+{{- if .OgImage}}
+<meta property="og:image" content="{{.OgImage}}">
+{{- end}}
+{{- with .PostDate}}
+<meta property="article:published_time" content="{{.Format "2006-01-02T15:04:05Z07:00"}}">
+{{- end}}
+<link rel="alternate" type="application/rss+xml" title="{{.SiteName}}" href="/feed/rss">
+<link rel="alternate" type="application/atom+xml" title="{{.SiteName}}" href="/feed/atom">
+<style>{{.Css}}</style>
+{{- end}}
+{{- define "content"}}{{.Html}}{{end}}
+{{- define "menu"}}<ul>
+{{- range .}}
+<li><a href="{{.Url}}">{{.Label}}</a>{{if .Children}}{{template "menu" .Children}}{{end}}</li>
+{{- end}}
+</ul>{{end}}
+{{- define "breadcrumbs"}}
+{{- if .Breadcrumbs}}
+<nav aria-label="Breadcrumb">
+<ol>
+{{- range .Breadcrumbs}}
+<li><a href="{{.Url}}">{{.Title}}</a></li>
+{{- end}}
+<li aria-current="page">{{.Title}}</li>
+</ol>
+</nav>
+{{- end}}
+{{- end}}
+{{- define "post-header"}}
+{{- if .IsPost}}
+<header class="tp-post-header">
+{{- if .FeaturedImage}}
+<img class="tp-post-image" src="{{.FeaturedImage}}" alt="">
+{{- end}}
+<p class="tp-post-meta">
+{{- with .PostDate}}<time datetime="{{.Format "2006-01-02"}}">{{.Format "January 2, 2006"}}</time>{{end}}
+{{- if .Author}} by {{.Author}}{{end}}</p>
+</header>
+{{- end}}
+{{- end}}`
+
+const builtinLayout = `<!DOCTYPE html>
+<html lang="en">
+<head>
+{{template "head" .}}
+</head>
+<body>
+{{- with index .Menus "header"}}
+<nav class="tp-menu-header">{{template "menu" .}}</nav>
+{{- end}}
+{{- template "breadcrumbs" .}}
+{{- template "post-header" .}}
+{{template "content" .}}
+{{- with index .Menus "footer"}}
+<footer><nav class="tp-menu-footer">{{template "menu" .}}</nav></footer>
+{{- end}}
+</body>
+</html>
+`
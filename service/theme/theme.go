@@ -0,0 +1,235 @@
+/*
+Package theme is for services related to public website themes
+
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+package theme
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pgray64/tinypress/conf"
+	"github.com/pgray64/tinypress/service/settings"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BuiltinTheme is compiled into Tinypress, so there is always something to render pages with
+const BuiltinTheme = "default"
+
+// DefaultLayout is used for pages without a layout, or whose layout the active theme doesn't have
+const DefaultLayout = "default"
+
+// Theme is a parsed set of layouts. Each layout is a complete HTML document that pulls in the page through the
+// "head", "content", "menu" and "breadcrumbs" templates, which every theme gets on top of its own partials.
+type Theme struct {
+	Name    string
+	Layouts map[string]*template.Template
+}
+
+var ErrThemeNotFound = errors.New("theme does not exist")
+
+// LoadError is a problem with the files of a theme, such as a template that doesn't parse
+type LoadError struct {
+	Theme string
+	Err   error
+}
+
+func (err *LoadError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *LoadError) Unwrap() error {
+	return err.Err
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9]+(?:[-_][a-z0-9]+)*$`)
+
+var cache = struct {
+	sync.RWMutex
+	themes map[string]*Theme
+}{themes: make(map[string]*Theme)}
+
+func themesDirectory() string {
+	if conf.Secrets.ThemesDirectory != "" {
+		return conf.Secrets.ThemesDirectory
+	}
+	return conf.DefaultThemesDirectory
+}
+
+// AssetsDirectory is where a theme keeps the fonts, stylesheets and images its layouts link to
+func AssetsDirectory(name string) string {
+	return filepath.Join(themesDirectory(), name, "assets")
+}
+
+// ListThemes returns the names of the built-in theme and every theme in the themes directory
+func ListThemes() ([]string, error) {
+	names := []string{BuiltinTheme}
+	entries, err := os.ReadDir(themesDirectory())
+	if errors.Is(err, os.ErrNotExist) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != BuiltinTheme && namePattern.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Load parses every layout of a theme. Themes live in <themes directory>/<name>, with layouts in layouts/*.html and
+// templates shared between layouts in partials/*.html. A theme needs at least a default layout.
+func Load(name string) (*Theme, error) {
+	if name == BuiltinTheme {
+		return loadBuiltin()
+	}
+	if !namePattern.MatchString(name) {
+		return nil, ErrThemeNotFound
+	}
+	themeDirectory := filepath.Join(themesDirectory(), name)
+	if info, err := os.Stat(themeDirectory); err != nil || !info.IsDir() {
+		return nil, ErrThemeNotFound
+	}
+	loaded, err := parseTheme(name, themeDirectory)
+	if err != nil {
+		return nil, &LoadError{Theme: name, Err: err}
+	}
+	return loaded, nil
+}
+
+func parseTheme(name string, themeDirectory string) (*Theme, error) {
+	base, err := template.New("base").Parse(builtinTemplates)
+	if err != nil {
+		return nil, err
+	}
+	partials, err := filepath.Glob(filepath.Join(themeDirectory, "partials", "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	if len(partials) > 0 {
+		if base, err = base.ParseFiles(partials...); err != nil {
+			return nil, err
+		}
+	}
+
+	layoutFiles, err := filepath.Glob(filepath.Join(themeDirectory, "layouts", "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	loaded := &Theme{Name: name, Layouts: make(map[string]*template.Template)}
+	for _, layoutFile := range layoutFiles {
+		layoutName := strings.TrimSuffix(filepath.Base(layoutFile), ".html")
+		if !namePattern.MatchString(layoutName) {
+			continue
+		}
+		contents, err := os.ReadFile(layoutFile)
+		if err != nil {
+			return nil, err
+		}
+		layout, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = layout.New(layoutName).Parse(string(contents)); err != nil {
+			return nil, err
+		}
+		loaded.Layouts[layoutName] = layout.Lookup(layoutName)
+	}
+	if loaded.Layouts[DefaultLayout] == nil {
+		return nil, fmt.Errorf("layouts/%s.html is missing", DefaultLayout)
+	}
+	return loaded, nil
+}
+
+func loadBuiltin() (*Theme, error) {
+	base, err := template.New("base").Parse(builtinTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = base.New(DefaultLayout).Parse(builtinLayout); err != nil {
+		return nil, err
+	}
+	return &Theme{
+		Name:    BuiltinTheme,
+		Layouts: map[string]*template.Template{DefaultLayout: base.Lookup(DefaultLayout)},
+	}, nil
+}
+
+// LayoutNames lists the layouts a page can pick from
+func (theme *Theme) LayoutNames() []string {
+	names := make([]string, 0, len(theme.Layouts))
+	for name := range theme.Layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Layout returns the named layout, falling back to the default one
+func (theme *Theme) Layout(name string) *template.Template {
+	if layout, ok := theme.Layouts[name]; ok {
+		return layout
+	}
+	return theme.Layouts[DefaultLayout]
+}
+
+// Activate checks that a theme parses before making it the theme of the site, so that broken templates are reported
+// to the admin rather than to site visitors
+func Activate(name string) error {
+	loaded, err := Load(name)
+	if err != nil {
+		return err
+	}
+	if err = settings.UpdateActiveTheme(name); err != nil {
+		return err
+	}
+	cache.Lock()
+	cache.themes[name] = loaded
+	cache.Unlock()
+	return nil
+}
+
+// Get returns a parsed theme for rendering. Themes are parsed once per process, and if a theme can no longer be
+// parsed, for example because its files were changed after activation, the built-in theme is used instead.
+func Get(name string) *Theme {
+	cache.RLock()
+	loaded, ok := cache.themes[name]
+	cache.RUnlock()
+	if ok {
+		return loaded
+	}
+	loaded, err := Load(name)
+	if err != nil {
+		loaded = builtin
+	}
+	cache.Lock()
+	cache.themes[name] = loaded
+	cache.Unlock()
+	return loaded
+}
+
+var builtin = mustLoadBuiltin()
+
+func mustLoadBuiltin() *Theme {
+	loaded, err := loadBuiltin()
+	if err != nil {
+		panic(err)
+	}
+	return loaded
+}
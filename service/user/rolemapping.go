@@ -34,10 +34,24 @@ func GetFeaturesForRole(role userrole.UserRole) []productfeature.ProductFeature
 		return []productfeature.ProductFeature{
 			productfeature.ManageUsers,
 			productfeature.ManageSettings,
+			productfeature.ManageRedirects,
+			productfeature.ManageMenus,
+			productfeature.ManageTaxonomy,
+			productfeature.BreakEditLocks,
 		}
 	case userrole.Editor:
 		return []productfeature.ProductFeature{
 			productfeature.AddEditContent,
+			productfeature.ManageTaxonomy,
+		}
+	case userrole.Reviewer:
+		return []productfeature.ProductFeature{
+			productfeature.ReviewContent,
+		}
+	case userrole.Publisher:
+		return []productfeature.ProductFeature{
+			productfeature.ReviewContent,
+			productfeature.PublishContent,
 		}
 	case userrole.User:
 		return []productfeature.ProductFeature{}
@@ -45,6 +59,33 @@ func GetFeaturesForRole(role userrole.UserRole) []productfeature.ProductFeature
 		return []productfeature.ProductFeature{}
 	}
 }
+
+// BackfillReviewRoles gives admins the Reviewer and Publisher roles on installs set up before content review existed,
+// since nobody could approve or publish otherwise. Editors are left out so that they can't publish their own work. Installs where anyone has either role are left
+// alone, so roles taken away later are not handed back.
+func BackfillReviewRoles() error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		countRes := tx.Model(&RoleMapping{}).
+			Where(map[string]interface{}{"user_role": []userrole.UserRole{userrole.Reviewer, userrole.Publisher}}).
+			Count(&count)
+		if countRes.Error != nil || count > 0 {
+			return countRes.Error
+		}
+		for _, role := range []userrole.UserRole{userrole.Reviewer, userrole.Publisher} {
+			insertRes := tx.Exec(`insert into role_mappings (user_id, user_role)
+select distinct role_mappings.user_id, ? from role_mappings
+inner join users on users.id = role_mappings.user_id and users.deleted_at is null
+where role_mappings.user_role = ?
+on conflict do nothing`, role, userrole.Admin)
+			if insertRes.Error != nil {
+				return insertRes.Error
+			}
+		}
+		return nil
+	})
+}
+
 func IsRemovingLastAdmin(userID int, updatedRoles []userrole.UserRole) (bool, error) {
 	if userID < 1 {
 		return false, errors.New("invalid user")
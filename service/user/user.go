@@ -112,3 +112,24 @@ func CreateUserSession(userId int, c echo.Context) error {
 	err = sess.Save(c.Request(), c.Response())
 	return err
 }
+
+// GetDisplayNames maps user IDs to display names, including users that have since been deleted
+func GetDisplayNames(userIds []int) (map[int]string, error) {
+	var users []User
+	names := make(map[int]string)
+	if len(userIds) < 1 {
+		return names, nil
+	}
+	selectRes := database.Database.Unscoped().
+		Model(&User{}).
+		Select("id", "display_name").
+		Where(map[string]interface{}{"id": userIds}).
+		Find(&users)
+	if selectRes.Error != nil {
+		return names, selectRes.Error
+	}
+	for _, row := range users {
+		names[row.ID] = row.DisplayName
+	}
+	return names, nil
+}
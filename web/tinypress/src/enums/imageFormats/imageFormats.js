@@ -0,0 +1,33 @@
+/*
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+const ImageFormats = Object.freeze({
+  Original: 1,
+  Jpeg: 2,
+  Png: 3,
+  Webp: 4,
+  getDescription(imageFormat) {
+    switch (imageFormat) {
+      case ImageFormats.Original:
+        return "Same as uploaded";
+      case ImageFormats.Jpeg:
+        return "JPEG";
+      case ImageFormats.Png:
+        return "PNG";
+      case ImageFormats.Webp:
+        return "WebP (lossless)";
+      default:
+        throw new Error("Invalid image format");
+    }
+  },
+});
+export default ImageFormats;
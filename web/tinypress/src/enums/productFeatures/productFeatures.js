@@ -14,5 +14,11 @@ const ProductFeatures = Object.freeze({
   ManageUsers: 1,
   ManageSettings: 2,
   AddEditContent: 3,
+  ManageRedirects: 4,
+  BreakEditLocks: 5,
+  ReviewContent: 6,
+  PublishContent: 7,
+  ManageMenus: 8,
+  ManageTaxonomy: 9,
 });
 export default ProductFeatures;
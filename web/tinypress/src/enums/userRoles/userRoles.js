@@ -14,6 +14,8 @@ const UserRoles = Object.freeze({
   Admin: 1,
   Editor: 2,
   User: 3,
+  Reviewer: 4,
+  Publisher: 5,
   getDescription(role) {
     switch (role) {
       case UserRoles.Admin:
@@ -22,6 +24,10 @@ const UserRoles = Object.freeze({
         return "Editor";
       case UserRoles.User:
         return "User";
+      case UserRoles.Reviewer:
+        return "Reviewer";
+      case UserRoles.Publisher:
+        return "Publisher";
       default:
         throw new Error("Invalid role");
     }
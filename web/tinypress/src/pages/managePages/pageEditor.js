@@ -28,12 +28,29 @@ import {
   getPageWithDraft,
   saveDraft,
 } from "../../services/api/editor/editPage";
-import { Alert, CircularProgress, TextField } from "@mui/material";
+import {
+  listMedia,
+  uploadMedia,
+} from "../../services/api/editor/mediaLibrary";
+import {
+  Alert,
+  CircularProgress,
+  Dialog,
+  DialogActions,
+  DialogContent,
+  DialogContentText,
+  DialogTitle,
+  TextField,
+} from "@mui/material";
 import Button from "@mui/material/Button";
 import SaveIcon from "@mui/icons-material/Save";
 
 const theme = createTheme();
 
+// Saves are turned away while someone else holds the edit lock on the page
+const lockedMessage = (lock) =>
+  `${lock?.lockedBy || "Someone else"} has this page open in the editor.`;
+
 export default function PageEditor() {
   const [serverError, setServerError] = React.useState(null);
   const [successMessage, setSuccessMessage] = React.useState(null);
@@ -41,6 +58,10 @@ export default function PageEditor() {
   const [saving, setSaving] = React.useState(false);
   const [pageTitle, setPageTitle] = React.useState("");
   const [editor, setEditor] = React.useState(null);
+  // Set when someone else saved the page since this editing session started, with the edits that could not be saved
+  const [conflict, setConflict] = React.useState(null);
+  // Draft this editing session is based on, so saves can detect edits made by someone else
+  const baseRevisionId = React.useRef(0);
   const { id } = useParams();
   const closeErrorSnackbar = () => {
     setServerError(null);
@@ -60,6 +81,7 @@ export default function PageEditor() {
           ? JSON.parse(res.data.editorContent)
           : undefined;
         setPageTitle(res.data.pageTitle);
+        baseRevisionId.current = res.data.draftId;
 
         const newEditor = GrapesJs.init({
           container: "#grapes-js-editor",
@@ -113,6 +135,23 @@ export default function PageEditor() {
             },
           },
           styleManager: {},
+          assetManager: {
+            uploadFile: (e) => {
+              const files = e.dataTransfer
+                ? e.dataTransfer.files
+                : e.target.files;
+              uploadMedia(files).then(
+                (res) => {
+                  newEditor.AssetManager.add(res.data.data);
+                },
+                (err) => {
+                  setServerError(
+                    err.response?.data?.message ?? "An error occurred."
+                  );
+                }
+              );
+            },
+          },
           commands: {
             defaults: [
               // ...
@@ -125,6 +164,9 @@ export default function PageEditor() {
             ],
           },
         });
+        listMedia({ page: 0 }).then((res) => {
+          newEditor.AssetManager.add(res.data.mediaList);
+        });
         setEditor(newEditor);
         // Hacky fix for duplicate StyleManager entries
         newEditor.StyleManager.getSectors().models =
@@ -144,15 +186,26 @@ export default function PageEditor() {
             return saveDraft({
               pageId,
               editorContent: JSON.stringify(data),
+              baseRevisionId: baseRevisionId.current,
             }).then(
-              () => {
+              (saveRes) => {
+                baseRevisionId.current = saveRes.data.draftId;
                 setSaving(false);
                 setSuccessMessage("Draft saved!");
               },
               (err) => {
-                setServerError(
-                  err.response?.data?.message ?? "An error occurred."
-                );
+                if (err.response?.data?.latestDraft) {
+                  setConflict({
+                    latestDraft: err.response.data.latestDraft,
+                    editorContent: JSON.stringify(data),
+                  });
+                } else if (err.response?.status === 409) {
+                  setServerError(lockedMessage(err.response.data));
+                } else {
+                  setServerError(
+                    err.response?.data?.message ?? "An error occurred."
+                  );
+                }
                 setSaving(false);
               }
             );
@@ -172,6 +225,39 @@ export default function PageEditor() {
   const handleSaveDraftClick = () => {
     editor.runCommand("store-data");
   };
+  const handleCloseConflictDialog = () => {
+    setConflict(null);
+  };
+  // Saves these edits as the latest draft, replacing what the other person saved
+  const handleOverwriteClick = () => {
+    setSaving(true);
+    saveDraft({
+      pageId: parseInt(id),
+      editorContent: conflict.editorContent,
+      force: true,
+    }).then(
+      (saveRes) => {
+        baseRevisionId.current = saveRes.data.draftId;
+        setConflict(null);
+        setSaving(false);
+        setSuccessMessage("Draft saved!");
+      },
+      (err) => {
+        setServerError(
+          err.response?.status === 409
+            ? lockedMessage(err.response.data)
+            : err.response?.data?.message ?? "An error occurred."
+        );
+        setSaving(false);
+      }
+    );
+  };
+  // Drops these edits and carries on from what the other person saved
+  const handleLoadLatestClick = () => {
+    editor.loadProjectData(JSON.parse(conflict.latestDraft.editorContent));
+    baseRevisionId.current = conflict.latestDraft.draftId;
+    setConflict(null);
+  };
 
   return (
     <ThemeProvider theme={theme}>
@@ -197,6 +283,40 @@ export default function PageEditor() {
           open={successMessage}
           onClose={closeSuccessSnackbar}
         />
+        <Dialog
+          open={!!conflict}
+          onClose={handleCloseConflictDialog}
+          aria-labelledby="conflict-dialog-title"
+          aria-describedby="conflict-dialog-description"
+        >
+          <DialogTitle id="conflict-dialog-title">
+            This page was changed by someone else
+          </DialogTitle>
+          <DialogContent>
+            <DialogContentText id="conflict-dialog-description">
+              {conflict?.latestDraft.createdBy || "Someone"} saved this page
+              at{" "}
+              {conflict
+                ? new Date(conflict.latestDraft.createdAt).toLocaleString()
+                : ""}{" "}
+              while you were editing it. Overwrite their changes with yours, or
+              load their version and lose your unsaved changes.
+            </DialogContentText>
+          </DialogContent>
+          <DialogActions>
+            <Button onClick={handleCloseConflictDialog}>Cancel</Button>
+            <Button onClick={handleLoadLatestClick} disabled={saving}>
+              Load their version
+            </Button>
+            <Button
+              onClick={handleOverwriteClick}
+              disabled={saving}
+              color="error"
+            >
+              Overwrite anyway
+            </Button>
+          </DialogActions>
+        </Dialog>
 
         {initializing ? (
           <Box
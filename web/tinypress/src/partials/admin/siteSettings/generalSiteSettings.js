@@ -69,6 +69,13 @@ export default function GeneralSiteSettings({ settingsData, onSave }) {
     updateGeneralSettings({
       siteName: data.get("siteName"),
       imageDirectoryPath: data.get("imageDirectoryPath"),
+      // Keep the storage backend as it is, the secret key included
+      storageBackend: settingsData.storageBackend,
+      s3Endpoint: settingsData.s3Endpoint,
+      s3Region: settingsData.s3Region,
+      s3Bucket: settingsData.s3Bucket,
+      s3AccessKey: settingsData.s3AccessKey,
+      s3UseSsl: settingsData.s3UseSsl,
     }).then(
       () => {
         setSuccessMessage("General settings updated.");
@@ -40,6 +40,14 @@ export default function UserRoleMultiselect({
       name: UserRoles.getDescription(UserRoles.User),
       value: UserRoles.User,
     },
+    {
+      name: UserRoles.getDescription(UserRoles.Reviewer),
+      value: UserRoles.Reviewer,
+    },
+    {
+      name: UserRoles.getDescription(UserRoles.Publisher),
+      value: UserRoles.Publisher,
+    },
   ];
 
   function getStyles(name, personName, theme) {
@@ -0,0 +1,53 @@
+/*
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import api from "../api";
+
+const baseUrl = "/api/authed/v1/admin/redirects/";
+
+export function listRedirects({ page }) {
+  return api.post(baseUrl + "list-redirects", { page });
+}
+export function getRedirect({ id }) {
+  return api.post(baseUrl + "get-redirect", { id });
+}
+export function addRedirect({
+  sourcePath,
+  targetPath,
+  targetPageId,
+  statusCode,
+}) {
+  return api.post(baseUrl + "add-redirect", {
+    sourcePath,
+    targetPath,
+    targetPageId,
+    statusCode,
+  });
+}
+export function updateRedirect({
+  id,
+  sourcePath,
+  targetPath,
+  targetPageId,
+  statusCode,
+}) {
+  return api.post(baseUrl + "update-redirect", {
+    id,
+    sourcePath,
+    targetPath,
+    targetPageId,
+    statusCode,
+  });
+}
+export function deleteRedirect({ id }) {
+  return api.post(baseUrl + "delete-redirect", { id });
+}
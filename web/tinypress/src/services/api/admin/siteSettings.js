@@ -17,10 +17,28 @@ const baseUrl = "/api/authed/v1/admin/site-settings/";
 export function getSiteSettings() {
   return api.get(baseUrl + "get-site-settings");
 }
-export function updateGeneralSettings({ siteName, imageDirectoryPath }) {
+// Leaving s3SecretKey empty keeps the current one, since it is never sent back
+export function updateGeneralSettings({
+  siteName,
+  imageDirectoryPath,
+  storageBackend,
+  s3Endpoint,
+  s3Region,
+  s3Bucket,
+  s3AccessKey,
+  s3SecretKey,
+  s3UseSsl,
+}) {
   return api.post(baseUrl + "update-general-settings", {
     siteName,
     imageDirectoryPath,
+    storageBackend,
+    s3Endpoint,
+    s3Region,
+    s3Bucket,
+    s3AccessKey,
+    s3SecretKey,
+    s3UseSsl,
   });
 }
 export function updateSmtpSettings({
@@ -36,3 +54,25 @@ export function updateSmtpSettings({
     smtpPort,
   });
 }
+export function updateSeoSettings({ robotsTxt, discourageSearchEngines }) {
+  return api.post(baseUrl + "update-seo-settings", {
+    robotsTxt,
+    discourageSearchEngines,
+  });
+}
+// maxUploadKb and uploadTypeLimits are in kilobytes, the latter as "image/gif=5120,image/svg+xml=512"
+export function updateMediaSettings({
+  imageSizes,
+  imageFormat,
+  imageQuality,
+  maxUploadKb,
+  uploadTypeLimits,
+}) {
+  return api.post(baseUrl + "update-media-settings", {
+    imageSizes,
+    imageFormat,
+    imageQuality,
+    maxUploadKb,
+    uploadTypeLimits,
+  });
+}
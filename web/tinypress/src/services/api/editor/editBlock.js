@@ -0,0 +1,81 @@
+/*
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import api from "../api";
+
+const baseUrl = "/api/authed/v1/block-editor/";
+
+export function createBlock({
+  name,
+  handle,
+  renderedHtml,
+  renderedCss,
+  editorContent,
+}) {
+  return api.post(baseUrl + "create", {
+    name,
+    handle,
+    renderedHtml,
+    renderedCss,
+    editorContent,
+  });
+}
+
+export function getBlockWithDraft({ blockId }) {
+  return api.post(baseUrl + "get-block-with-draft", { blockId });
+}
+
+export function listBlocks({ page }) {
+  return api.post(baseUrl + "list-blocks", { page });
+}
+
+export function saveBlockDraft({
+  blockId,
+  renderedHtml,
+  renderedCss,
+  editorContent,
+}) {
+  return api.post(baseUrl + "save-draft", {
+    blockId,
+    renderedHtml,
+    renderedCss,
+    editorContent,
+  });
+}
+
+export function publishBlockDraft({ draftId }) {
+  return api.post(baseUrl + "publish-draft", { draftId });
+}
+
+export function renameBlock({ blockId, name }) {
+  return api.post(baseUrl + "rename", { blockId, name });
+}
+
+export function deleteBlock({ blockId }) {
+  return api.post(baseUrl + "delete", { blockId });
+}
+
+export function listBlockRevisions({ blockId, page }) {
+  return api.post(baseUrl + "list-revisions", { blockId, page });
+}
+
+export function getBlockRevision({ revisionId }) {
+  return api.post(baseUrl + "get-revision", { revisionId });
+}
+
+export function restoreBlockRevision({ revisionId }) {
+  return api.post(baseUrl + "restore-revision", { revisionId });
+}
+
+export function getBlockUsage({ blockId }) {
+  return api.post(baseUrl + "where-used", { blockId });
+}
@@ -24,12 +24,14 @@ export function createPageWithDefaults() {
 }
 export function createPage({
   title,
+  parentId,
   renderedHtml,
   renderedCss,
   editorContent,
 }) {
   return api.post(baseUrl + "create", {
     title,
+    parentId,
     renderedHtml,
     renderedCss,
     editorContent,
@@ -47,12 +49,16 @@ export function saveDraft({
   renderedHtml,
   renderedCss,
   editorContent,
+  baseRevisionId,
+  force,
 }) {
   return api.post(baseUrl + "save-draft", {
     pageId,
     renderedHtml,
     renderedCss,
     editorContent,
+    baseRevisionId,
+    force,
   });
 }
 
@@ -61,3 +67,199 @@ export function publishDraft({ draftId }) {
     draftId,
   });
 }
+
+export function updateSlug({ pageId, slug }) {
+  return api.post(baseUrl + "update-slug", {
+    pageId,
+    slug,
+  });
+}
+
+export function listRevisions({ pageId, page }) {
+  return api.post(baseUrl + "list-revisions", {
+    pageId,
+    page,
+  });
+}
+
+export function getRevision({ revisionId }) {
+  return api.post(baseUrl + "get-revision", {
+    revisionId,
+  });
+}
+
+export function compareRevisions({ fromRevisionId, toRevisionId }) {
+  return api.post(baseUrl + "compare-revisions", {
+    fromRevisionId,
+    toRevisionId,
+  });
+}
+
+export function restoreRevision({ revisionId }) {
+  return api.post(baseUrl + "restore-revision", {
+    revisionId,
+  });
+}
+
+export function scheduleDraft({ draftId, publishAt, expireAt }) {
+  return api.post(baseUrl + "schedule-draft", {
+    draftId,
+    publishAt,
+    expireAt,
+  });
+}
+
+export function listSchedules({ pageId }) {
+  return api.post(baseUrl + "list-schedules", {
+    pageId,
+  });
+}
+
+export function cancelSchedule({ scheduleId }) {
+  return api.post(baseUrl + "cancel-schedule", {
+    scheduleId,
+  });
+}
+
+export function unpublishPage({ pageId }) {
+  return api.post(baseUrl + "unpublish", {
+    pageId,
+  });
+}
+
+export function trashPage({ pageId }) {
+  return api.post(baseUrl + "trash", {
+    pageId,
+  });
+}
+
+export function listTrash({ page }) {
+  return api.post(baseUrl + "list-trash", {
+    page,
+  });
+}
+
+export function restorePage({ pageId }) {
+  return api.post(baseUrl + "restore", {
+    pageId,
+  });
+}
+
+export function purgePage({ pageId }) {
+  return api.post(baseUrl + "purge", {
+    pageId,
+  });
+}
+
+export function updateMetadata({
+  pageId,
+  title,
+  slug,
+  metaDescription,
+  canonicalUrl,
+  ogTitle,
+  ogDescription,
+  ogImage,
+  noIndex,
+  layout,
+}) {
+  return api.post(baseUrl + "update-metadata", {
+    pageId,
+    title,
+    slug,
+    metaDescription,
+    canonicalUrl,
+    ogTitle,
+    ogDescription,
+    ogImage,
+    noIndex,
+    layout,
+  });
+}
+
+export function acquireLock({ pageId }) {
+  return api.post(baseUrl + "acquire-lock", {
+    pageId,
+  });
+}
+
+export function heartbeatLock({ pageId }) {
+  return api.post(baseUrl + "heartbeat-lock", {
+    pageId,
+  });
+}
+
+export function releaseLock({ pageId }) {
+  return api.post(baseUrl + "release-lock", {
+    pageId,
+  });
+}
+
+export function breakLock({ pageId }) {
+  return api.post(baseUrl + "break-lock", {
+    pageId,
+  });
+}
+
+export function submitForReview({ revisionId, comment }) {
+  return api.post(baseUrl + "submit-for-review", {
+    revisionId,
+    comment,
+  });
+}
+
+export function requestChanges({ revisionId, comment }) {
+  return api.post(baseUrl + "request-changes", {
+    revisionId,
+    comment,
+  });
+}
+
+export function approveDraft({ revisionId, comment }) {
+  return api.post(baseUrl + "approve-draft", {
+    revisionId,
+    comment,
+  });
+}
+
+export function addReviewComment({ revisionId, comment }) {
+  return api.post(baseUrl + "add-review-comment", {
+    revisionId,
+    comment,
+  });
+}
+
+export function listReviewHistory({ pageId }) {
+  return api.post(baseUrl + "list-review-history", {
+    pageId,
+  });
+}
+
+export function movePage({ pageId, parentId }) {
+  return api.post(baseUrl + "move", {
+    pageId,
+    parentId,
+  });
+}
+
+export function getPageTree() {
+  return api.post(baseUrl + "get-page-tree", {});
+}
+
+export function listLayouts() {
+  return api.post(baseUrl + "list-layouts", {});
+}
+
+export function setPageTerms({ pageId, termIds }) {
+  return api.post(baseUrl + "set-terms", {
+    pageId,
+    termIds,
+  });
+}
+
+export function searchPages({ query, page }) {
+  return api.post(baseUrl + "search", {
+    query,
+    page,
+  });
+}
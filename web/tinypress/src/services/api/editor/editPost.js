@@ -0,0 +1,76 @@
+/*
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import api from "../api";
+
+// Posts are pages too, so everything else in editPage.js works with them as well
+const baseUrl = "/api/authed/v1/post-editor/";
+
+export function createPostWithDefaults() {
+  return createPost({
+    title: "New post",
+    renderedHtml: "",
+    renderedCss: "",
+    editorContent: "",
+  });
+}
+export function createPost({
+  title,
+  slug,
+  renderedHtml,
+  renderedCss,
+  editorContent,
+}) {
+  return api.post(baseUrl + "create", {
+    title,
+    slug,
+    renderedHtml,
+    renderedCss,
+    editorContent,
+  });
+}
+
+export function getPostWithDraft({ pageId }) {
+  return api.post(baseUrl + "get-post-with-draft", {
+    pageId,
+  });
+}
+
+export function updatePostDetails({
+  pageId,
+  postDate,
+  excerpt,
+  featuredImage,
+  authorId,
+}) {
+  return api.post(baseUrl + "update-post-details", {
+    pageId,
+    postDate,
+    excerpt,
+    featuredImage,
+    authorId,
+  });
+}
+
+export function listRecentlyEditedPosts({ page, termId }) {
+  return api.post(baseUrl + "list-recently-edited", {
+    page,
+    termId,
+  });
+}
+
+export function setPostTerms({ pageId, termIds }) {
+  return api.post(baseUrl + "set-terms", {
+    pageId,
+    termIds,
+  });
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2022 Philippe Gray
+
+This file is part of Tinypress.
+
+Tinypress is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Tinypress is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Tinypress. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import api from "../api";
+
+const baseUrl = "/api/authed/v1/media-library/";
+
+// Uploads come back as {data: [...]}, which is the shape the GrapesJS asset manager expects
+export function uploadMedia(files) {
+  const formData = new FormData();
+  for (const file of files) {
+    formData.append("files[]", file);
+  }
+  return api.post(baseUrl + "upload", formData);
+}
+export function listMedia({ query, page }) {
+  return api.post(baseUrl + "list-media", {
+    query,
+    page,
+  });
+}
+// Media that is still used fails with a 409 listing where, and only drafts and older revisions can be overridden with force
+export function deleteMedia({ mediaId, force }) {
+  return api.post(baseUrl + "delete-media", {
+    mediaId,
+    force,
+  });
+}
+export function getMediaUsage({ mediaId }) {
+  return api.post(baseUrl + "get-usage", {
+    mediaId,
+  });
+}
+// Lists media that nothing has used for at least the given number of days
+export function listOrphanedMedia({ days, page }) {
+  return api.post(baseUrl + "list-orphans", {
+    days,
+    page,
+  });
+}
@@ -25,6 +25,13 @@ export function setup({
   smtpUsername,
   smtpPassword,
   smtpPort,
+  storageBackend,
+  s3Endpoint,
+  s3Region,
+  s3Bucket,
+  s3AccessKey,
+  s3SecretKey,
+  s3UseSsl,
 }) {
   return api.post(baseUrl + "site-setup", {
     siteName,
@@ -37,5 +44,12 @@ export function setup({
     smtpUsername,
     smtpPassword,
     smtpPort,
+    storageBackend,
+    s3Endpoint,
+    s3Region,
+    s3Bucket,
+    s3AccessKey,
+    s3SecretKey,
+    s3UseSsl,
   });
 }